@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const maxRecentAlerts = 20
+
+type Alert struct {
+	Time     time.Time
+	URL      string
+	Severity string
+	Message  string
+}
+
+func (a Alert) String() string {
+	return fmt.Sprintf("%s [%s] %s: %s", a.Time.Format("15:04:05"), a.Severity, a.URL, a.Message)
+}
+
+// checkSLO evaluates the SLO of url and records an alert whenever its
+// burn-rate severity changes.
+func (m *Monitor) checkSLO(url string, now time.Time) {
+	m.statsMu.RLock()
+	stat := m.stats[url]
+	m.statsMu.RUnlock()
+
+	status, ok := stat.SLOStatus(now)
+	if !ok {
+		return
+	}
+
+	// Leave the state untouched while suppressed so that a burn which
+	// outlasts the maintenance window or silence still alerts afterwards.
+	if m.suppressed(url, now) {
+		return
+	}
+
+	m.alertsMu.Lock()
+	previous := m.burnState[url]
+	m.burnState[url] = status.Alert
+	m.alertsMu.Unlock()
+
+	if status.Alert == previous {
+		return
+	}
+
+	alert := Alert{Time: now, URL: url, Severity: status.Alert}
+	if status.Alert == BurnNone {
+		alert.Severity = "resolved"
+		alert.Message = "error budget burn rate back to normal"
+	} else {
+		alert.Message = fmt.Sprintf("error budget burning at %.1fx over the last hour, %.1f%% remaining",
+			status.BurnRate1h, status.BudgetRemaining*100)
+	}
+
+	m.recordAlert(alert)
+}
+
+// checkState records an alert and notifies when a target goes down or
+// recovers. Targets start out up, so the first successful check is not a
+// recovery.
+func (m *Monitor) checkState(url string, result CheckResult, now time.Time) {
+	// As with burn rates, leave the state untouched while suppressed so
+	// that an outage which outlasts the silence still notifies afterwards.
+	if m.suppressed(url, now) {
+		return
+	}
+
+	state := stateUp
+	if !result.Success {
+		state = stateDown
+	}
+
+	m.alertsMu.Lock()
+	previous, known := m.targetState[url]
+	m.targetState[url] = state
+	m.alertsMu.Unlock()
+
+	if state == previous || (!known && state == stateUp) {
+		return
+	}
+
+	event := m.stateEvent(url, state, result.Error, now)
+
+	alert := Alert{Time: now, URL: url, Severity: stateDown, Message: result.Error}
+	if state == stateUp {
+		alert.Severity = "recovered"
+		alert.Message = fmt.Sprintf("up again after %s", event.Incident.Duration(now).Round(time.Second))
+	}
+	m.recordAlert(alert)
+	if event.Down() {
+		m.openEscalation(event)
+	} else {
+		m.closeEscalation(event)
+	}
+	if m.hooks != nil {
+		m.hooks.trigger(event)
+	}
+}
+
+// stateEvent describes url at now for notifiers, including a snapshot of
+// its statistics, its latest incident and its last failed checks.
+func (m *Monitor) stateEvent(url, state, errorText string, now time.Time) StateEvent {
+	m.statsMu.RLock()
+	stat := m.stats[url]
+	target := m.targets[url]
+	m.statsMu.RUnlock()
+
+	snapshot := stat.GetSnapshot()
+	event := StateEvent{Time: now, URL: url, TargetID: target.ID, State: state, Error: errorText, Stats: &snapshot}
+	if incidents := stat.Incidents(); len(incidents) > 0 {
+		event.Incident = incidents[len(incidents)-1]
+	}
+	for _, check := range stat.RecentChecks() {
+		if !check.Success {
+			event.RecentErrors = append(event.RecentErrors, check)
+		}
+	}
+	event.RecentErrors = event.RecentErrors[max(0, len(event.RecentErrors)-5):]
+	return event
+}
+
+// contentChanged alerts on a content change unless it happened during
+// maintenance or while silenced, when changes are expected.
+func (m *Monitor) contentChanged(url string, now time.Time) {
+	if m.suppressed(url, now) {
+		return
+	}
+
+	m.recordAlert(Alert{
+		Time:     now,
+		URL:      url,
+		Severity: "change",
+		Message:  "response content changed unexpectedly",
+	})
+}
+
+func (m *Monitor) recordAlert(alert Alert) {
+	m.alertsMu.Lock()
+	defer m.alertsMu.Unlock()
+
+	m.appendAlert(alert)
+}
+
+// appendAlert adds an alert to the log. The caller holds m.alertsMu.
+func (m *Monitor) appendAlert(alert Alert) {
+	m.alerts = append(m.alerts, alert)
+	if len(m.alerts) > maxRecentAlerts {
+		m.alerts = m.alerts[len(m.alerts)-maxRecentAlerts:]
+	}
+}
+
+func (m *Monitor) recentAlerts() []Alert {
+	m.alertsMu.Lock()
+	defer m.alertsMu.Unlock()
+
+	return append([]Alert(nil), m.alerts...)
+}
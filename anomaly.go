@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+)
+
+const (
+	defaultAnomalyThreshold    = 3
+	defaultAnomalyAlpha        = 0.05
+	defaultAnomalyWarmup       = 30
+	defaultAnomalyMinDeviation = 50 * time.Millisecond
+	maxAnomalies               = 20
+)
+
+// AnomalyConfig enables latency anomaly detection. Every successful check
+// is compared with a baseline learned from the previous ones, an
+// exponentially weighted moving mean and variance, and is an anomaly when
+// it deviates from the mean by more than Threshold standard deviations and
+// at least MinDeviation. With HourOfDay checks are compared with the
+// baseline of their hour of the day once it has seen Warmup checks.
+type AnomalyConfig struct {
+	Threshold    float64  `json:"threshold,omitempty"`
+	Alpha        float64  `json:"alpha,omitempty"`
+	Warmup       int      `json:"warmup,omitempty"`
+	MinDeviation Duration `json:"min_deviation,omitempty"`
+	HourOfDay    bool     `json:"hour_of_day,omitempty"`
+}
+
+func (a *AnomalyConfig) validate() error {
+	if a.Threshold < 0 {
+		return fmt.Errorf("threshold must be positive, got %v", a.Threshold)
+	}
+	if a.Alpha < 0 || a.Alpha >= 1 {
+		return fmt.Errorf("alpha must be between 0 and 1, got %v", a.Alpha)
+	}
+	if a.Warmup < 0 {
+		return fmt.Errorf("warmup must not be negative, got %d", a.Warmup)
+	}
+	if a.MinDeviation.Duration < 0 {
+		return fmt.Errorf("min_deviation must not be negative, got %s", a.MinDeviation)
+	}
+
+	if a.Threshold == 0 {
+		a.Threshold = defaultAnomalyThreshold
+	}
+	if a.Alpha == 0 {
+		a.Alpha = defaultAnomalyAlpha
+	}
+	if a.Warmup == 0 {
+		a.Warmup = defaultAnomalyWarmup
+	}
+	if a.MinDeviation.Duration == 0 {
+		a.MinDeviation.Duration = defaultAnomalyMinDeviation
+	}
+	return nil
+}
+
+// ewma is an exponentially weighted moving mean and variance of latencies
+// in milliseconds.
+type ewma struct {
+	Mean     float64 `json:"mean_ms"`
+	Variance float64 `json:"variance_ms2"`
+	Count    int64   `json:"count"`
+}
+
+func (e *ewma) add(x, alpha float64) {
+	if e.Count == 0 {
+		e.Mean, e.Variance = x, 0
+	} else {
+		diff := x - e.Mean
+		incr := alpha * diff
+		e.Mean += incr
+		e.Variance = (1 - alpha) * (e.Variance + diff*incr)
+	}
+	e.Count++
+}
+
+func (e ewma) stddev() float64 {
+	return math.Sqrt(e.Variance)
+}
+
+// latencyBaseline is the learned latency of a target, overall and for each
+// hour of the day. Both are always kept up to date so that turning
+// HourOfDay on does not start from scratch.
+type latencyBaseline struct {
+	Overall ewma     `json:"overall"`
+	Hours   [24]ewma `json:"hours"`
+}
+
+// Anomaly is a check whose latency deviated from the baseline. Score is the
+// deviation in standard deviations, negative for unusually fast checks.
+type Anomaly struct {
+	Time     time.Time `json:"time"`
+	Duration Duration  `json:"duration"`
+	Mean     Duration  `json:"mean"`
+	Stddev   Duration  `json:"stddev"`
+	Score    float64   `json:"score"`
+	// Hour is the hour of the day whose baseline the check was compared
+	// with, or -1 for the overall baseline.
+	Hour int `json:"hour"`
+}
+
+func (a Anomaly) String() string {
+	direction := "above"
+	if a.Score < 0 {
+		direction = "below"
+	}
+	return fmt.Sprintf("latency %s is %.1fσ %s the baseline of %s ± %s",
+		a.Duration.Round(time.Millisecond), math.Abs(a.Score), direction,
+		a.Mean.Round(time.Millisecond), a.Stddev.Round(time.Millisecond))
+}
+
+func fromMilliseconds(ms float64) time.Duration {
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// observe compares a check with the baseline and then learns from it, so
+// that a lasting change in latency becomes the new normal.
+func (b *latencyBaseline) observe(cfg *AnomalyConfig, at time.Time, duration time.Duration) (Anomaly, bool) {
+	hour := at.Hour()
+	reference, referenceHour := b.Overall, -1
+	if cfg.HourOfDay && b.Hours[hour].Count >= int64(cfg.Warmup) {
+		reference, referenceHour = b.Hours[hour], hour
+	}
+
+	x := milliseconds(duration)
+	b.Overall.add(x, cfg.Alpha)
+	b.Hours[hour].add(x, cfg.Alpha)
+
+	if reference.Count < int64(cfg.Warmup) {
+		return Anomaly{}, false
+	}
+	deviation := x - reference.Mean
+	stddev := reference.stddev()
+	if math.Abs(deviation) < milliseconds(cfg.MinDeviation.Duration) || math.Abs(deviation) <= cfg.Threshold*stddev {
+		return Anomaly{}, false
+	}
+
+	// A perfectly steady baseline has no spread; score against a
+	// microsecond rather than dividing by zero.
+	score := deviation / max(stddev, 0.001)
+	return Anomaly{
+		Time:     at,
+		Duration: Duration{duration},
+		Mean:     Duration{fromMilliseconds(reference.Mean)},
+		Stddev:   Duration{fromMilliseconds(stddev)},
+		Score:    score,
+		Hour:     referenceHour,
+	}, true
+}
+
+// SetAnomaly enables anomaly detection with cfg.
+func (s *URLStats) SetAnomaly(cfg *AnomalyConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.anomaly = cfg
+	if s.baseline == nil {
+		s.baseline = &latencyBaseline{}
+	}
+}
+
+// detectAnomaly checks a result against the baseline. Failed checks are
+// left out, their latency says little and outages alert on their own. The
+// caller holds s.mu.
+func (s *URLStats) detectAnomaly(result CheckResult) bool {
+	if s.anomaly == nil || !result.Success {
+		return false
+	}
+
+	anomaly, ok := s.baseline.observe(s.anomaly, result.Time, result.Duration)
+	if !ok {
+		return false
+	}
+	s.Anomalies++
+	s.anomalies = append(s.anomalies, anomaly)
+	if len(s.anomalies) > maxAnomalies {
+		s.anomalies = s.anomalies[len(s.anomalies)-maxAnomalies:]
+	}
+	return true
+}
+
+// RecentAnomalies returns the most recent anomalies, oldest first.
+func (s *URLStats) RecentAnomalies() []Anomaly {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]Anomaly(nil), s.anomalies...)
+}
+
+// LastAnomaly returns the most recent anomaly, if there was one.
+func (s *URLStats) LastAnomaly() (Anomaly, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.anomalies) == 0 {
+		return Anomaly{}, false
+	}
+	return s.anomalies[len(s.anomalies)-1], true
+}
+
+// anomalyDetected alerts when the check just recorded for url was an
+// anomaly, unless the target is in maintenance or silenced.
+func (m *Monitor) anomalyDetected(url string, result CheckResult, now time.Time) {
+	m.statsMu.RLock()
+	stat := m.stats[url]
+	m.statsMu.RUnlock()
+
+	anomaly, ok := stat.LastAnomaly()
+	if !ok || !anomaly.Time.Equal(result.Time) || m.suppressed(url, now) {
+		return
+	}
+	m.recordAlert(Alert{Time: now, URL: url, Severity: "anomaly", Message: anomaly.String()})
+}
+
+// AnomalyRecord is an anomaly of one target, as listed by the API.
+type AnomalyRecord struct {
+	URL      string `json:"url"`
+	TargetID string `json:"target_id"`
+	Anomaly
+}
+
+// Anomalies returns the recent anomalies of every target, or only of url
+// when it is not empty, ordered by time.
+func (m *Monitor) Anomalies(url string) []AnomalyRecord {
+	m.statsMu.RLock()
+	defer m.statsMu.RUnlock()
+
+	records := []AnomalyRecord{}
+	for _, u := range m.urls {
+		if url != "" && u != url {
+			continue
+		}
+		for _, anomaly := range m.stats[u].RecentAnomalies() {
+			records = append(records, AnomalyRecord{URL: u, TargetID: m.targets[u].ID, Anomaly: anomaly})
+		}
+	}
+	slices.SortStableFunc(records, func(a, b AnomalyRecord) int { return a.Time.Compare(b.Time) })
+	return records
+}
+
+func (m *Monitor) handleListAnomalies(w http.ResponseWriter, r *http.Request) {
+	url := r.URL.Query().Get("url")
+	if url != "" {
+		m.statsMu.RLock()
+		_, ok := m.stats[url]
+		m.statsMu.RUnlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("unknown target '%s'", url))
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, m.Anomalies(url))
+}
+
+// baselineFile is the content of the baseline file.
+type baselineFile struct {
+	Targets map[string]*latencyBaseline `json:"targets"`
+}
+
+// loadBaselines restores the baselines saved by a previous run. A missing
+// baseline file is not an error.
+func (m *Monitor) loadBaselines() error {
+	data, err := os.ReadFile(m.baselineFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading baselines: %v", err)
+	}
+
+	var saved baselineFile
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("parsing baselines '%s': %v", m.baselineFile, err)
+	}
+
+	m.statsMu.RLock()
+	defer m.statsMu.RUnlock()
+
+	for url, baseline := range saved.Targets {
+		stat, ok := m.stats[url]
+		if !ok || baseline == nil {
+			continue
+		}
+		stat.mu.Lock()
+		if stat.anomaly != nil {
+			stat.baseline = baseline
+		}
+		stat.mu.Unlock()
+	}
+	return nil
+}
+
+// SaveBaselines writes the baselines of every target with anomaly
+// detection to the baseline file, so that the next run need not learn them
+// again.
+func (m *Monitor) SaveBaselines() error {
+	if m.baselineFile == "" {
+		return nil
+	}
+
+	saved := baselineFile{Targets: make(map[string]*latencyBaseline)}
+	m.statsMu.RLock()
+	for url, stat := range m.stats {
+		stat.mu.RLock()
+		if stat.baseline != nil {
+			baseline := *stat.baseline
+			saved.Targets[url] = &baseline
+		}
+		stat.mu.RUnlock()
+	}
+	m.statsMu.RUnlock()
+
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding baselines: %v", err)
+	}
+	if err := writeFileAtomic(m.baselineFile, data); err != nil {
+		return fmt.Errorf("writing baselines: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	defaultApdexT = 500 * time.Millisecond
+	// apdexWindow is the rolling window shown next to the lifetime score.
+	apdexWindow = time.Hour
+)
+
+// apdexCounts classifies a check against the Apdex threshold t: satisfied
+// within t, tolerating within 4t and frustrated otherwise or when it
+// failed.
+func apdexCounts(success bool, duration, t time.Duration) (satisfied, tolerating int64) {
+	switch {
+	case !success:
+	case duration <= t:
+		satisfied = 1
+	case duration <= 4*t:
+		tolerating = 1
+	}
+	return satisfied, tolerating
+}
+
+// apdexScore is (satisfied + tolerating/2) / total. It is false when there
+// were no checks, since the score is undefined then.
+func apdexScore(satisfied, tolerating, total int64) (float64, bool) {
+	if total == 0 {
+		return 0, false
+	}
+	return (float64(satisfied) + float64(tolerating)/2) / float64(total), true
+}
+
+// Apdex is the score over every recorded check.
+func (s *URLStats) Apdex() (float64, bool) {
+	return apdexScore(s.ApdexSatisfied, s.ApdexTolerating, s.TotalRequests)
+}
+
+// Apdex is the score over the checks counted in b.
+func (b windowBucket) Apdex() (float64, bool) {
+	return apdexScore(b.Satisfied, b.Tolerating, b.Total)
+}
+
+// SetApdexT sets the threshold used to score later checks.
+func (s *URLStats) SetApdexT(t time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ApdexT = t
+}
+
+func formatApdex(score float64, ok bool) string {
+	if !ok {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f", score)
+}
+
+// apdexRank sorts targets without a score before the worst scores.
+func apdexRank(score float64, ok bool) float64 {
+	if !ok {
+		return -1
+	}
+	return score
+}
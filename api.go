@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Handler returns the control API. It is only served when -listen is set.
+func (m *Monitor) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /api/silences", m.handleListSilences)
+	mux.HandleFunc("POST /api/silences", m.handleCreateSilence)
+	mux.HandleFunc("DELETE /api/silences/{id}", m.handleDeleteSilence)
+	mux.HandleFunc("GET /api/changes", m.handleListChanges)
+	mux.HandleFunc("GET /api/changes/diff", m.handleChangeDiff)
+	mux.HandleFunc("GET /api/targets", m.handleListTargets)
+	mux.HandleFunc("GET /api/exporters", m.handleListExporters)
+	mux.HandleFunc("GET /api/notifiers", m.handleListNotifiers)
+	mux.HandleFunc("GET /api/hooks", m.handleListHooks)
+	mux.HandleFunc("GET /api/rules", m.handleListRules)
+	mux.HandleFunc("GET /api/escalations", m.handleListEscalations)
+	mux.HandleFunc("GET /api/incidents", m.handleListIncidents)
+	mux.HandleFunc("GET /api/anomalies", m.handleListAnomalies)
+	mux.HandleFunc("GET /api/checks", m.handleListChecks)
+	mux.HandleFunc("GET /api/har", m.handleHAR)
+	mux.HandleFunc("POST /api/escalations/{id}/ack", m.handleAcknowledge)
+	mux.HandleFunc("POST /api/escalations/{id}/resolve", m.handleResolve)
+	mux.HandleFunc("GET /badges/{id}/{badge}", m.handleBadge)
+
+	return mux
+}
+
+// Serve runs the control API on addr until ctx is cancelled.
+func (m *Monitor) Serve(ctx context.Context, wg *sync.WaitGroup, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("control API: %v", err)
+	}
+
+	server := &http.Server{
+		Handler:           m.Handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go server.Serve(listener)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	return nil
+}
+
+func (m *Monitor) handleListSilences(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, m.Silences(time.Now()))
+}
+
+type silenceRequest struct {
+	URL      string   `json:"url"`
+	Reason   string   `json:"reason"`
+	Duration Duration `json:"duration"`
+}
+
+func (m *Monitor) handleCreateSilence(w http.ResponseWriter, r *http.Request) {
+	var req silenceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+		return
+	}
+
+	if req.Duration.Duration <= 0 {
+		writeError(w, http.StatusBadRequest, errors.New("duration must be positive"))
+		return
+	}
+
+	if req.URL != "" {
+		m.statsMu.RLock()
+		_, known := m.stats[req.URL]
+		m.statsMu.RUnlock()
+		if !known {
+			writeError(w, http.StatusNotFound, fmt.Errorf("unknown target '%s'", req.URL))
+			return
+		}
+	}
+
+	now := time.Now()
+	silence := m.AddSilence(Silence{
+		URL:     req.URL,
+		Reason:  req.Reason,
+		Created: now,
+		Until:   now.Add(req.Duration.Duration),
+	})
+
+	writeJSON(w, http.StatusCreated, silence)
+}
+
+func (m *Monitor) handleDeleteSilence(w http.ResponseWriter, r *http.Request) {
+	if !m.RemoveSilence(r.PathValue("id")) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown silence '%s'", r.PathValue("id")))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// targetStats looks up the stats for the url query parameter.
+func (m *Monitor) targetStats(w http.ResponseWriter, r *http.Request) (*URLStats, bool) {
+	url := r.URL.Query().Get("url")
+
+	m.statsMu.RLock()
+	stat, ok := m.stats[url]
+	m.statsMu.RUnlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown target '%s'", url))
+	}
+	return stat, ok
+}
+
+func (m *Monitor) handleListChanges(w http.ResponseWriter, r *http.Request) {
+	stat, ok := m.targetStats(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, stat.RecentChanges())
+}
+
+// handleChangeDiff returns a unified diff for one change, the latest unless
+// the index query parameter selects an older one from /api/changes.
+func (m *Monitor) handleChangeDiff(w http.ResponseWriter, r *http.Request) {
+	stat, ok := m.targetStats(w, r)
+	if !ok {
+		return
+	}
+
+	changes := stat.RecentChanges()
+	if len(changes) == 0 {
+		writeError(w, http.StatusNotFound, errors.New("no content changes recorded"))
+		return
+	}
+
+	index := len(changes) - 1
+	if s := r.URL.Query().Get("index"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n >= len(changes) {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("index must be between 0 and %d", len(changes)-1))
+			return
+		}
+		index = n
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, changes[index].Diff(stat.URL))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
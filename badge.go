@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var targetIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// defaultTargetID derives a readable ID from the host and path of a URL,
+// e.g. "example-com-health" for https://example.com/health.
+func defaultTargetID(rawURL string) string {
+	id := rawURL
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		id = u.Host + u.Path
+	}
+	id = strings.Trim(nonIDChars.ReplaceAllString(strings.ToLower(id), "-"), "-")
+	if id == "" {
+		return "target"
+	}
+	return id
+}
+
+var nonIDChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// assignTargetIDs gives every target an ID that is stable across restarts.
+// Configured IDs are kept; every derived ID that clashes with another
+// target gets a short hash of its URL appended, so that the IDs do not
+// depend on the order of the targets.
+func (m *Monitor) assignTargetIDs() {
+	m.ids = make(map[string]string)
+	derived := make(map[string]int)
+	for _, url := range m.urls {
+		if id := m.targets[url].ID; id != "" {
+			m.ids[id] = url
+		} else {
+			derived[defaultTargetID(url)]++
+		}
+	}
+
+	for _, url := range m.urls {
+		target := m.targets[url]
+		if target.ID != "" {
+			continue
+		}
+		id := defaultTargetID(url)
+		if _, taken := m.ids[id]; taken || derived[id] > 1 {
+			sum := sha256.Sum256([]byte(url))
+			id += "-" + hex.EncodeToString(sum[:3])
+		}
+		target.ID = id
+		m.targets[url] = target
+		m.ids[id] = url
+	}
+}
+
+type targetInfo struct {
+	ID     string            `json:"id"`
+	URL    string            `json:"url"`
+	Badges map[string]string `json:"badges"`
+}
+
+func (m *Monitor) handleListTargets(w http.ResponseWriter, r *http.Request) {
+	var targets []targetInfo
+	for _, url := range m.urls {
+		id := m.targets[url].ID
+		badges := make(map[string]string)
+		for _, badge := range badgeKinds {
+			badges[badge] = "/badges/" + id + "/" + badge + ".svg"
+		}
+		targets = append(targets, targetInfo{ID: id, URL: url, Badges: badges})
+	}
+	writeJSON(w, http.StatusOK, targets)
+}
+
+var badgeKinds = []string{"status", "uptime", "response"}
+
+const (
+	badgeGreen  = "#4c1"
+	badgeLime   = "#97ca00"
+	badgeYellow = "#dfb317"
+	badgeRed    = "#e05d44"
+	badgeGrey   = "#9f9f9f"
+)
+
+// handleBadge serves /badges/{id}/{badge}.svg. The uptime badge covers the
+// whole run unless the window query parameter limits it, e.g. window=24h.
+// The label query parameter replaces the default label.
+func (m *Monitor) handleBadge(w http.ResponseWriter, r *http.Request) {
+	url, ok := m.ids[r.PathValue("id")]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown target '%s'", r.PathValue("id")), http.StatusNotFound)
+		return
+	}
+
+	var row tableRow
+	for _, candidate := range m.tableRows() {
+		if candidate.url == url {
+			row = candidate
+		}
+	}
+
+	kind := strings.TrimSuffix(r.PathValue("badge"), ".svg")
+	var label, message, color string
+	switch kind {
+	case "status":
+		label, message = "status", row.state
+		color = map[string]string{stateUp: badgeGreen, stateDegraded: badgeYellow, stateDown: badgeRed}[row.state]
+	case "uptime":
+		label = "uptime"
+		success, total := row.snapshot.SuccessCount, row.snapshot.TotalRequests
+		if s := r.URL.Query().Get("window"); s != "" {
+			window, err := parseDuration(s)
+			if err != nil || window <= 0 || window > statusPageDays*24*time.Hour {
+				http.Error(w, fmt.Sprintf("window must be a duration up to %dd", statusPageDays), http.StatusBadRequest)
+				return
+			}
+			m.statsMu.RLock()
+			stat := m.stats[url]
+			m.statsMu.RUnlock()
+			counts := stat.Window(time.Now(), window)
+			success, total = counts.Success, counts.Total
+			label += " " + formatWindow(window)
+		}
+		message = "-"
+		if total > 0 {
+			uptime := percent(success, total)
+			message = fmt.Sprintf("%.2f%%", uptime)
+			color = map[string]string{stateUp: badgeGreen, "minor": badgeLime, stateDegraded: badgeYellow, stateDown: badgeRed}[uptimeClass(uptime)]
+		}
+	case "response":
+		label, message = "response", formatDuration(row.snapshot.AverageDuration())
+		switch avg := row.snapshot.AverageDuration(); {
+		case avg == 0:
+		case avg < 300*time.Millisecond:
+			color = badgeGreen
+		case avg < time.Second:
+			color = badgeYellow
+		default:
+			color = badgeRed
+		}
+	default:
+		http.Error(w, fmt.Sprintf("unknown badge '%s', expected one of %s", kind, strings.Join(badgeKinds, ", ")), http.StatusNotFound)
+		return
+	}
+
+	if custom := r.URL.Query().Get("label"); custom != "" {
+		label = custom
+	}
+	if color == "" {
+		color = badgeGrey
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	// Badges are embedded in READMEs behind caching proxies.
+	w.Header().Set("Cache-Control", "no-cache, max-age=0")
+	writeBadge(w, label, message, color)
+}
+
+type badgeData struct {
+	Label, Message, Color    string
+	LabelWidth, MessageWidth int
+	Width, LabelX, MessageX  int
+}
+
+// badgeTextWidth approximates the width of text in 11px Verdana.
+func badgeTextWidth(s string) int {
+	return len([]rune(s))*7 + 10
+}
+
+func writeBadge(w io.Writer, label, message, color string) error {
+	data := badgeData{
+		Label:        label,
+		Message:      message,
+		Color:        color,
+		LabelWidth:   badgeTextWidth(label),
+		MessageWidth: badgeTextWidth(message),
+	}
+	data.Width = data.LabelWidth + data.MessageWidth
+	data.LabelX = data.LabelWidth * 10 / 2
+	data.MessageX = (data.LabelWidth + data.MessageWidth/2) * 10
+	return badgeTemplate.Execute(w, data)
+}
+
+var badgeTemplate = template.Must(template.New("badge").Parse(`<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="20" role="img" aria-label="{{.Label}}: {{.Message}}">
+<title>{{.Label}}: {{.Message}}</title>
+<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="{{.Width}}" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)"><rect width="{{.LabelWidth}}" height="20" fill="#555"/><rect x="{{.LabelWidth}}" width="{{.MessageWidth}}" height="20" fill="{{.Color}}"/><rect width="{{.Width}}" height="20" fill="url(#s)"/></g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="110">
+<text x="{{.LabelX}}" y="150" fill="#010101" fill-opacity=".3" transform="scale(.1)">{{.Label}}</text><text x="{{.LabelX}}" y="140" transform="scale(.1)">{{.Label}}</text>
+<text x="{{.MessageX}}" y="150" fill="#010101" fill-opacity=".3" transform="scale(.1)">{{.Message}}</text><text x="{{.MessageX}}" y="140" transform="scale(.1)">{{.Message}}</text>
+</g>
+</svg>
+`))
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const defaultMaxBodyBytes = 10 * 1024 * 1024
+
+// bodyInfo describes a response body that was streamed rather than held in
+// memory.
+type bodyInfo struct {
+	Size      int64
+	Hash      string
+	Truncated bool
+}
+
+// readBody consumes at most limit bytes of r, hashing them with SHA-256
+// when withHash is set. Reading stops at the limit and the body is marked
+// truncated if there was more to read.
+func readBody(r io.Reader, limit int64, withHash bool) (bodyInfo, error) {
+	var info bodyInfo
+
+	var h hash.Hash
+	dst := io.Discard
+	if withHash {
+		h = sha256.New()
+		dst = h
+	}
+
+	n, err := io.Copy(dst, io.LimitReader(r, limit))
+	info.Size = n
+	if err != nil {
+		return info, err
+	}
+
+	if n == limit {
+		var probe [1]byte
+		if m, _ := r.Read(probe[:]); m > 0 {
+			info.Truncated = true
+		}
+	}
+
+	if h != nil {
+		info.Hash = hex.EncodeToString(h.Sum(nil))
+	}
+	return info, nil
+}
+
+// parseSize parses a byte count such as "512", "64KB" or "10MB".
+func parseSize(input string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(input))
+
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{
+		{"GB", 1024 * 1024 * 1024},
+		{"MB", 1024 * 1024},
+		{"KB", 1024},
+		{"B", 1},
+	} {
+		if number, ok := strings.CutSuffix(s, unit.suffix); ok {
+			s, multiplier = strings.TrimSpace(number), unit.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", input)
+	}
+	if n > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("size '%s' is too large", input)
+	}
+	return n * multiplier, nil
+}
+
+// Size is a byte count that reads from JSON strings like "10MB" or plain
+// numbers, and from flags.
+type Size int64
+
+func (s *Size) UnmarshalJSON(data []byte) error {
+	var n int64
+	if err := json.Unmarshal(data, &n); err == nil {
+		*s = Size(n)
+		return nil
+	}
+
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return fmt.Errorf("size must be a number of bytes or a string like \"10MB\"")
+	}
+	return s.Set(str)
+}
+
+func (s *Size) Set(value string) error {
+	n, err := parseSize(value)
+	if err != nil {
+		return err
+	}
+	*s = Size(n)
+	return nil
+}
+
+func (s Size) String() string {
+	return formatSize(int64(s))
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	Targets     []TargetConfig       `json:"targets"`
+	SLO         *SLO                 `json:"slo,omitempty"`
+	Anomaly     *AnomalyConfig       `json:"anomaly,omitempty"`
+	Maintenance []*MaintenanceWindow `json:"maintenance,omitempty"`
+	Silences    []Silence            `json:"-"`
+	Listen      string               `json:"listen,omitempty"`
+	Interactive bool                 `json:"-"`
+	Color       bool                 `json:"-"`
+	Columns     []string             `json:"columns,omitempty"`
+	Format      string               `json:"format,omitempty"`
+
+	ReportFile   string `json:"report_file,omitempty"`
+	ReportFormat string `json:"report_format,omitempty"`
+	IncidentLog  string `json:"incident_log,omitempty"`
+	BaselineFile string `json:"baseline_file,omitempty"`
+	HARFile      string `json:"har_file,omitempty"`
+
+	Snapshots *SnapshotConfig `json:"snapshots,omitempty"`
+
+	StatusPage *StatusPage      `json:"status_page,omitempty"`
+	Exporters  []ExporterConfig `json:"exporters,omitempty"`
+	OTLP       *OTLPConfig      `json:"otlp,omitempty"`
+	Notifiers  []NotifierConfig `json:"notifiers,omitempty"`
+	Escalation []EscalationStep `json:"escalation,omitempty"`
+	Hooks      []HookConfig     `json:"hooks,omitempty"`
+
+	AlertRules   []*AlertRule `json:"alert_rules,omitempty"`
+	RuleInterval Duration     `json:"rule_interval,omitempty"`
+
+	MaxInFlight int      `json:"max_in_flight,omitempty"`
+	HostRate    float64  `json:"host_rate,omitempty"`
+	StartJitter Duration `json:"start_jitter,omitempty"`
+
+	MaxBodySize Size `json:"max_body_size,omitempty"`
+	HashBody    bool `json:"hash_body,omitempty"`
+
+	DetectChanges  bool     `json:"detect_changes,omitempty"`
+	IgnorePatterns []string `json:"ignore_patterns,omitempty"`
+
+	// RecentChecks is how many check results are kept per target for the
+	// API, the drill-down and the final report, at most maxRecentChecks.
+	RecentChecks int `json:"recent_checks,omitempty"`
+
+	// ApdexT is the Apdex threshold: checks within it satisfy, within
+	// four times it tolerate.
+	ApdexT Duration `json:"apdex_t,omitempty"`
+}
+
+type TargetConfig struct {
+	URL string `json:"url"`
+	// ID identifies the target in API and badge paths. It defaults to a
+	// slug of the URL's host and path.
+	ID          string               `json:"id,omitempty"`
+	SLO         *SLO                 `json:"slo,omitempty"`
+	Anomaly     *AnomalyConfig       `json:"anomaly,omitempty"`
+	Maintenance []*MaintenanceWindow `json:"maintenance,omitempty"`
+
+	// Method is GET or HEAD. HEAD checks and checks with SkipBody set
+	// never read the response body.
+	Method      string `json:"method,omitempty"`
+	SkipBody    bool   `json:"skip_body,omitempty"`
+	MaxBodySize Size   `json:"max_body_size,omitempty"`
+	HashBody    bool   `json:"hash_body,omitempty"`
+
+	// DetectChanges records an event whenever the body differs from the
+	// previous one once every IgnorePatterns match has been removed.
+	DetectChanges  bool     `json:"detect_changes,omitempty"`
+	IgnorePatterns []string `json:"ignore_patterns,omitempty"`
+
+	ApdexT Duration `json:"apdex_t,omitempty"`
+
+	ignore []*regexp.Regexp
+}
+
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %v", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing config '%s': %v", path, err)
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+func (c *Config) validate() error {
+	if c.SLO != nil {
+		if err := c.SLO.validate(); err != nil {
+			return fmt.Errorf("default slo: %v", err)
+		}
+	}
+	if c.Anomaly != nil {
+		if err := c.Anomaly.validate(); err != nil {
+			return fmt.Errorf("default anomaly: %v", err)
+		}
+	}
+
+	if c.MaxBodySize < 0 {
+		return fmt.Errorf("max_body_size must not be negative")
+	}
+	if c.MaxInFlight < 0 {
+		return fmt.Errorf("max_in_flight must not be negative")
+	}
+	if c.HostRate < 0 {
+		return fmt.Errorf("host_rate must not be negative")
+	}
+	if c.StartJitter.Duration < 0 {
+		return fmt.Errorf("start_jitter must not be negative")
+	}
+	if c.ApdexT.Duration < 0 {
+		return fmt.Errorf("apdex_t must not be negative")
+	}
+	if c.RecentChecks < 0 || c.RecentChecks > maxRecentChecks {
+		return fmt.Errorf("recent_checks must be between 0 and %d, got %d", maxRecentChecks, c.RecentChecks)
+	}
+	if c.RecentChecks == 0 {
+		c.RecentChecks = defaultRecentChecks
+	}
+
+	for i, w := range c.Maintenance {
+		if err := w.validate(); err != nil {
+			return fmt.Errorf("maintenance window %d: %v", i+1, err)
+		}
+	}
+
+	if _, err := compilePatterns(c.IgnorePatterns); err != nil {
+		return err
+	}
+
+	if _, err := lookupColumns(c.Columns); err != nil {
+		return err
+	}
+	if _, err := newRenderer(c.Format, nil, false); err != nil {
+		return err
+	}
+	if c.ReportFile != "" {
+		if _, err := reportFormat(c.ReportFile, c.ReportFormat); err != nil {
+			return err
+		}
+	}
+
+	if c.Snapshots != nil {
+		if err := c.Snapshots.validate(); err != nil {
+			return fmt.Errorf("snapshots: %v", err)
+		}
+	}
+
+	if c.StatusPage != nil {
+		if err := c.StatusPage.validate(); err != nil {
+			return fmt.Errorf("status_page: %v", err)
+		}
+	}
+
+	for i := range c.Exporters {
+		if err := c.Exporters[i].validate(); err != nil {
+			return fmt.Errorf("exporter %d: %v", i+1, err)
+		}
+	}
+	if err := nameExporters(c.Exporters); err != nil {
+		return err
+	}
+
+	if c.OTLP != nil {
+		if err := c.OTLP.validate(); err != nil {
+			return fmt.Errorf("otlp: %v", err)
+		}
+	}
+
+	for i := range c.Notifiers {
+		if err := c.Notifiers[i].validate(); err != nil {
+			return fmt.Errorf("notifier %d: %v", i+1, err)
+		}
+	}
+
+	if len(c.Escalation) > 0 {
+		if err := validateEscalation(c.Escalation, c.Notifiers); err != nil {
+			return err
+		}
+	}
+
+	for i := range c.Hooks {
+		if err := c.Hooks[i].validate(); err != nil {
+			return fmt.Errorf("hook %d: %v", i+1, err)
+		}
+	}
+
+	names := make(map[string]bool)
+	for i, rule := range c.AlertRules {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("alert rule %d: %v", i+1, err)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("alert rule name '%s' is used more than once", rule.Name)
+		}
+		names[rule.Name] = true
+	}
+	if c.RuleInterval.Duration == 0 {
+		c.RuleInterval.Duration = defaultRuleInterval
+	}
+	if c.RuleInterval.Duration < time.Second {
+		return fmt.Errorf("rule_interval must be at least 1s, got %s", c.RuleInterval)
+	}
+
+	ids := make(map[string]bool)
+	for i, target := range c.Targets {
+		if target.URL == "" {
+			return fmt.Errorf("target %d has no url", i+1)
+		}
+		if target.ID != "" {
+			if !targetIDPattern.MatchString(target.ID) {
+				return fmt.Errorf("target '%s' id '%s' may only contain letters, digits, '-' and '_'", target.URL, target.ID)
+			}
+			if ids[target.ID] {
+				return fmt.Errorf("target id '%s' is used more than once", target.ID)
+			}
+			ids[target.ID] = true
+		}
+		if target.SLO != nil {
+			if err := target.SLO.validate(); err != nil {
+				return fmt.Errorf("target '%s' slo: %v", target.URL, err)
+			}
+		}
+		if target.Anomaly != nil {
+			if err := target.Anomaly.validate(); err != nil {
+				return fmt.Errorf("target '%s' anomaly: %v", target.URL, err)
+			}
+		}
+		if _, err := compilePatterns(target.IgnorePatterns); err != nil {
+			return fmt.Errorf("target '%s': %v", target.URL, err)
+		}
+		if target.MaxBodySize < 0 {
+			return fmt.Errorf("target '%s' max_body_size must not be negative", target.URL)
+		}
+		if target.ApdexT.Duration < 0 {
+			return fmt.Errorf("target '%s' apdex_t must not be negative", target.URL)
+		}
+		switch target.Method {
+		case "", http.MethodGet, http.MethodHead:
+		default:
+			return fmt.Errorf("target '%s' method must be GET or HEAD, got '%s'", target.URL, target.Method)
+		}
+		for j, w := range target.Maintenance {
+			if err := w.validate(); err != nil {
+				return fmt.Errorf("target '%s' maintenance window %d: %v", target.URL, j+1, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// targetConfig returns the configuration for url, falling back to the
+// global defaults for anything the target does not set itself.
+func (c *Config) targetConfig(url string) TargetConfig {
+	target := TargetConfig{URL: url}
+	for _, t := range c.Targets {
+		if t.URL == url {
+			target = t
+			break
+		}
+	}
+
+	if target.SLO == nil && c.SLO != nil {
+		slo := *c.SLO
+		target.SLO = &slo
+	}
+	if target.Anomaly == nil && c.Anomaly != nil {
+		anomaly := *c.Anomaly
+		target.Anomaly = &anomaly
+	}
+
+	if target.Method == "" {
+		target.Method = http.MethodGet
+	}
+	if target.MaxBodySize == 0 {
+		target.MaxBodySize = c.MaxBodySize
+	}
+	if target.MaxBodySize == 0 {
+		target.MaxBodySize = defaultMaxBodyBytes
+	}
+	target.HashBody = target.HashBody || c.HashBody
+	if target.ApdexT.Duration == 0 {
+		target.ApdexT = c.ApdexT
+	}
+	if target.ApdexT.Duration == 0 {
+		target.ApdexT.Duration = defaultApdexT
+	}
+
+	target.DetectChanges = target.DetectChanges || c.DetectChanges
+	patterns := append(append([]string(nil), c.IgnorePatterns...), target.IgnorePatterns...)
+	// Patterns were checked by validate.
+	target.ignore, _ = compilePatterns(patterns)
+
+	target.Maintenance = append([]*MaintenanceWindow(nil), target.Maintenance...)
+	for _, w := range c.Maintenance {
+		if w.appliesTo(url) {
+			target.Maintenance = append(target.Maintenance, w)
+		}
+	}
+
+	return target
+}
+
+func (c *Config) urls() []string {
+	var urls []string
+	for _, target := range c.Targets {
+		urls = append(urls, target.URL)
+	}
+	return urls
+}
+
+// Duration is a time.Duration that reads from JSON strings and flags. On top
+// of the units understood by time.ParseDuration it accepts a "d" suffix for
+// days, since SLO windows are usually expressed that way.
+type Duration struct {
+	time.Duration
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration '%s'", s)
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration '%s'", s)
+	}
+	return d, nil
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"300ms\" or \"30d\"")
+	}
+
+	parsed, err := parseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) Set(s string) error {
+	parsed, err := parseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
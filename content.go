@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// Only the most recent content changes are kept per target, since each
+	// one holds two bodies.
+	maxContentChanges = 5
+	// maxDiffContent is how much of each body is kept for diffs. Change
+	// detection still hashes the whole body.
+	maxDiffContent = 64 * 1024
+	// maxDiffCells bounds the LCS table of a diff, about 2MB. Larger
+	// changes are shown as a single replaced hunk.
+	maxDiffCells = 250_000
+)
+
+// ContentChange records a successful response whose body differs from the
+// one before it, after dynamic regions have been stripped.
+type ContentChange struct {
+	Time    time.Time `json:"time"`
+	OldHash string    `json:"old_hash"`
+	NewHash string    `json:"new_hash"`
+	// Truncated is set when either body was longer than maxDiffContent
+	// and only its start is diffed.
+	Truncated bool   `json:"truncated,omitempty"`
+	Previous  []byte `json:"-"`
+	Current   []byte `json:"-"`
+}
+
+// Diff returns a unified diff between the previous and current body.
+func (c ContentChange) Diff(url string) string {
+	diff := unifiedDiff(url+" (previous)", url+" (current)", string(c.Previous), string(c.Current))
+	if c.Truncated {
+		diff += fmt.Sprintf("[bodies truncated at %s]\n", formatSize(maxDiffContent))
+	}
+	return diff
+}
+
+// diffContent returns the part of body kept for diffs, copied so that the
+// full body is not held on to.
+func diffContent(body []byte) (content []byte, truncated bool) {
+	if body == nil {
+		return nil, false
+	}
+	return bytes.Clone(body[:min(len(body), maxDiffContent)]), len(body) > maxDiffContent
+}
+
+// readBodyContent is readBody that also keeps the bytes that were read.
+func readBodyContent(r io.Reader, limit int64, withHash bool) ([]byte, bodyInfo, error) {
+	var buf bytes.Buffer
+	info, err := readBody(io.TeeReader(r, &buf), limit, withHash)
+	// The truncation probe may have read one byte past the limit.
+	return buf.Bytes()[:info.Size], info, err
+}
+
+// contentHash hashes body after removing every match of the ignore
+// patterns, so that timestamps, nonces and similar dynamic regions do not
+// register as changes.
+func contentHash(body []byte, ignore []*regexp.Regexp) string {
+	for _, re := range ignore {
+		body = re.ReplaceAll(body, nil)
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ignore pattern '%s': %v", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// unifiedDiff produces a line-based unified diff with three lines of
+// context. Very large inputs are reported as a single replaced hunk rather
+// than diffed line by line.
+func unifiedDiff(fromName, toName, from, to string) string {
+	a := splitLines(from)
+	b := splitLines(to)
+
+	ops := diffLines(a, b)
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+
+	const context = 3
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		// Extend the hunk over changes separated by little context.
+		start := max(0, i-context)
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next == len(ops) || next-end > 2*context {
+				end = min(len(ops), end+context)
+				break
+			}
+			end = next
+		}
+
+		aStart, bStart := ops[start].aLine, ops[start].bLine
+		var aCount, bCount int
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", aStart+1, aCount, bStart+1, bCount)
+		for _, op := range ops[start:end] {
+			fmt.Fprintf(&out, "%c%s\n", op.kind, op.text)
+		}
+
+		i = end
+	}
+
+	return out.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+type diffOp struct {
+	kind         byte
+	text         string
+	aLine, bLine int
+}
+
+// diffLines computes an edit script using the longest common subsequence
+// of the lines between the common prefix and suffix.
+func diffLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var ops []diffOp
+	for i := 0; i < prefix; i++ {
+		ops = append(ops, diffOp{' ', a[i], i, i})
+	}
+
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(midA)*len(midB) > maxDiffCells {
+		for i, line := range midA {
+			ops = append(ops, diffOp{'-', line, prefix + i, prefix})
+		}
+		for j, line := range midB {
+			ops = append(ops, diffOp{'+', line, prefix + len(midA), prefix + j})
+		}
+	} else {
+		ops = append(ops, lcsDiff(midA, midB, prefix)...)
+	}
+
+	for i := 0; i < suffix; i++ {
+		ai, bi := len(a)-suffix+i, len(b)-suffix+i
+		ops = append(ops, diffOp{' ', a[ai], ai, bi})
+	}
+	return ops
+}
+
+func lcsDiff(a, b []string, offset int) []diffOp {
+	// lengths[i][j] is the LCS length of a[i:] and b[j:].
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i], offset + i, offset + j})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lengths[i+1][j] >= lengths[i][j+1]):
+			ops = append(ops, diffOp{'-', a[i], offset + i, offset + j})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j], offset + i, offset + j})
+			j++
+		}
+	}
+	return ops
+}
@@ -2,63 +2,316 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"strings"
 	"time"
 )
 
-func (m *Monitor) displayTable() {
-	m.clearScreen()
-	m.renderTable()
+// tableRenderer prints the human-readable table followed by sections for
+// SLOs, late checks, content changes, suppressions and alerts. Live frames
+// clear the screen first when clear is set.
+type tableRenderer struct {
+	layout func() tableLayout
+	clear  bool
 }
 
-func (m *Monitor) displayFinalTable() {
-	fmt.Println("\nFinal Statistics:")
-	m.renderTable()
+func (r *tableRenderer) Render(w io.Writer, frame Frame) error {
+	var b strings.Builder
+
+	if frame.Final {
+		b.WriteString("\nFinal Statistics:\n")
+	} else if r.clear {
+		b.WriteString("\033[2J\033[H")
+	}
+
+	layout := r.layout()
+	layout.render(&b, frame.Rows, nil)
+
+	renderSLOTable(&b, frame, layout.width)
+	renderScheduling(&b, frame)
+	renderContentChanges(&b, frame)
+	renderSuppressions(&b, frame)
+	renderRules(&b, frame)
+	renderEscalations(&b, frame)
+	renderAlerts(&b, frame)
+	renderExporters(&b, frame)
+	renderNotifiers(&b, frame)
+	renderHookRuns(&b, frame)
+	if frame.Final {
+		renderIncidents(&b, frame, layout.width)
+		renderRecentFailures(&b, frame)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func renderScheduling(b *strings.Builder, frame Frame) {
+	header := false
+	for _, row := range frame.Rows {
+		if row.snapshot.LateChecks == 0 {
+			continue
+		}
+
+		if !header {
+			b.WriteString("\nLate checks:\n")
+			header = true
+		}
+		fmt.Fprintf(b, "  %s: %d late, last delay %s, max delay %s\n",
+			row.url, row.snapshot.LateChecks,
+			formatDuration(row.snapshot.LastScheduleDelay), formatDuration(row.snapshot.MaxScheduleDelay))
+	}
+}
+
+func renderContentChanges(b *strings.Builder, frame Frame) {
+	header := false
+	for _, row := range frame.Rows {
+		if row.lastChange == nil {
+			continue
+		}
+
+		if !header {
+			b.WriteString("\nContent changes:\n")
+			header = true
+		}
+		last := row.lastChange
+		fmt.Fprintf(b, "  %s: %d changes, last at %s (%.8s -> %.8s)\n",
+			row.url, row.snapshot.ContentChanges, last.Time.Format("15:04:05"), last.OldHash, last.NewHash)
+	}
+}
+
+// renderExporters lists exporters that lost samples, or every exporter in
+// the final frame.
+func renderExporters(b *strings.Builder, frame Frame) {
+	header := false
+	for _, status := range frame.Exporters {
+		if !frame.Final && status.Failed == 0 && status.Dropped == 0 {
+			continue
+		}
+
+		if !header {
+			b.WriteString("\nExporters:\n")
+			header = true
+		}
+		fmt.Fprintf(b, "  %s %s: %d sent, %d failed, %d dropped\n",
+			status.Type, status.Address, status.Sent, status.Failed, status.Dropped)
+	}
+}
+
+// renderNotifiers lists notifiers with failed deliveries, or every notifier
+// in the final frame.
+func renderNotifiers(b *strings.Builder, frame Frame) {
+	header := false
+	for _, status := range frame.Notifiers {
+		if !frame.Final && status.Failed == 0 {
+			continue
+		}
+
+		if !header {
+			b.WriteString("\nNotifiers:\n")
+			header = true
+		}
+		fmt.Fprintf(b, "  %s: %d sent, %d failed", status.Type, status.Sent, status.Failed)
+		if status.Queued > 0 {
+			fmt.Fprintf(b, ", %d queued", status.Queued)
+		}
+		if status.LastError != "" {
+			fmt.Fprintf(b, ", last error: %s", status.LastError)
+		}
+		b.WriteString("\n")
+	}
+}
+
+// renderHookRuns lists the most recent hook runs.
+func renderHookRuns(b *strings.Builder, frame Frame) {
+	if len(frame.HookRuns) == 0 {
+		return
+	}
+
+	b.WriteString("\nHooks:\n")
+	for _, run := range frame.HookRuns[max(0, len(frame.HookRuns)-5):] {
+		fmt.Fprintf(b, "  %s\n", run)
+	}
+}
+
+// renderRules lists the rules that are pending or firing.
+func renderRules(b *strings.Builder, frame Frame) {
+	var active []RuleStatus
+	for _, status := range frame.Rules {
+		if status.State == RulePending || status.State == RuleFiring {
+			active = append(active, status)
+		}
+	}
+	if len(active) == 0 {
+		return
+	}
+
+	b.WriteString("\nAlert Rules:\n")
+	for _, status := range active {
+		fmt.Fprintf(b, "  %s\n", status)
+	}
 }
 
-func (m *Monitor) renderTable() {
+// renderEscalations lists the escalations that are not yet resolved.
+func renderEscalations(b *strings.Builder, frame Frame) {
+	var active []Escalation
+	for _, e := range frame.Escalations {
+		if e.State != EscalationResolved {
+			active = append(active, e)
+		}
+	}
+	if len(active) == 0 {
+		return
+	}
 
-	// Table header
-	fmt.Printf("%-30s %-12s %-12s %-12s %-10s %-10s %-10s %-15s\n",
-		"URL", "Duration Min", "Duration Avg", "Duration Max",
-		"Size Min", "Size Avg", "Size Max", "OK")
+	b.WriteString("\nEscalations:\n")
+	for _, e := range active {
+		fmt.Fprintf(b, "  %s\n", e)
+	}
+}
 
-	// Header separator
-	fmt.Printf("%-30s %-12s %-12s %-12s %-10s %-10s %-10s %-15s\n",
-		"────────────────────────────", "────────────", "────────────", "────────────",
-		"─────────", "─────────", "─────────", "──────────────")
+// incidentColumns are the columns of the incident list, the same as in
+// the reports.
+func incidentColumns(now time.Time) []tableColumn {
+	columns := make([]tableColumn, len(incidentHeaders))
+	for i, header := range incidentHeaders {
+		name := strings.ToLower(header)
+		if i == 0 {
+			// Lets the layout shrink it like the main table's URL column.
+			name = "url"
+		}
+		columns[i] = tableColumn{
+			name:   name,
+			header: header,
+			value:  func(r tableRow) string { return incidentCells(*r.incident, now)[i] },
+		}
+	}
+	return columns
+}
+
+// renderIncidents lists every recent incident for the final report.
+func renderIncidents(b *strings.Builder, frame Frame, width int) {
+	if len(frame.Incidents) == 0 {
+		return
+	}
+
+	rows := make([]tableRow, len(frame.Incidents))
+	for i := range frame.Incidents {
+		rows[i] = tableRow{url: frame.Incidents[i].URL, incident: &frame.Incidents[i]}
+	}
 
-	// Data rows
-	m.statsMu.RLock()
-	for _, url := range m.urls {
-		stat := m.stats[url]
-		snapshot := stat.GetSnapshot()
+	b.WriteString("\nIncidents:\n")
+	tableLayout{columns: incidentColumns(frame.Time), width: width}.render(b, rows, nil)
+}
 
-		displayURL := url
-		if len(displayURL) > 28 {
-			displayURL = displayURL[:25] + "..."
+func renderSuppressions(b *strings.Builder, frame Frame) {
+	var lines []string
+	for _, row := range frame.Rows {
+		if row.maintenance != nil {
+			lines = append(lines, fmt.Sprintf("  %s: %s", row.url, row.maintenance))
+		}
+	}
+	for _, s := range frame.Silences {
+		target := s.URL
+		if target == "" {
+			target = "all targets"
 		}
+		line := fmt.Sprintf("  %s: silenced until %s", target, s.Until.Format("Jan 2 15:04"))
+		if s.Reason != "" {
+			line += " (" + s.Reason + ")"
+		}
+		lines = append(lines, line)
+	}
 
-		// Format durations
-		minDur := formatDuration(snapshot.MinDuration)
-		avgDur := formatDuration(snapshot.AverageDuration())
-		maxDur := formatDuration(snapshot.MaxDuration)
+	if len(lines) == 0 {
+		return
+	}
+
+	b.WriteString("\nSuppressed:\n")
+	for _, line := range lines {
+		b.WriteString(line + "\n")
+	}
+}
+
+// sloColumns are the columns of the SLO table, one row per target with an
+// SLO.
+var sloColumns = []tableColumn{
+	{
+		name: "url", header: "SLO",
+		value: func(r tableRow) string { return r.url },
+	},
+	{
+		name: "window", header: "Window",
+		value: func(r tableRow) string { return formatWindow(r.slo.Window.Duration) },
+	},
+	{
+		name: "availability", header: "Availability",
+		value: func(r tableRow) string {
+			return formatObjective(r.sloStatus.Availability, r.slo.Availability, r.sloStatus.Requests)
+		},
+	},
+	{
+		name: "latency", header: "Latency",
+		value: func(r tableRow) string {
+			return formatObjective(r.sloStatus.LatencyGood, r.slo.LatencyTarget, r.sloStatus.Requests)
+		},
+	},
+	{
+		name: "budget", header: "Budget",
+		value: func(r tableRow) string { return fmt.Sprintf("%.1f%%", r.sloStatus.BudgetRemaining*100) },
+	},
+	{
+		name: "burn-1h", header: "Burn 1h",
+		value: func(r tableRow) string { return fmt.Sprintf("%.1fx", r.sloStatus.BurnRate1h) },
+	},
+	{
+		name: "alert", header: "Alert",
+		value: func(r tableRow) string {
+			alert := r.sloStatus.Alert
+			if alert == BurnNone {
+				alert = "-"
+			}
+			if !r.sloStatus.Met(r.slo) {
+				alert += " (violated)"
+			}
+			return alert
+		},
+	},
+}
+
+func renderSLOTable(b *strings.Builder, frame Frame, width int) {
+	var rows []tableRow
+	for _, row := range frame.Rows {
+		if row.sloStatus != nil {
+			rows = append(rows, row)
+		}
+	}
+	if len(rows) == 0 {
+		return
+	}
 
-		// Format sizes
-		minSize := formatSize(snapshot.MinSize)
-		avgSize := formatSize(snapshot.AverageSize())
-		maxSize := formatSize(snapshot.MaxSize)
+	b.WriteString("\n")
+	tableLayout{columns: sloColumns, width: width}.render(b, rows, nil)
+}
 
-		// Format success ratio
-		okRatio := fmt.Sprintf("%d/%d", snapshot.SuccessCount, snapshot.TotalRequests)
+func renderAlerts(b *strings.Builder, frame Frame) {
+	if len(frame.Alerts) == 0 {
+		return
+	}
 
-		fmt.Printf("%-30s %-12s %-12s %-12s %-10s %-10s %-10s %-15s\n",
-			displayURL, minDur, avgDur, maxDur, minSize, avgSize, maxSize, okRatio)
+	b.WriteString("\nAlerts:\n")
+	for _, alert := range frame.Alerts {
+		fmt.Fprintf(b, "  %s\n", alert)
 	}
-	m.statsMu.RUnlock()
 }
 
-func (m *Monitor) clearScreen() {
-	fmt.Print("\033[2J\033[H")
+// tableLayout returns the layout for the current terminal width.
+func (m *Monitor) tableLayout() tableLayout {
+	return tableLayout{
+		columns: m.columns,
+		width:   terminalWidth(),
+		color:   m.color,
+	}
 }
 
 func formatDuration(d time.Duration) string {
@@ -85,3 +338,17 @@ func formatSize(size int64) string {
 	}
 	return fmt.Sprintf("%dB", size)
 }
+
+func formatObjective(achieved, target float64, requests int64) string {
+	if target == 0 || requests == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f%%", achieved)
+}
+
+func formatWindow(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	return d.String()
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// SMTP connection security.
+const (
+	SMTPStartTLS = "starttls"
+	SMTPTLS      = "tls"
+	SMTPNone     = "none"
+)
+
+var smtpSecurity = []string{SMTPStartTLS, SMTPTLS, SMTPNone}
+
+// EmailConfig configures the SMTP notifier. The templates are paths to Go
+// templates that replace the built-in subject, plain text and HTML bodies.
+// With Digest set, events are collected for that long and sent as one
+// email.
+type EmailConfig struct {
+	Server   string   `json:"server"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	Security string   `json:"security,omitempty"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	Digest   Duration `json:"digest,omitempty"`
+
+	SubjectTemplate string `json:"subject_template,omitempty"`
+	TextTemplate    string `json:"text_template,omitempty"`
+	HTMLTemplate    string `json:"html_template,omitempty"`
+}
+
+func (c *EmailConfig) validate() error {
+	if _, _, err := net.SplitHostPort(c.Server); err != nil {
+		return fmt.Errorf("server must be host:port, got '%s'", c.Server)
+	}
+	if c.Security == "" {
+		c.Security = SMTPStartTLS
+	}
+	if !slices.Contains(smtpSecurity, c.Security) {
+		return fmt.Errorf("unknown security '%s', expected one of %s", c.Security, strings.Join(smtpSecurity, ", "))
+	}
+	if c.From == "" || len(c.To) == 0 {
+		return fmt.Errorf("from and to are required")
+	}
+	if c.Password == "" {
+		c.Password = os.Getenv("SMTP_PASSWORD")
+	}
+	if c.Digest.Duration < 0 {
+		return fmt.Errorf("digest must not be negative")
+	}
+	_, err := loadEmailTemplates(c)
+	return err
+}
+
+const defaultSubjectTemplate = `{{if .Digest}}[web-monitor] {{len .Events}} state changes
+{{- else}}{{with .Event}}[web-monitor] {{if .Down}}DOWN{{else}}RECOVERED{{end}}: {{.URL}}{{end}}{{end}}`
+
+const defaultTextTemplate = `{{range .Events}}{{.Summary}}
+
+Time:    {{.Time.Format "2006-01-02 15:04:05 MST"}}
+Target:  {{.TargetID}} ({{.URL}})
+Since:   {{.Incident.Start.Format "2006-01-02 15:04:05 MST"}}
+{{- if .Down}}
+Error:   {{.Error}}{{end}}
+Checks:  {{.Stats.SuccessCount}}/{{.Stats.TotalRequests}} successful, avg {{duration .Stats.AverageDuration}}, p95 {{duration (.Stats.Percentile 95)}}
+{{- if .RecentErrors}}
+
+Recent errors:
+{{- range .RecentErrors}}
+  {{.Time.Format "15:04:05"}}  {{.Error}}{{end}}{{end}}
+
+{{end}}`
+
+const defaultHTMLTemplate = `<!DOCTYPE html>
+<html><body style="font-family: sans-serif">
+{{range .Events}}<h2 style="color: {{if .Down}}#c62828{{else}}#2e7d32{{end}}">{{.Summary}}</h2>
+<table>
+<tr><th align="left">Time</th><td>{{.Time.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+<tr><th align="left">Target</th><td><a href="{{.URL}}">{{.TargetID}}</a></td></tr>
+<tr><th align="left">Since</th><td>{{.Incident.Start.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+{{if .Down}}<tr><th align="left">Error</th><td>{{.Error}}</td></tr>
+{{end}}<tr><th align="left">Checks</th><td>{{.Stats.SuccessCount}}/{{.Stats.TotalRequests}} successful, avg {{duration .Stats.AverageDuration}}, p95 {{duration (.Stats.Percentile 95)}}</td></tr>
+</table>
+{{if .RecentErrors}}<h3>Recent errors</h3>
+<ul>{{range .RecentErrors}}<li>{{.Time.Format "15:04:05"}} {{.Error}}</li>{{end}}</ul>
+{{end}}{{end}}</body></html>
+`
+
+var emailFuncs = map[string]any{
+	"duration": formatDuration,
+	"size":     formatSize,
+}
+
+type emailTemplates struct {
+	subject *template.Template
+	text    *template.Template
+	html    *htmltemplate.Template
+}
+
+// loadEmailTemplates parses the configured templates, falling back to the
+// built-in ones.
+func loadEmailTemplates(c *EmailConfig) (*emailTemplates, error) {
+	source := func(path, fallback string) (string, error) {
+		if path == "" {
+			return fallback, nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("reading template: %v", err)
+		}
+		return string(data), nil
+	}
+
+	var t emailTemplates
+	for _, tmpl := range []struct {
+		name, path, fallback string
+	}{
+		{"subject", c.SubjectTemplate, defaultSubjectTemplate},
+		{"text", c.TextTemplate, defaultTextTemplate},
+		{"html", c.HTMLTemplate, defaultHTMLTemplate},
+	} {
+		text, err := source(tmpl.path, tmpl.fallback)
+		if err != nil {
+			return nil, err
+		}
+		if tmpl.name == "html" {
+			t.html, err = htmltemplate.New(tmpl.name).Funcs(emailFuncs).Parse(text)
+		} else {
+			var parsed *template.Template
+			parsed, err = template.New(tmpl.name).Funcs(emailFuncs).Parse(text)
+			if tmpl.name == "subject" {
+				t.subject = parsed
+			} else {
+				t.text = parsed
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s template: %v", tmpl.name, err)
+		}
+	}
+	return &t, nil
+}
+
+// emailData is what the templates see. Event is the first event, which is
+// the only one unless Digest is set.
+type emailData struct {
+	Event  StateEvent
+	Events []StateEvent
+	Digest bool
+}
+
+// emailNotifier sends state changes over SMTP, either one email per event
+// or, in digest mode, one email per digest period.
+type emailNotifier struct {
+	cfg       EmailConfig
+	templates *emailTemplates
+
+	mu      sync.Mutex
+	pending []StateEvent
+	timer   *time.Timer
+	// delivered is called with the outcome of every digest, whether sent
+	// from the timer or by Flush, so that its events can be counted.
+	delivered func(events int, err error)
+}
+
+func newEmailNotifier(cfg EmailConfig) *emailNotifier {
+	// Templates were checked by validate.
+	templates, _ := loadEmailTemplates(&cfg)
+	return &emailNotifier{cfg: cfg, templates: templates, delivered: func(int, error) {}}
+}
+
+func (n *emailNotifier) Notify(ctx context.Context, event StateEvent) error {
+	if n.cfg.Digest.Duration == 0 {
+		return n.send(ctx, []StateEvent{event})
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.pending = append(n.pending, event)
+	if n.timer == nil {
+		n.timer = time.AfterFunc(n.cfg.Digest.Duration, func() {
+			ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+			defer cancel()
+			// The outcome is reported through delivered.
+			n.Flush(ctx)
+		})
+	}
+	return nil
+}
+
+// Flush sends the pending digest, if any.
+func (n *emailNotifier) Flush(ctx context.Context) error {
+	n.mu.Lock()
+	events := n.pending
+	n.pending = nil
+	if n.timer != nil {
+		n.timer.Stop()
+		n.timer = nil
+	}
+	n.mu.Unlock()
+
+	if len(events) == 0 {
+		return nil
+	}
+	err := n.send(ctx, events)
+	n.delivered(len(events), err)
+	return err
+}
+
+// digesting reports whether events are queued for a digest rather than
+// sent right away.
+func (n *emailNotifier) digesting() bool {
+	return n.cfg.Digest.Duration > 0
+}
+
+func (n *emailNotifier) send(ctx context.Context, events []StateEvent) error {
+	message, err := n.message(events, time.Now())
+	if err != nil {
+		return err
+	}
+	return n.deliver(ctx, message)
+}
+
+// message renders the events into a MIME email with plain text and HTML
+// alternatives.
+func (n *emailNotifier) message(events []StateEvent, now time.Time) ([]byte, error) {
+	data := emailData{Event: events[0], Events: events, Digest: n.cfg.Digest.Duration > 0}
+
+	var subject, text, html bytes.Buffer
+	if err := n.templates.subject.Execute(&subject, data); err != nil {
+		return nil, fmt.Errorf("rendering subject: %v", err)
+	}
+	if err := n.templates.text.Execute(&text, data); err != nil {
+		return nil, fmt.Errorf("rendering text body: %v", err)
+	}
+	if err := n.templates.html.Execute(&html, data); err != nil {
+		return nil, fmt.Errorf("rendering html body: %v", err)
+	}
+
+	var id [12]byte
+	rand.Read(id[:])
+	domain := n.cfg.From[strings.LastIndex(n.cfg.From, "@")+1:]
+
+	var b bytes.Buffer
+	body := multipart.NewWriter(&b)
+	fmt.Fprintf(&b, "From: %s\r\n", n.cfg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(n.cfg.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject.String())))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id[:]), domain)
+	fmt.Fprintf(&b, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", body.Boundary())
+
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{
+		{"text/plain; charset=utf-8", text.Bytes()},
+		{"text/html; charset=utf-8", html.Bytes()},
+	} {
+		w, err := body.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		qp.Write(part.content)
+		qp.Close()
+	}
+	body.Close()
+
+	return b.Bytes(), nil
+}
+
+func (n *emailNotifier) deliver(ctx context.Context, message []byte) error {
+	host, _, _ := net.SplitHostPort(n.cfg.Server)
+	tlsConfig := &tls.Config{ServerName: host}
+
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	var err error
+	if n.cfg.Security == SMTPTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", n.cfg.Server)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", n.cfg.Server)
+	}
+	if err != nil {
+		return fmt.Errorf("smtp: %v", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("smtp: %v", err)
+	}
+	defer client.Close()
+
+	if n.cfg.Security == SMTPStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("smtp: %s does not support STARTTLS", n.cfg.Server)
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("smtp: %v", err)
+		}
+	}
+	if n.cfg.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", n.cfg.Username, n.cfg.Password, host)); err != nil {
+			return fmt.Errorf("smtp: %v", err)
+		}
+	}
+
+	if err := client.Mail(n.cfg.From); err != nil {
+		return fmt.Errorf("smtp: %v", err)
+	}
+	for _, to := range n.cfg.To {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("smtp: %v", err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("smtp: %v", err)
+	}
+	if _, err := w.Write(message); err != nil {
+		return fmt.Errorf("smtp: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("smtp: %v", err)
+	}
+	return client.Quit()
+}
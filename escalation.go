@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	escalationTick    = time.Second
+	maxEscalationLog  = 50
+	recoveredByTarget = "recovery"
+)
+
+// EscalationStep notifies the named notifiers once an alert has been open
+// and unacknowledged for After. Steps run in order, so After must not
+// decrease from one step to the next.
+type EscalationStep struct {
+	After  Duration `json:"after,omitempty"`
+	Notify []string `json:"notify"`
+}
+
+// parseEscalationStep parses the -escalate flag value, names[@after], for
+// example "pagerduty@15m".
+func parseEscalationStep(value string) (EscalationStep, error) {
+	names, after, found := strings.Cut(value, "@")
+	var step EscalationStep
+	if found {
+		d, err := parseDuration(after)
+		if err != nil {
+			return step, fmt.Errorf("invalid escalation step '%s': %v", value, err)
+		}
+		step.After.Duration = d
+	}
+	for name := range strings.SplitSeq(names, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			step.Notify = append(step.Notify, name)
+		}
+	}
+	return step, nil
+}
+
+// validateEscalation checks that every step names known notifiers and that
+// the steps are in order.
+func validateEscalation(steps []EscalationStep, notifiers []NotifierConfig) error {
+	names := make(map[string]bool)
+	for _, n := range notifiers {
+		if names[n.name()] {
+			return fmt.Errorf("notifier name '%s' is used more than once, set a unique name to use it in escalation", n.name())
+		}
+		names[n.name()] = true
+	}
+
+	var previous time.Duration
+	for i, step := range steps {
+		if len(step.Notify) == 0 {
+			return fmt.Errorf("escalation step %d notifies nobody", i+1)
+		}
+		for _, name := range step.Notify {
+			if !names[name] {
+				return fmt.Errorf("escalation step %d: unknown notifier '%s'", i+1, name)
+			}
+		}
+		if step.After.Duration < previous {
+			return fmt.Errorf("escalation step %d: after %s is earlier than the previous step", i+1, step.After)
+		}
+		previous = step.After.Duration
+	}
+	return nil
+}
+
+// escalationLevel is an EscalationStep with its notifiers looked up.
+type escalationLevel struct {
+	after     time.Duration
+	notifiers []*notifierEntry
+}
+
+// escalationLevels resolves the configured steps. Without steps every
+// notifier is told straight away.
+func escalationLevels(steps []EscalationStep, notifiers []*notifierEntry) []escalationLevel {
+	if len(steps) == 0 {
+		if len(notifiers) == 0 {
+			return nil
+		}
+		return []escalationLevel{{notifiers: notifiers}}
+	}
+
+	var levels []escalationLevel
+	for _, step := range steps {
+		level := escalationLevel{after: step.After.Duration}
+		for _, n := range notifiers {
+			if slices.Contains(step.Notify, n.name) {
+				level.notifiers = append(level.notifiers, n)
+			}
+		}
+		levels = append(levels, level)
+	}
+	return levels
+}
+
+// Escalation states.
+const (
+	EscalationOpen         = "open"
+	EscalationAcknowledged = "acknowledged"
+	EscalationResolved     = "resolved"
+)
+
+// Escalation follows one alert, a target going down or a rule firing, from
+// the first notification until it is acknowledged or resolved.
+// Acknowledging or resolving stops further steps; the notifiers reached so
+// far are still told when the target recovers or the rule resolves.
+type Escalation struct {
+	ID       string `json:"id"`
+	URL      string `json:"url"`
+	TargetID string `json:"target_id"`
+	Rule     string `json:"rule,omitempty"`
+	Summary  string `json:"summary"`
+	State    string `json:"state"`
+	// Steps is the number of escalation steps notified so far.
+	Steps    int      `json:"steps"`
+	Notified []string `json:"notified"`
+
+	OpenedAt       time.Time `json:"opened_at"`
+	AcknowledgedAt time.Time `json:"acknowledged_at,omitzero"`
+	AcknowledgedBy string    `json:"acknowledged_by,omitempty"`
+	ResolvedAt     time.Time `json:"resolved_at,omitzero"`
+	ResolvedBy     string    `json:"resolved_by,omitempty"`
+	TimeToAck      Duration  `json:"time_to_ack,omitzero"`
+	TimeToResolve  Duration  `json:"time_to_resolve,omitzero"`
+
+	event     StateEvent
+	notifiers []*notifierEntry
+	// active is cleared once the recovery has been sent.
+	active bool
+}
+
+func (e *Escalation) key() string {
+	return e.URL + " " + e.Rule
+}
+
+func (e Escalation) String() string {
+	return fmt.Sprintf("#%s [%s] %s since %s, notified %s", e.ID, e.State, e.Summary, e.OpenedAt.Format("15:04:05"), strings.Join(e.Notified, ", "))
+}
+
+// openEscalation starts escalating a down event. Notifiers of the first
+// step are told immediately.
+func (m *Monitor) openEscalation(event StateEvent) {
+	if len(m.escalationLevels) == 0 {
+		return
+	}
+
+	m.alertsMu.Lock()
+	defer m.alertsMu.Unlock()
+
+	m.nextEscalationID++
+	e := &Escalation{
+		ID:       strconv.Itoa(m.nextEscalationID),
+		URL:      event.URL,
+		TargetID: event.TargetID,
+		Rule:     event.Rule,
+		Summary:  event.Summary(),
+		State:    EscalationOpen,
+		Notified: []string{},
+		OpenedAt: event.Time,
+		event:    event,
+		active:   true,
+	}
+	if previous, ok := m.activeEscalations[e.key()]; ok {
+		previous.active = false
+	}
+	m.activeEscalations[e.key()] = e
+	m.escalations = append(m.escalations, e)
+	m.trimEscalations()
+
+	m.advanceEscalation(e, event.Time)
+}
+
+// closeEscalation sends a recovery to everyone who was told about the
+// matching down event, and resolves the escalation unless someone already
+// did.
+func (m *Monitor) closeEscalation(event StateEvent) {
+	if len(m.escalationLevels) == 0 {
+		return
+	}
+
+	m.alertsMu.Lock()
+	defer m.alertsMu.Unlock()
+
+	key := event.URL + " " + event.Rule
+	e, ok := m.activeEscalations[key]
+	if !ok {
+		return
+	}
+	delete(m.activeEscalations, key)
+	e.active = false
+
+	if e.State != EscalationResolved {
+		e.State, e.ResolvedAt, e.ResolvedBy = EscalationResolved, event.Time, recoveredByTarget
+		e.TimeToResolve = Duration{event.Time.Sub(e.OpenedAt)}
+	}
+	m.notify(event, e.notifiers)
+}
+
+// advanceEscalation notifies every step whose delay has passed. The caller
+// holds m.alertsMu.
+func (m *Monitor) advanceEscalation(e *Escalation, now time.Time) {
+	for e.State == EscalationOpen && e.Steps < len(m.escalationLevels) {
+		level := m.escalationLevels[e.Steps]
+		if now.Sub(e.OpenedAt) < level.after {
+			return
+		}
+		e.Steps++
+
+		var names []string
+		for _, n := range level.notifiers {
+			if !slices.Contains(e.notifiers, n) {
+				e.notifiers = append(e.notifiers, n)
+				e.Notified = append(e.Notified, n.name)
+				names = append(names, n.name)
+			}
+		}
+		m.notify(e.event, level.notifiers)
+
+		// The first step is the ordinary notification, later ones are
+		// worth an alert of their own.
+		if e.Steps > 1 {
+			m.appendAlert(Alert{
+				Time:     now,
+				URL:      e.URL,
+				Severity: "escalated",
+				Message:  fmt.Sprintf("#%s unacknowledged after %s, notified %s", e.ID, level.after, strings.Join(names, ", ")),
+			})
+		}
+	}
+}
+
+// trimEscalations drops the oldest finished escalations beyond
+// maxEscalationLog. The caller holds m.alertsMu.
+func (m *Monitor) trimEscalations() {
+	for len(m.escalations) > maxEscalationLog {
+		i := slices.IndexFunc(m.escalations, func(e *Escalation) bool { return !e.active })
+		if i < 0 {
+			return
+		}
+		m.escalations = slices.Delete(m.escalations, i, i+1)
+	}
+}
+
+func (m *Monitor) escalationLoop(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(escalationTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			m.escalate(now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// escalate moves every open escalation on to the steps that are due.
+func (m *Monitor) escalate(now time.Time) {
+	m.alertsMu.Lock()
+	defer m.alertsMu.Unlock()
+
+	for _, e := range m.activeEscalations {
+		m.advanceEscalation(e, now)
+	}
+}
+
+var errUnknownEscalation = errors.New("unknown escalation")
+
+// Acknowledge stops an open escalation from notifying further steps.
+func (m *Monitor) Acknowledge(id, by string, now time.Time) (Escalation, error) {
+	m.alertsMu.Lock()
+	defer m.alertsMu.Unlock()
+
+	e := m.findEscalation(id)
+	if e == nil {
+		return Escalation{}, errUnknownEscalation
+	}
+	if e.State != EscalationOpen {
+		return *e, fmt.Errorf("escalation #%s is already %s", id, e.State)
+	}
+	e.State, e.AcknowledgedAt, e.AcknowledgedBy = EscalationAcknowledged, now, by
+	e.TimeToAck = Duration{now.Sub(e.OpenedAt)}
+	return *e, nil
+}
+
+// Resolve closes an escalation by hand, for example when the alert was a
+// false positive.
+func (m *Monitor) Resolve(id, by string, now time.Time) (Escalation, error) {
+	m.alertsMu.Lock()
+	defer m.alertsMu.Unlock()
+
+	e := m.findEscalation(id)
+	if e == nil {
+		return Escalation{}, errUnknownEscalation
+	}
+	if e.State == EscalationResolved {
+		return *e, fmt.Errorf("escalation #%s is already resolved", id)
+	}
+	e.State, e.ResolvedAt, e.ResolvedBy = EscalationResolved, now, by
+	e.TimeToResolve = Duration{now.Sub(e.OpenedAt)}
+	return *e, nil
+}
+
+// findEscalation looks up an escalation by ID. The caller holds
+// m.alertsMu.
+func (m *Monitor) findEscalation(id string) *Escalation {
+	for _, e := range m.escalations {
+		if e.ID == id {
+			return e
+		}
+	}
+	return nil
+}
+
+// Escalations returns the escalation log, oldest first.
+func (m *Monitor) Escalations() []Escalation {
+	m.alertsMu.Lock()
+	defer m.alertsMu.Unlock()
+
+	escalations := []Escalation{}
+	for _, e := range m.escalations {
+		escalations = append(escalations, *e)
+	}
+	return escalations
+}
+
+func (m *Monitor) handleListEscalations(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, m.Escalations())
+}
+
+type escalationRequest struct {
+	By string `json:"by"`
+}
+
+func (m *Monitor) handleAcknowledge(w http.ResponseWriter, r *http.Request) {
+	m.updateEscalation(w, r, m.Acknowledge)
+}
+
+func (m *Monitor) handleResolve(w http.ResponseWriter, r *http.Request) {
+	m.updateEscalation(w, r, m.Resolve)
+}
+
+func (m *Monitor) updateEscalation(w http.ResponseWriter, r *http.Request, update func(id, by string, now time.Time) (Escalation, error)) {
+	var req escalationRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+			return
+		}
+	}
+	if req.By == "" {
+		req.By = "api"
+	}
+
+	e, err := update(r.PathValue("id"), req.By, time.Now())
+	switch {
+	case errors.Is(err, errUnknownEscalation):
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown escalation '%s'", r.PathValue("id")))
+	case err != nil:
+		writeError(w, http.StatusConflict, err)
+	default:
+		writeJSON(w, http.StatusOK, e)
+	}
+}
@@ -0,0 +1,555 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+// Exporter types.
+const (
+	ExporterStatsD   = "statsd"
+	ExporterGraphite = "graphite"
+	ExporterInfluxDB = "influxdb"
+)
+
+var exporterTypes = []string{ExporterStatsD, ExporterGraphite, ExporterInfluxDB}
+
+const (
+	defaultExportInterval = 10 * time.Second
+	defaultExportBatch    = 100
+	exportQueueSize       = 1000
+	exportFlushInterval   = time.Second
+	exportTimeout         = 5 * time.Second
+	statsdPacketSize      = 1432
+)
+
+// ExporterConfig configures a push exporter. Address is host:port for StatsD
+// (UDP) and Graphite (TCP) and the write URL for InfluxDB, e.g.
+// http://localhost:8086/api/v2/write?org=acme&bucket=monitor.
+type ExporterConfig struct {
+	// Name tags the exporter's own counters. It defaults to the type, or
+	// the type and position when there are several of that type.
+	Name      string            `json:"name,omitempty"`
+	Type      string            `json:"type"`
+	Address   string            `json:"address"`
+	Prefix    string            `json:"prefix,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	Interval  Duration          `json:"interval,omitempty"`
+	BatchSize int               `json:"batch_size,omitempty"`
+	// Token is sent as "Authorization: Token <token>" to InfluxDB.
+	Token string `json:"token,omitempty"`
+}
+
+func (c *ExporterConfig) validate() error {
+	if !slices.Contains(exporterTypes, c.Type) {
+		return fmt.Errorf("unknown type '%s', expected one of %s", c.Type, strings.Join(exporterTypes, ", "))
+	}
+	if c.Address == "" {
+		return fmt.Errorf("address is required")
+	}
+	if c.Type == ExporterInfluxDB && !strings.HasPrefix(c.Address, "http://") && !strings.HasPrefix(c.Address, "https://") {
+		return fmt.Errorf("influxdb address must be an http(s) URL, got '%s'", c.Address)
+	}
+	if c.Interval.Duration == 0 {
+		c.Interval.Duration = defaultExportInterval
+	}
+	if c.Interval.Duration < time.Second {
+		return fmt.Errorf("interval must be at least 1s, got %s", c.Interval)
+	}
+	if c.BatchSize == 0 {
+		c.BatchSize = defaultExportBatch
+	}
+	if c.BatchSize < 0 {
+		return fmt.Errorf("batch_size must not be negative")
+	}
+	if c.Prefix == "" {
+		c.Prefix = "web_monitor"
+	}
+	return nil
+}
+
+// nameExporters gives every exporter without a name a default one and
+// checks that the names are unique.
+func nameExporters(exporters []ExporterConfig) error {
+	types := make(map[string]int)
+	for _, e := range exporters {
+		types[e.Type]++
+	}
+
+	names := make(map[string]bool)
+	for i := range exporters {
+		e := &exporters[i]
+		if e.Name == "" {
+			e.Name = e.Type
+			if types[e.Type] > 1 {
+				e.Name = fmt.Sprintf("%s-%d", e.Type, i+1)
+			}
+		}
+		if names[e.Name] {
+			return fmt.Errorf("exporter %d: duplicate name '%s'", i+1, e.Name)
+		}
+		names[e.Name] = true
+	}
+	return nil
+}
+
+// Metric kinds, named after their StatsD types.
+const (
+	metricCounter = "c"
+	metricGauge   = "g"
+	metricTiming  = "ms"
+)
+
+type metricField struct {
+	Name  string
+	Value float64
+	Kind  string
+}
+
+// metricSample is a set of fields measured together, such as one check or
+// one target's aggregates. Each exporter maps it onto its own naming.
+type metricSample struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      []metricField
+	Time        time.Time
+}
+
+// ExporterStatus counts what an exporter did with the samples it was given.
+// Dropped samples did not fit in the queue; failed samples were lost to a
+// send error.
+type ExporterStatus struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Address string `json:"address"`
+	Sent    int64  `json:"sent"`
+	Failed  int64  `json:"failed"`
+	Dropped int64  `json:"dropped"`
+}
+
+// exporter batches samples from a queue and pushes them in the background,
+// so a slow or unreachable backend never holds up a check.
+type exporter struct {
+	cfg    ExporterConfig
+	queue  chan metricSample
+	encode func(prefix string, batch []metricSample) []exportPayload
+	send   func(ctx context.Context, payload []byte) error
+
+	sent, failed, dropped atomic.Int64
+}
+
+func newExporter(cfg ExporterConfig) *exporter {
+	if cfg.Name == "" {
+		cfg.Name = cfg.Type
+	}
+	e := &exporter{cfg: cfg, queue: make(chan metricSample, exportQueueSize)}
+
+	switch cfg.Type {
+	case ExporterStatsD:
+		e.encode = encodeStatsD
+		e.send = func(ctx context.Context, payload []byte) error {
+			return sendConn(ctx, "udp", cfg.Address, payload)
+		}
+	case ExporterGraphite:
+		e.encode = encodeGraphite
+		e.send = func(ctx context.Context, payload []byte) error {
+			return sendConn(ctx, "tcp", cfg.Address, payload)
+		}
+	case ExporterInfluxDB:
+		client := &http.Client{Timeout: exportTimeout}
+		e.encode = encodeInflux
+		e.send = func(ctx context.Context, payload []byte) error {
+			return sendInflux(ctx, client, cfg.Address, cfg.Token, payload)
+		}
+	}
+	return e
+}
+
+// enqueue hands a sample to the exporter without blocking. The exporter's
+// own tags are added; a full queue drops the sample.
+func (e *exporter) enqueue(sample metricSample) {
+	if len(e.cfg.Tags) > 0 {
+		tags := maps.Clone(e.cfg.Tags)
+		maps.Copy(tags, sample.Tags)
+		sample.Tags = tags
+	}
+
+	select {
+	case e.queue <- sample:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+func (e *exporter) status() ExporterStatus {
+	return ExporterStatus{
+		Name:    e.cfg.Name,
+		Type:    e.cfg.Type,
+		Address: e.cfg.Address,
+		Sent:    e.sent.Load(),
+		Failed:  e.failed.Load(),
+		Dropped: e.dropped.Load(),
+	}
+}
+
+func (e *exporter) run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(exportFlushInterval)
+	defer ticker.Stop()
+
+	var batch []metricSample
+	for {
+		select {
+		case sample := <-e.queue:
+			batch = append(batch, sample)
+			if len(batch) >= e.cfg.BatchSize {
+				e.flush(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			e.flush(batch)
+			batch = nil
+		case <-ctx.Done():
+			// Push whatever is still queued before shutting down.
+			for len(e.queue) > 0 {
+				batch = append(batch, <-e.queue)
+			}
+			e.flush(batch)
+			return
+		}
+	}
+}
+
+// flush sends a batch. Sends are bounded by exportTimeout rather than the
+// monitor's context so that shutdown does not cut off the final push.
+func (e *exporter) flush(batch []metricSample) {
+	if len(batch) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	// Payloads are sent independently, so one that fails does not take
+	// those before or after it with it.
+	for _, payload := range e.encode(e.cfg.Prefix, batch) {
+		if err := e.send(ctx, payload.data); err != nil {
+			e.failed.Add(int64(payload.samples))
+			continue
+		}
+		e.sent.Add(int64(payload.samples))
+	}
+}
+
+func sendConn(ctx context.Context, network, address string, payload []byte) error {
+	dialer := net.Dialer{Timeout: exportTimeout}
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetWriteDeadline(time.Now().Add(exportTimeout))
+	_, err = conn.Write(payload)
+	return err
+}
+
+func sendInflux(ctx context.Context, client *http.Client, url, token string, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if token != "" {
+		req.Header.Set("Authorization", "Token "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("influxdb write returned %s", resp.Status)
+	}
+	return nil
+}
+
+func sortedTags(tags map[string]string) []string {
+	return slices.Sorted(maps.Keys(tags))
+}
+
+// sanitizeTag replaces whitespace and the characters in invalid with
+// underscores, for protocols without a way to escape them.
+func sanitizeTag(s, invalid string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || strings.ContainsRune(invalid, r) {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// exportPayload is one send's worth of encoded samples. A sample split
+// across payloads counts with the one holding its last line.
+type exportPayload struct {
+	data    []byte
+	samples int
+}
+
+// encodeStatsD writes one line per field in DogStatsD format, e.g.
+// "web_monitor.check.duration:120|ms|#target:example-com", packed into
+// packets that fit a typical MTU. Tags with an empty value are left out,
+// as for the other protocols.
+func encodeStatsD(prefix string, batch []metricSample) []exportPayload {
+	var packets []exportPayload
+	var packet bytes.Buffer
+	samples := 0
+	for _, sample := range batch {
+		var tags []string
+		for _, key := range sortedTags(sample.Tags) {
+			if value := sample.Tags[key]; value != "" {
+				tags = append(tags, sanitizeTag(key, ",|#:")+":"+sanitizeTag(value, ",|#"))
+			}
+		}
+
+		for _, field := range sample.Fields {
+			line := fmt.Sprintf("%s.%s.%s:%s|%s", prefix, sample.Measurement, field.Name, formatMetric(field.Value), field.Kind)
+			if len(tags) > 0 {
+				line += "|#" + strings.Join(tags, ",")
+			}
+
+			if packet.Len() > 0 && packet.Len()+1+len(line) > statsdPacketSize {
+				packets = append(packets, exportPayload{bytes.Clone(packet.Bytes()), samples})
+				packet.Reset()
+				samples = 0
+			}
+			if packet.Len() > 0 {
+				packet.WriteByte('\n')
+			}
+			packet.WriteString(line)
+		}
+		samples++
+	}
+	if packet.Len() > 0 {
+		packets = append(packets, exportPayload{packet.Bytes(), samples})
+	}
+	return packets
+}
+
+// encodeGraphite writes the plaintext protocol with Graphite 1.1 tags, e.g.
+// "web_monitor.check.duration;target=example-com 120 1700000000". Tags
+// with an empty value are not allowed and left out.
+func encodeGraphite(prefix string, batch []metricSample) []exportPayload {
+	var b bytes.Buffer
+	for _, sample := range batch {
+		var tags strings.Builder
+		for _, key := range sortedTags(sample.Tags) {
+			if value := sample.Tags[key]; value != "" {
+				fmt.Fprintf(&tags, ";%s=%s", sanitizeTag(key, ";!^=~"), sanitizeTag(value, ";~"))
+			}
+		}
+		for _, field := range sample.Fields {
+			fmt.Fprintf(&b, "%s.%s.%s%s %s %d\n", prefix, sample.Measurement, field.Name, tags.String(),
+				formatMetric(field.Value), sample.Time.Unix())
+		}
+	}
+	return []exportPayload{{b.Bytes(), len(batch)}}
+}
+
+// encodeInflux writes InfluxDB line protocol with one line per sample, e.g.
+// "web_monitor_check,target=example-com duration=120,success=1 1700000000000000000".
+// Empty tag values are not allowed and left out.
+func encodeInflux(prefix string, batch []metricSample) []exportPayload {
+	escape := strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+
+	var b bytes.Buffer
+	for _, sample := range batch {
+		b.WriteString(escape.Replace(prefix + "_" + sample.Measurement))
+		for _, key := range sortedTags(sample.Tags) {
+			if value := sample.Tags[key]; value != "" {
+				fmt.Fprintf(&b, ",%s=%s", escape.Replace(key), escape.Replace(value))
+			}
+		}
+		for i, field := range sample.Fields {
+			sep := ","
+			if i == 0 {
+				sep = " "
+			}
+			fmt.Fprintf(&b, "%s%s=%s", sep, escape.Replace(field.Name), formatMetric(field.Value))
+		}
+		fmt.Fprintf(&b, " %d\n", sample.Time.UnixNano())
+	}
+	return []exportPayload{{b.Bytes(), len(batch)}}
+}
+
+func formatMetric(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// exportCheck queues a check result on every exporter.
+func (m *Monitor) exportCheck(url string, result CheckResult) {
+	if len(m.exporters) == 0 {
+		return
+	}
+
+	m.statsMu.RLock()
+	tags := map[string]string{"target": m.targets[url].ID}
+	m.statsMu.RUnlock()
+
+	if !result.Success {
+		kind := result.ErrorKind
+		if kind == "" {
+			kind = ErrorOther
+		}
+		tags["error"] = kind
+	}
+
+	sample := metricSample{
+		Measurement: "check",
+		Tags:        tags,
+		Time:        result.Time,
+		Fields: []metricField{
+			{"duration", milliseconds(result.Duration), metricTiming},
+			{"size", float64(result.BodySize), metricGauge},
+			{"success", boolMetric(result.Success), metricGauge},
+			{"count", 1, metricCounter},
+		},
+	}
+	if !result.Success {
+		sample.Fields = append(sample.Fields, metricField{"failures", 1, metricCounter})
+	}
+
+	for _, e := range m.exporters {
+		e.enqueue(sample)
+	}
+}
+
+func boolMetric(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// aggregateSamples summarises every target and every exporter's own
+// counters as gauges.
+func (m *Monitor) aggregateSamples(now time.Time) []metricSample {
+	var samples []metricSample
+	for _, row := range m.tableRows() {
+		s := row.snapshot
+		fields := []metricField{
+			{"requests", float64(s.TotalRequests), metricGauge},
+			{"successes", float64(s.SuccessCount), metricGauge},
+			{"success_ratio", percent(s.SuccessCount, s.TotalRequests) / 100, metricGauge},
+			{"duration_avg", milliseconds(s.AverageDuration()), metricGauge},
+			{"duration_p95", milliseconds(s.Percentile(95)), metricGauge},
+			{"duration_p99", milliseconds(s.Percentile(99)), metricGauge},
+			{"size_avg", float64(s.AverageSize()), metricGauge},
+			{"up", boolMetric(row.state == stateUp || row.state == stateDegraded), metricGauge},
+		}
+		for _, kind := range errorKinds {
+			fields = append(fields, metricField{"errors_" + kind, float64(s.ErrorCounts[kind]), metricGauge})
+		}
+		if score, ok := s.Apdex(); ok {
+			fields = append(fields, metricField{"apdex", score, metricGauge})
+		}
+		if score, ok := row.recentCounts.Apdex(); ok {
+			fields = append(fields, metricField{"apdex_1h", score, metricGauge})
+		}
+		if m.targets[row.url].Anomaly != nil {
+			fields = append(fields, metricField{"anomalies", float64(s.Anomalies), metricGauge})
+		}
+		if status := row.sloStatus; status != nil {
+			fields = append(fields,
+				metricField{"slo_availability", status.Availability / 100, metricGauge},
+				metricField{"slo_budget_remaining", status.BudgetRemaining, metricGauge},
+				metricField{"slo_burn_rate_1h", status.BurnRate1h, metricGauge},
+				metricField{"slo_burn_rate_6h", status.BurnRate6h, metricGauge},
+				metricField{"slo_met", boolMetric(status.Met(row.slo)), metricGauge},
+			)
+			if row.slo.Latency.Duration > 0 {
+				fields = append(fields, metricField{"slo_latency_good", status.LatencyGood / 100, metricGauge})
+			}
+		}
+		samples = append(samples, metricSample{
+			Measurement: "target",
+			Tags:        map[string]string{"target": m.targets[row.url].ID},
+			Fields:      fields,
+			Time:        now,
+		})
+	}
+
+	for _, status := range m.ExporterStatus() {
+		samples = append(samples, metricSample{
+			Measurement: "exporter",
+			Tags:        map[string]string{"exporter": status.Type, "name": status.Name},
+			Fields: []metricField{
+				{"sent", float64(status.Sent), metricGauge},
+				{"failed", float64(status.Failed), metricGauge},
+				{"dropped", float64(status.Dropped), metricGauge},
+			},
+			Time: now,
+		})
+	}
+	return samples
+}
+
+// ExporterStatus returns the counters of every exporter.
+func (m *Monitor) ExporterStatus() []ExporterStatus {
+	var statuses []ExporterStatus
+	for _, e := range m.exporters {
+		statuses = append(statuses, e.status())
+	}
+	if m.otlp != nil {
+		statuses = append(statuses, m.otlp.status())
+	}
+	return statuses
+}
+
+// startExporters runs every exporter and pushes aggregates to each at its
+// configured interval.
+func (m *Monitor) startExporters(ctx context.Context, wg *sync.WaitGroup) {
+	if m.otlp != nil {
+		wg.Add(1)
+		go m.otlp.run(ctx, wg, m.aggregateSamples)
+	}
+
+	for _, e := range m.exporters {
+		wg.Add(2)
+		go e.run(ctx, wg)
+		go func() {
+			defer wg.Done()
+
+			ticker := time.NewTicker(e.cfg.Interval.Duration)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case now := <-ticker.C:
+					for _, sample := range m.aggregateSamples(now) {
+						e.enqueue(sample)
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+}
+
+func (m *Monitor) handleListExporters(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, m.ExporterStatus())
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// HAR 1.2 documents, see http://www.softwareishard.com/blog/har-12-spec/.
+// Only the parts a check can fill are included.
+type harDocument struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	// Custom fields start with an underscore.
+	TargetID string `json:"_targetId"`
+	Error    string `json:"_error,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// harTimings are in milliseconds. Blocked, DNS, Connect and SSL are -1
+// when they did not apply, for example on a reused connection; Connect
+// includes SSL.
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// harHeaders lists headers sorted by name.
+func harHeaders(header http.Header) []harNameValue {
+	list := []harNameValue{}
+	for _, name := range slices.Sorted(maps.Keys(header)) {
+		for _, value := range header[name] {
+			list = append(list, harNameValue{Name: name, Value: value})
+		}
+	}
+	return list
+}
+
+func harCookies(cookies []*http.Cookie) []harNameValue {
+	list := []harNameValue{}
+	for _, cookie := range cookies {
+		list = append(list, harNameValue{Name: cookie.Name, Value: cookie.Value})
+	}
+	return list
+}
+
+// newHARTimings splits a check into HAR timings using its httptrace phases.
+// Only the first occurrence of each phase counts, so after redirects the
+// later hops end up in Receive.
+func newHARTimings(check CheckResult) harTimings {
+	phase := func(name string) (time.Time, bool) {
+		i := slices.IndexFunc(check.Phases, func(p TracePhase) bool { return p.Name == name })
+		if i < 0 {
+			return time.Time{}, false
+		}
+		return check.Phases[i].Time, true
+	}
+	between := func(from, to time.Time) float64 {
+		return max(0, milliseconds(to.Sub(from)))
+	}
+
+	timings := harTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1}
+	dnsStart, dns := phase(PhaseDNSStart)
+	dnsDone, _ := phase(PhaseDNSDone)
+	connectStart, connect := phase(PhaseConnectStart)
+	connectDone, _ := phase(PhaseConnectDone)
+	tlsStart, tls := phase(PhaseTLSStart)
+	tlsDone, _ := phase(PhaseTLSDone)
+
+	// The request is sent once the connection is ready, which is the start
+	// of the check on a reused connection.
+	ready := check.Time
+	switch {
+	case dns:
+		timings.Blocked = between(check.Time, dnsStart)
+		timings.DNS = between(dnsStart, dnsDone)
+	case connect:
+		timings.Blocked = between(check.Time, connectStart)
+	}
+	if dns {
+		ready = dnsDone
+	}
+	if connect {
+		timings.Connect = between(connectStart, connectDone)
+		ready = connectDone
+	}
+	if tls {
+		timings.SSL = between(tlsStart, tlsDone)
+		timings.Connect = between(connectStart, tlsDone)
+		ready = tlsDone
+	}
+
+	wrote, sent := phase(PhaseWroteRequest)
+	firstByte, answered := phase(PhaseFirstByte)
+	if sent {
+		timings.Send = between(ready, wrote)
+	}
+	if sent && answered {
+		timings.Wait = between(wrote, firstByte)
+		timings.Receive = between(firstByte, check.Time.Add(check.Duration))
+	}
+	return timings
+}
+
+// total is the time of the entry, the sum of every timing that applies.
+func (t harTimings) total() float64 {
+	total := t.Send + t.Wait + t.Receive
+	for _, timing := range []float64{t.Blocked, t.DNS, t.Connect} {
+		total += max(0, timing)
+	}
+	return total
+}
+
+func newHAREntry(targetURL, targetID string, check CheckResult) harEntry {
+	method := check.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	request := harRequest{
+		Method:      method,
+		URL:         targetURL,
+		HTTPVersion: check.Proto,
+		Cookies:     harCookies((&http.Request{Header: check.RequestHeader}).Cookies()),
+		Headers:     harHeaders(check.RequestHeader),
+		QueryString: []harNameValue{},
+		HeadersSize: -1,
+	}
+	if parsed, err := url.Parse(targetURL); err == nil {
+		query := parsed.Query()
+		for _, name := range slices.Sorted(maps.Keys(query)) {
+			for _, value := range query[name] {
+				request.QueryString = append(request.QueryString, harNameValue{Name: name, Value: value})
+			}
+		}
+	}
+
+	response := harResponse{
+		Status:      check.StatusCode,
+		StatusText:  http.StatusText(check.StatusCode),
+		HTTPVersion: check.Proto,
+		Cookies:     harCookies((&http.Response{Header: check.ResponseHeader}).Cookies()),
+		Headers:     harHeaders(check.ResponseHeader),
+		Content:     harContent{Size: check.BodySize, MimeType: check.ResponseHeader.Get("Content-Type")},
+		RedirectURL: check.ResponseHeader.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    check.BodySize,
+	}
+	if check.StatusCode == 0 {
+		// Without a response nothing was received.
+		response.BodySize = -1
+	}
+
+	timings := newHARTimings(check)
+	return harEntry{
+		StartedDateTime: check.Time,
+		Time:            timings.total(),
+		Request:         request,
+		Response:        response,
+		Timings:         timings,
+		TargetID:        targetID,
+		Error:           check.Error,
+	}
+}
+
+// HAR returns the recent checks of every target, or only of url when it is
+// not empty, as a HAR document ordered by start time.
+func (m *Monitor) HAR(url string) harDocument {
+	m.statsMu.RLock()
+	defer m.statsMu.RUnlock()
+
+	doc := harDocument{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "web-monitor", Version: "1.0"},
+		Entries: []harEntry{},
+	}}
+	for _, u := range m.urls {
+		if url != "" && u != url {
+			continue
+		}
+		for _, check := range m.stats[u].RecentChecks() {
+			doc.Log.Entries = append(doc.Log.Entries, newHAREntry(u, m.targets[u].ID, check))
+		}
+	}
+	slices.SortStableFunc(doc.Log.Entries, func(a, b harEntry) int { return a.StartedDateTime.Compare(b.StartedDateTime) })
+	return doc
+}
+
+func (m *Monitor) handleHAR(w http.ResponseWriter, r *http.Request) {
+	url := r.URL.Query().Get("url")
+	if url != "" {
+		m.statsMu.RLock()
+		_, ok := m.stats[url]
+		m.statsMu.RUnlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("unknown target '%s'", url))
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, m.HAR(url))
+}
+
+// WriteHAR writes the recent checks of every target to path as a HAR file.
+func (m *Monitor) WriteHAR(path string) error {
+	data, err := json.MarshalIndent(m.HAR(""), "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding HAR: %v", err)
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("writing HAR file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// Histogram buckets grow by 10% from 1ms, which keeps percentile estimates
+// within 10% of the true value up to about two minutes.
+const (
+	histogramBase    = time.Millisecond
+	histogramGrowth  = 1.1
+	histogramBuckets = 128
+)
+
+var histogramBounds = func() []time.Duration {
+	bounds := make([]time.Duration, histogramBuckets)
+	bound := float64(histogramBase)
+	for i := range bounds {
+		bounds[i] = time.Duration(bound)
+		bound *= histogramGrowth
+	}
+	return bounds
+}()
+
+// latencyHistogram counts durations in logarithmic buckets. Bucket i holds
+// durations up to histogramBounds[i]; the last bucket also takes anything
+// larger.
+type latencyHistogram struct {
+	counts [histogramBuckets]int64
+	total  int64
+}
+
+func histogramBucket(d time.Duration) int {
+	if d <= histogramBase {
+		return 0
+	}
+	i := int(math.Ceil(math.Log(float64(d)/float64(histogramBase)) / math.Log(histogramGrowth)))
+	// Guard against rounding at the bucket edges.
+	for i > 0 && histogramBounds[min(i, histogramBuckets-1)-1] >= d {
+		i--
+	}
+	return min(i, histogramBuckets-1)
+}
+
+func (h *latencyHistogram) add(d time.Duration) {
+	h.counts[histogramBucket(d)]++
+	h.total++
+}
+
+func (h *latencyHistogram) merge(other *latencyHistogram) {
+	for i, n := range other.counts {
+		h.counts[i] += n
+	}
+	h.total += other.total
+}
+
+// Percentile returns the upper bound of the bucket containing the p-th
+// percentile, or 0 when nothing has been recorded.
+func (h *latencyHistogram) Percentile(p float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+
+	rank := int64(math.Ceil(p / 100 * float64(h.total)))
+	rank = max(rank, 1)
+
+	var seen int64
+	for i, n := range h.counts {
+		seen += n
+		if seen >= rank {
+			return histogramBounds[i]
+		}
+	}
+	return histogramBounds[histogramBuckets-1]
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRecentChecks = 30
+	// maxRecentChecks bounds the ring, which holds every check's headers
+	// and phases.
+	maxRecentChecks = 1000
+	// degradedChecks is how many of the latest checks decide whether a
+	// target is degraded, however many are kept.
+	degradedChecks = 30
+	finalFailures  = 5
+)
+
+// checkRing keeps the results of the most recent checks, overwriting the
+// oldest once it is full.
+type checkRing struct {
+	checks []CheckResult
+	next   int
+	full   bool
+}
+
+func newCheckRing(size int) *checkRing {
+	return &checkRing{checks: make([]CheckResult, size)}
+}
+
+func (r *checkRing) add(check CheckResult) {
+	if len(r.checks) == 0 {
+		return
+	}
+	r.checks[r.next] = check
+	r.next = (r.next + 1) % len(r.checks)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// list returns a copy of the checks, oldest first. GetSnapshot copies the
+// ring, but window snapshots have none, so a nil ring lists nothing.
+func (r *checkRing) list() []CheckResult {
+	if r == nil {
+		return nil
+	}
+	if !r.full {
+		return slices.Clone(r.checks[:r.next])
+	}
+	return append(slices.Clone(r.checks[r.next:]), r.checks[:r.next]...)
+}
+
+// resized returns a ring of the given size holding the newest checks of r.
+func (r *checkRing) resized(size int) *checkRing {
+	ring := newCheckRing(size)
+	checks := r.list()
+	for _, check := range checks[max(0, len(checks)-size):] {
+		ring.add(check)
+	}
+	return ring
+}
+
+// SetRecentChecks sets how many check results are kept for RecentChecks.
+func (s *URLStats) SetRecentChecks(size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.recent = s.recent.resized(size)
+}
+
+// RecentChecks returns the results of the most recent checks, oldest first.
+func (s *URLStats) RecentChecks() []CheckResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.recent.list()
+}
+
+// CheckRecord is a check result of one target, as listed by the API.
+type CheckRecord struct {
+	URL        string    `json:"url"`
+	TargetID   string    `json:"target_id"`
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Duration   Duration  `json:"duration"`
+	Size       int64     `json:"size"`
+	Success    bool      `json:"success"`
+	Error      string    `json:"error,omitempty"`
+	ErrorKind  string    `json:"error_kind,omitempty"`
+	Snapshot   string    `json:"snapshot,omitempty"`
+}
+
+// Checks returns the recent checks of every target, or only of url when it
+// is not empty, ordered by time.
+func (m *Monitor) Checks(url string) []CheckRecord {
+	m.statsMu.RLock()
+	defer m.statsMu.RUnlock()
+
+	records := []CheckRecord{}
+	for _, u := range m.urls {
+		if url != "" && u != url {
+			continue
+		}
+		for _, check := range m.stats[u].RecentChecks() {
+			records = append(records, CheckRecord{
+				URL:        u,
+				TargetID:   m.targets[u].ID,
+				Time:       check.Time,
+				StatusCode: check.StatusCode,
+				Duration:   Duration{check.Duration},
+				Size:       check.BodySize,
+				Success:    check.Success,
+				Error:      check.Error,
+				ErrorKind:  check.ErrorKind,
+				Snapshot:   check.Snapshot,
+			})
+		}
+	}
+	slices.SortStableFunc(records, func(a, b CheckRecord) int { return a.Time.Compare(b.Time) })
+	return records
+}
+
+func (m *Monitor) handleListChecks(w http.ResponseWriter, r *http.Request) {
+	url := r.URL.Query().Get("url")
+	if url != "" {
+		m.statsMu.RLock()
+		_, ok := m.stats[url]
+		m.statsMu.RUnlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("unknown target '%s'", url))
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, m.Checks(url))
+}
+
+// renderRecentFailures lists the last few failed checks of each target for
+// the final report.
+func renderRecentFailures(b *strings.Builder, frame Frame) {
+	header := false
+	for _, row := range frame.Rows {
+		var failures []CheckResult
+		for _, check := range row.recent {
+			if !check.Success {
+				failures = append(failures, check)
+			}
+		}
+		if len(failures) == 0 {
+			continue
+		}
+
+		if !header {
+			b.WriteString("\nRecent failures:\n")
+			header = true
+		}
+		fmt.Fprintf(b, "  %s:\n", row.url)
+		for _, check := range failures[max(0, len(failures)-finalFailures):] {
+			status := "-"
+			if check.StatusCode != 0 {
+				status = fmt.Sprint(check.StatusCode)
+			}
+			fmt.Fprintf(b, "    %s  %-4s %-8s %s\n", check.Time.Format("15:04:05"), status, formatDuration(check.Duration), check.Error)
+			if check.Snapshot != "" {
+				fmt.Fprintf(b, "      response saved to %s\n", check.Snapshot)
+			}
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHookTimeout     = 30 * time.Second
+	defaultHookMinInterval = 5 * time.Minute
+	maxHookOutput          = 64 << 10
+	maxHookRuns            = 50
+)
+
+// HookConfig runs a command when a target goes down or recovers. Command is
+// run directly, without a shell. A hook runs at most once per MinInterval
+// for each target and state, so that a recovery is never held back by the
+// outage it ends; runs in between are recorded as skipped, and the last one
+// skipped runs once MinInterval has passed if the state has not changed.
+type HookConfig struct {
+	Command     []string `json:"command"`
+	On          []string `json:"on,omitempty"`
+	Timeout     Duration `json:"timeout,omitempty"`
+	MinInterval Duration `json:"min_interval,omitempty"`
+}
+
+func (c *HookConfig) validate() error {
+	if len(c.Command) == 0 || c.Command[0] == "" {
+		return fmt.Errorf("command is required")
+	}
+	if len(c.On) == 0 {
+		c.On = []string{stateDown, stateUp}
+	}
+	for _, state := range c.On {
+		if state != stateDown && state != stateUp {
+			return fmt.Errorf("on must be '%s' or '%s', got '%s'", stateDown, stateUp, state)
+		}
+	}
+	if c.Timeout.Duration == 0 {
+		c.Timeout.Duration = defaultHookTimeout
+	}
+	if c.MinInterval.Duration == 0 {
+		c.MinInterval.Duration = defaultHookMinInterval
+	}
+	if c.Timeout.Duration < 0 || c.MinInterval.Duration < 0 {
+		return fmt.Errorf("timeout and min_interval must not be negative")
+	}
+	return nil
+}
+
+// shellHook runs command with /bin/sh, for hooks given on the command line.
+func shellHook(command string, state string) HookConfig {
+	return HookConfig{Command: []string{"/bin/sh", "-c", command}, On: []string{state}}
+}
+
+// HookRun is the outcome of one hook execution.
+type HookRun struct {
+	Time     time.Time `json:"time"`
+	Command  string    `json:"command"`
+	URL      string    `json:"url"`
+	TargetID string    `json:"target_id"`
+	State    string    `json:"state"`
+	Skipped  bool      `json:"skipped,omitempty"`
+	Duration Duration  `json:"duration"`
+	ExitCode int       `json:"exit_code"`
+	Output   string    `json:"output,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+func (r HookRun) Failed() bool {
+	return !r.Skipped && (r.ExitCode != 0 || r.Error != "")
+}
+
+func (r HookRun) String() string {
+	status := fmt.Sprintf("exit %d", r.ExitCode)
+	switch {
+	case r.Skipped:
+		status = "skipped, ran less than min_interval ago"
+	case r.Error != "":
+		status = r.Error
+	}
+	return fmt.Sprintf("%s %s on %s: %s (%s)", r.Time.Format("15:04:05"), r.Command, r.TargetID, status, formatDuration(r.Duration.Duration))
+}
+
+// hookRunner starts hooks in the background and keeps a log of their runs.
+type hookRunner struct {
+	hooks []HookConfig
+
+	mu      sync.Mutex
+	lastRun map[string]time.Time
+	pending map[string]*pendingHook
+	runs    []HookRun
+	closed  bool
+	running sync.WaitGroup
+
+	// recorded is called with every run, for alerting.
+	recorded func(HookRun)
+}
+
+func newHookRunner(hooks []HookConfig) *hookRunner {
+	return &hookRunner{hooks: hooks, lastRun: make(map[string]time.Time), pending: make(map[string]*pendingHook), recorded: func(HookRun) {}}
+}
+
+// pendingHook is a skipped run waiting for the end of its MinInterval.
+type pendingHook struct {
+	event StateEvent
+	timer *time.Timer
+}
+
+// trigger starts every hook that applies to the event without waiting for
+// it to finish.
+func (h *hookRunner) trigger(event StateEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return
+	}
+
+	// The target left the state any pending runs were held back for.
+	for key, pending := range h.pending {
+		if pending.event.URL == event.URL && pending.event.State != event.State {
+			pending.timer.Stop()
+			delete(h.pending, key)
+		}
+	}
+
+	for i, hook := range h.hooks {
+		if !slices.Contains(hook.On, event.State) {
+			continue
+		}
+
+		run := HookRun{
+			Time:     event.Time,
+			Command:  strings.Join(hook.Command, " "),
+			URL:      event.URL,
+			TargetID: event.TargetID,
+			State:    event.State,
+		}
+
+		key := strconv.Itoa(i) + " " + event.State + " " + event.URL
+		if last, ok := h.lastRun[key]; ok && event.Time.Sub(last) < hook.MinInterval.Duration {
+			run.Skipped = true
+			h.record(run)
+			h.hold(key, hook, event, last.Add(hook.MinInterval.Duration).Sub(event.Time))
+			continue
+		}
+		h.lastRun[key] = event.Time
+		h.start(hook, event, run)
+	}
+}
+
+// hold runs the latest skipped event for key after wait, unless the state
+// changes or the runner is closed first. The caller holds h.mu.
+func (h *hookRunner) hold(key string, hook HookConfig, event StateEvent, wait time.Duration) {
+	if pending, ok := h.pending[key]; ok {
+		pending.event = event
+		return
+	}
+
+	pending := &pendingHook{event: event}
+	pending.timer = time.AfterFunc(wait, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if h.closed || h.pending[key] != pending {
+			return
+		}
+		delete(h.pending, key)
+
+		now := time.Now()
+		h.lastRun[key] = now
+		h.start(hook, pending.event, HookRun{
+			Time:     now,
+			Command:  strings.Join(hook.Command, " "),
+			URL:      pending.event.URL,
+			TargetID: pending.event.TargetID,
+			State:    pending.event.State,
+		})
+	})
+	h.pending[key] = pending
+}
+
+// start runs the hook in the background. The caller holds h.mu.
+func (h *hookRunner) start(hook HookConfig, event StateEvent, run HookRun) {
+	h.running.Add(1)
+	go func() {
+		defer h.running.Done()
+
+		run = runHook(hook, event, run)
+		h.mu.Lock()
+		h.record(run)
+		h.mu.Unlock()
+	}()
+}
+
+// record adds a run to the log. The caller holds h.mu.
+func (h *hookRunner) record(run HookRun) {
+	h.runs = append(h.runs, run)
+	if len(h.runs) > maxHookRuns {
+		h.runs = h.runs[len(h.runs)-maxHookRuns:]
+	}
+	h.recorded(run)
+}
+
+func (h *hookRunner) Runs() []HookRun {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]HookRun(nil), h.runs...)
+}
+
+// close stops new hooks from starting, including skipped ones still
+// pending, and waits for running ones, so that remediation started before
+// shutdown is not cut short.
+func (h *hookRunner) close() {
+	h.mu.Lock()
+	h.closed = true
+	for _, pending := range h.pending {
+		pending.timer.Stop()
+	}
+	clear(h.pending)
+	h.mu.Unlock()
+
+	h.running.Wait()
+}
+
+// runHook executes the hook with the event as JSON on stdin and as
+// WEB_MONITOR_* environment variables.
+func runHook(hook HookConfig, event StateEvent, run HookRun) HookRun {
+	ctx, cancel := context.WithTimeout(context.Background(), hook.Timeout.Duration)
+	defer cancel()
+
+	input, _ := json.Marshal(event)
+
+	cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Env = append(os.Environ(),
+		"WEB_MONITOR_URL="+event.URL,
+		"WEB_MONITOR_TARGET_ID="+event.TargetID,
+		"WEB_MONITOR_STATE="+event.State,
+		"WEB_MONITOR_ERROR="+event.Error,
+		"WEB_MONITOR_TIME="+event.Time.Format(time.RFC3339),
+		"WEB_MONITOR_INCIDENT_START="+event.Incident.Start.Format(time.RFC3339),
+		"WEB_MONITOR_INCIDENT_CHECKS="+strconv.FormatInt(event.Incident.Checks, 10),
+	)
+	output := &limitedBuffer{limit: maxHookOutput}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	// Don't wait forever for children that keep the output open.
+	cmd.WaitDelay = time.Second
+
+	start := time.Now()
+	err := cmd.Run()
+	run.Duration = Duration{time.Since(start)}
+	run.Output = output.String()
+
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		run.ExitCode = -1
+		run.Error = fmt.Sprintf("timed out after %s", hook.Timeout)
+	case errors.As(err, &exitErr):
+		run.ExitCode = exitErr.ExitCode()
+	case err != nil:
+		run.ExitCode = -1
+		run.Error = err.Error()
+	}
+	return run
+}
+
+// limitedBuffer keeps the first limit bytes written to it and discards the
+// rest.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room < len(p) {
+		b.truncated = true
+		b.Buffer.Write(p[:max(room, 0)])
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+func (b *limitedBuffer) String() string {
+	if b.truncated {
+		return b.Buffer.String() + "\n[output truncated]"
+	}
+	return b.Buffer.String()
+}
+
+// hookAlert records failed hook runs as alerts.
+func (m *Monitor) hookAlert(run HookRun) {
+	if !run.Failed() {
+		return
+	}
+	m.recordAlert(Alert{Time: run.Time, URL: run.URL, Severity: "hook", Message: run.String()})
+}
+
+func (m *Monitor) hookRuns() []HookRun {
+	if m.hooks == nil {
+		return nil
+	}
+	return m.hooks.Runs()
+}
+
+func (m *Monitor) handleListHooks(w http.ResponseWriter, r *http.Request) {
+	runs := m.hookRuns()
+	if runs == nil {
+		runs = []HookRun{}
+	}
+	writeJSON(w, http.StatusOK, runs)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Only the most recent incidents are kept per target.
+const maxIncidents = 20
+
+// Incident is a period during which every check of a target failed. It
+// opens with the first failed check and closes with the next success.
+type Incident struct {
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end,omitzero"`
+	FirstError string    `json:"first_error,omitempty"`
+	Checks     int64     `json:"checks"`
+	// Interrupted marks incidents that were still ongoing when monitoring
+	// stopped. Their end is the last time the target was known to be down.
+	Interrupted bool `json:"interrupted,omitempty"`
+}
+
+func (i Incident) Ongoing() bool {
+	return i.End.IsZero()
+}
+
+// Duration is the length of the incident, up to now while it is ongoing.
+func (i Incident) Duration(now time.Time) time.Duration {
+	if i.Ongoing() {
+		return now.Sub(i.Start)
+	}
+	return i.End.Sub(i.Start)
+}
+
+// trackIncident opens, extends or closes the latest incident for a check
+// result. The caller holds s.mu.
+func (s *URLStats) trackIncident(result CheckResult) {
+	open := len(s.incidents) > 0 && s.incidents[len(s.incidents)-1].Ongoing()
+
+	if result.Success {
+		if open {
+			s.incidents[len(s.incidents)-1].End = result.Time
+		}
+		return
+	}
+
+	if !open {
+		s.incidents = append(s.incidents, Incident{Start: result.Time, FirstError: result.Error})
+		if len(s.incidents) > maxIncidents {
+			s.incidents = s.incidents[len(s.incidents)-maxIncidents:]
+		}
+	}
+	s.incidents[len(s.incidents)-1].Checks++
+}
+
+// Incidents returns the most recent incidents, oldest first.
+func (s *URLStats) Incidents() []Incident {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]Incident(nil), s.incidents...)
+}
+
+// LastIncident returns the most recent incident, if there was one.
+func (s *URLStats) LastIncident() (Incident, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.incidents) == 0 {
+		return Incident{}, false
+	}
+	return s.incidents[len(s.incidents)-1], true
+}
+
+// restoreIncidents merges incidents saved by a previous run at saved into
+// the history, skipping any that are already known. Incidents that were
+// still ongoing are closed at saved and marked interrupted, so that the
+// time the monitor was not running does not count as downtime. The caller
+// holds s.mu.
+func (s *URLStats) restoreIncidents(incidents []Incident, saved time.Time) {
+	for _, incident := range incidents {
+		if incident.Ongoing() {
+			incident.End, incident.Interrupted = saved, true
+		}
+		known := slices.ContainsFunc(s.incidents, func(i Incident) bool { return i.Start.Equal(incident.Start) })
+		if !known {
+			s.incidents = append(s.incidents, incident)
+		}
+	}
+	slices.SortStableFunc(s.incidents, func(a, b Incident) int { return a.Start.Compare(b.Start) })
+	if len(s.incidents) > maxIncidents {
+		s.incidents = s.incidents[len(s.incidents)-maxIncidents:]
+	}
+}
+
+// IncidentRecord is an incident of one target, as listed by the API and
+// written to the incident log. Logged is when it was written to the log.
+type IncidentRecord struct {
+	URL      string    `json:"url"`
+	TargetID string    `json:"target_id"`
+	Logged   time.Time `json:"logged,omitzero"`
+	Incident
+}
+
+// Incidents returns the recent incidents of every target, or only of url
+// when it is not empty, ordered by start.
+func (m *Monitor) Incidents(url string) []IncidentRecord {
+	m.statsMu.RLock()
+	defer m.statsMu.RUnlock()
+
+	records := []IncidentRecord{}
+	for _, u := range m.urls {
+		if url != "" && u != url {
+			continue
+		}
+		for _, incident := range m.stats[u].Incidents() {
+			records = append(records, IncidentRecord{URL: u, TargetID: m.targets[u].ID, Incident: incident})
+		}
+	}
+	slices.SortStableFunc(records, func(a, b IncidentRecord) int { return a.Start.Compare(b.Start) })
+	return records
+}
+
+func (m *Monitor) handleListIncidents(w http.ResponseWriter, r *http.Request) {
+	url := r.URL.Query().Get("url")
+	if url != "" {
+		m.statsMu.RLock()
+		_, ok := m.stats[url]
+		m.statsMu.RUnlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("unknown target '%s'", url))
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, m.Incidents(url))
+}
+
+// incidentLog appends every finished incident to a JSON Lines file, which
+// keeps the full history where the stats only keep the last maxIncidents.
+type incidentLog struct {
+	path string
+	mu   sync.Mutex
+}
+
+func (l *incidentLog) append(logged time.Time, records ...IncidentRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("writing incident log: %v", err)
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, record := range records {
+		record.Logged = logged
+		if err := enc.Encode(record); err != nil {
+			return fmt.Errorf("writing incident log: %v", err)
+		}
+	}
+	return nil
+}
+
+// loadIncidentLog restores the incidents of monitored targets from the
+// incident log. A missing log is not an error.
+func (m *Monitor) loadIncidentLog() error {
+	f, err := os.Open(m.incidentLog.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading incident log: %v", err)
+	}
+	defer f.Close()
+
+	byURL := make(map[string][]Incident)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var record IncidentRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return fmt.Errorf("parsing incident log line %d: %v", line, err)
+		}
+		// An incident logged while still open is closed at the time it was logged.
+		if record.Ongoing() {
+			if record.Logged.IsZero() {
+				continue
+			}
+			record.End, record.Interrupted = record.Logged, true
+		}
+		byURL[record.URL] = append(byURL[record.URL], record.Incident)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading incident log: %v", err)
+	}
+
+	m.statsMu.RLock()
+	defer m.statsMu.RUnlock()
+
+	for url, incidents := range byURL {
+		if stat, ok := m.stats[url]; ok {
+			stat.mu.Lock()
+			stat.restoreIncidents(incidents[max(0, len(incidents)-maxIncidents):], time.Time{})
+			stat.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+// incidentClosed appends the incident a successful check just ended to the
+// incident log.
+func (m *Monitor) incidentClosed(url string, result CheckResult) {
+	if m.incidentLog == nil || !result.Success {
+		return
+	}
+
+	m.statsMu.RLock()
+	stat := m.stats[url]
+	id := m.targets[url].ID
+	m.statsMu.RUnlock()
+
+	incident, ok := stat.LastIncident()
+	if !ok || !incident.End.Equal(result.Time) {
+		return
+	}
+	if err := m.incidentLog.append(time.Now(), IncidentRecord{URL: url, TargetID: id, Incident: incident}); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+}
+
+// FlushIncidentLog writes incidents still ongoing at now to the incident
+// log, marked as interrupted, so that the log covers the whole run.
+func (m *Monitor) FlushIncidentLog(now time.Time) error {
+	if m.incidentLog == nil {
+		return nil
+	}
+
+	var ongoing []IncidentRecord
+	for _, record := range m.Incidents("") {
+		if record.Ongoing() {
+			record.End, record.Interrupted = now, true
+			ongoing = append(ongoing, record)
+		}
+	}
+	return m.incidentLog.append(now, ongoing...)
+}
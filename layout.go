@@ -0,0 +1,415 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// tableColumn is one column of the statistics table. Columns are looked up
+// by name for -columns.
+type tableColumn struct {
+	name   string
+	header string
+	value  func(row tableRow) string
+	less   func(a, b tableRow) bool
+}
+
+var tableColumns = []tableColumn{
+	{
+		name: "url", header: "URL",
+		value: func(r tableRow) string { return r.url },
+		less:  func(a, b tableRow) bool { return a.url < b.url },
+	},
+	{
+		name: "min", header: "Duration Min",
+		value: func(r tableRow) string { return formatDuration(r.snapshot.MinDuration) },
+		less:  func(a, b tableRow) bool { return a.snapshot.MinDuration < b.snapshot.MinDuration },
+	},
+	{
+		name: "avg", header: "Duration Avg",
+		value: func(r tableRow) string { return formatDuration(r.snapshot.AverageDuration()) },
+		less:  func(a, b tableRow) bool { return a.snapshot.AverageDuration() < b.snapshot.AverageDuration() },
+	},
+	{
+		name: "max", header: "Duration Max",
+		value: func(r tableRow) string { return formatDuration(r.snapshot.MaxDuration) },
+		less:  func(a, b tableRow) bool { return a.snapshot.MaxDuration < b.snapshot.MaxDuration },
+	},
+	{
+		name: "size-min", header: "Size Min",
+		value: func(r tableRow) string { return formatSize(r.snapshot.MinSize) },
+		less:  func(a, b tableRow) bool { return a.snapshot.MinSize < b.snapshot.MinSize },
+	},
+	{
+		name: "size-avg", header: "Size Avg",
+		value: func(r tableRow) string { return formatSize(r.snapshot.AverageSize()) },
+		less:  func(a, b tableRow) bool { return a.snapshot.AverageSize() < b.snapshot.AverageSize() },
+	},
+	{
+		name: "size-max", header: "Size Max",
+		value: func(r tableRow) string {
+			maxSize := formatSize(r.snapshot.MaxSize)
+			if r.snapshot.TruncatedBodies > 0 {
+				// The largest body was cut off at the size limit.
+				maxSize = ">" + maxSize
+			}
+			return maxSize
+		},
+		less: func(a, b tableRow) bool { return a.snapshot.MaxSize < b.snapshot.MaxSize },
+	},
+	{
+		name: "ok", header: "OK",
+		value: func(r tableRow) string {
+			okRatio := fmt.Sprintf("%d/%d", r.snapshot.SuccessCount, r.snapshot.TotalRequests)
+			if r.snapshot.ExcludedFailures > 0 {
+				okRatio += fmt.Sprintf(" +%d maint", r.snapshot.ExcludedFailures)
+			}
+			return okRatio
+		},
+		less: func(a, b tableRow) bool {
+			return percent(a.snapshot.SuccessCount, a.snapshot.TotalRequests) <
+				percent(b.snapshot.SuccessCount, b.snapshot.TotalRequests)
+		},
+	},
+	percentileColumn(50),
+	percentileColumn(90),
+	percentileColumn(95),
+	percentileColumn(99),
+	{
+		name: "stddev", header: "Std Dev",
+		value: func(r tableRow) string { return formatDuration(r.snapshot.StdDev()) },
+		less:  func(a, b tableRow) bool { return a.snapshot.StdDev() < b.snapshot.StdDev() },
+	},
+	{
+		name: "cv", header: "CV",
+		value: func(r tableRow) string {
+			if r.snapshot.TotalRequests < 2 {
+				return "-"
+			}
+			return fmt.Sprintf("%.0f%%", r.snapshot.CoefficientOfVariation()*100)
+		},
+		less: func(a, b tableRow) bool {
+			return a.snapshot.CoefficientOfVariation() < b.snapshot.CoefficientOfVariation()
+		},
+	},
+	{
+		name: "jitter", header: "Jitter",
+		value: func(r tableRow) string { return formatDuration(r.snapshot.Jitter()) },
+		less:  func(a, b tableRow) bool { return a.snapshot.Jitter() < b.snapshot.Jitter() },
+	},
+	{
+		name: "apdex", header: "Apdex",
+		value: func(r tableRow) string { return formatApdex(r.snapshot.Apdex()) },
+		less:  func(a, b tableRow) bool { return apdexRank(a.snapshot.Apdex()) < apdexRank(b.snapshot.Apdex()) },
+	},
+	{
+		name: "apdex-1h", header: "Apdex 1h",
+		value: func(r tableRow) string { return formatApdex(r.recentCounts.Apdex()) },
+		less:  func(a, b tableRow) bool { return apdexRank(a.recentCounts.Apdex()) < apdexRank(b.recentCounts.Apdex()) },
+	},
+	{
+		name: "errors", header: "Errors",
+		value: func(r tableRow) string { return strconv.FormatInt(r.snapshot.ErrorTotal(), 10) },
+		less:  func(a, b tableRow) bool { return a.snapshot.ErrorTotal() < b.snapshot.ErrorTotal() },
+	},
+	{
+		name: "state", header: "State",
+		value: func(r tableRow) string { return r.state },
+		less:  func(a, b tableRow) bool { return stateRank(a.state) < stateRank(b.state) },
+	},
+	{
+		name: "last", header: "Last Check",
+		value: func(r tableRow) string {
+			if len(r.recent) == 0 {
+				return "-"
+			}
+			return r.recent[len(r.recent)-1].Time.Format("15:04:05")
+		},
+		less: func(a, b tableRow) bool { return a.lastCheck().Before(b.lastCheck()) },
+	},
+}
+
+func percentileColumn(p float64) tableColumn {
+	return tableColumn{
+		name:   fmt.Sprintf("p%g", p),
+		header: fmt.Sprintf("P%g", p),
+		value:  func(r tableRow) string { return formatDuration(r.snapshot.Percentile(p)) },
+		less:   func(a, b tableRow) bool { return a.snapshot.Percentile(p) < b.snapshot.Percentile(p) },
+	}
+}
+
+var defaultColumns = []string{"url", "min", "avg", "max", "size-min", "size-avg", "size-max", "ok"}
+
+func lookupColumns(names []string) ([]tableColumn, error) {
+	var columns []tableColumn
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, column := range tableColumns {
+			if column.name == name {
+				columns = append(columns, column)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown column '%s', expected one of %s", name, columnNames())
+		}
+	}
+	return columns, nil
+}
+
+func columnNames() string {
+	var names []string
+	for _, column := range tableColumns {
+		names = append(names, column.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Row states, used for colouring and the TUI state filter.
+const (
+	statePending  = "pending"
+	stateUp       = "up"
+	stateDegraded = "degraded"
+	stateDown     = "down"
+)
+
+type tableRow struct {
+	url      string
+	snapshot *URLStats
+	state    string
+	alerting bool
+	recent   []CheckResult
+
+	// recentCounts are the counts over the last apdexWindow.
+	recentCounts windowBucket
+
+	slo         *SLO
+	sloStatus   *SLOStatus
+	lastChange  *ContentChange
+	maintenance *MaintenanceWindow
+
+	// incident is set for the rows of the incident list instead.
+	incident *IncidentRecord
+}
+
+func (r tableRow) lastCheck() time.Time {
+	if len(r.recent) == 0 {
+		return time.Time{}
+	}
+	return r.recent[len(r.recent)-1].Time
+}
+
+// stateRank orders states from healthy to down.
+func stateRank(state string) int {
+	switch state {
+	case stateUp:
+		return 1
+	case stateDegraded:
+		return 2
+	case stateDown:
+		return 3
+	}
+	return 0
+}
+
+// tableRows collects a row per target in configuration order. A target is
+// down when its last check failed and degraded when it is up but has an
+// active burn-rate alert or failed one of its recent checks.
+func (m *Monitor) tableRows() []tableRow {
+	now := time.Now()
+
+	m.statsMu.RLock()
+	defer m.statsMu.RUnlock()
+
+	var rows []tableRow
+	for _, url := range m.urls {
+		stat := m.stats[url]
+		snapshot := stat.GetSnapshot()
+		row := tableRow{
+			url:      url,
+			snapshot: &snapshot,
+			state:    statePending,
+			recent:   stat.RecentChecks(),
+
+			recentCounts: stat.Window(now, apdexWindow),
+		}
+		if status, ok := stat.SLOStatus(now); ok {
+			row.slo = stat.SLO()
+			row.sloStatus = &status
+			row.alerting = status.Alert != BurnNone
+		}
+		if changes := stat.RecentChanges(); len(changes) > 0 {
+			row.lastChange = &changes[len(changes)-1]
+		}
+		row.maintenance = m.maintenanceWindow(url, now)
+
+		if len(row.recent) > 0 {
+			row.state = stateUp
+			if !row.recent[len(row.recent)-1].Success {
+				row.state = stateDown
+			} else if row.alerting {
+				row.state = stateDegraded
+			} else {
+				for _, check := range row.recent[max(0, len(row.recent)-degradedChecks):] {
+					if !check.Success {
+						row.state = stateDegraded
+						break
+					}
+				}
+			}
+		}
+
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// tableLayout renders rows with the chosen columns, fitted to width. The URL
+// column shrinks first; if the table still does not fit, columns are dropped
+// from the right.
+type tableLayout struct {
+	columns []tableColumn
+	width   int
+	color   bool
+}
+
+const minURLWidth = 16
+
+func (l tableLayout) visibleColumns() []tableColumn {
+	if len(l.columns) == 0 {
+		columns, _ := lookupColumns(defaultColumns)
+		return columns
+	}
+	return l.columns
+}
+
+func (l tableLayout) render(b *strings.Builder, rows []tableRow, prefix func(i int) string) {
+	columns := l.visibleColumns()
+
+	cells := make([][]string, len(rows))
+	widths := make([]int, len(columns))
+	for j, column := range columns {
+		widths[j] = utf8.RuneCountInString(column.header)
+	}
+	for i, row := range rows {
+		cells[i] = make([]string, len(columns))
+		for j, column := range columns {
+			cells[i][j] = column.value(row)
+			widths[j] = max(widths[j], utf8.RuneCountInString(cells[i][j]))
+		}
+	}
+
+	prefixWidth := 0
+	if prefix != nil {
+		prefixWidth = utf8.RuneCountInString(prefix(-1))
+	}
+
+	if l.width > 0 {
+		total := func() int {
+			sum := prefixWidth
+			for _, w := range widths {
+				sum += w + 1
+			}
+			return sum - 1
+		}
+
+		for j, column := range columns {
+			if column.name == "url" && total() > l.width {
+				widths[j] = max(minURLWidth, widths[j]-(total()-l.width))
+			}
+		}
+		for len(columns) > 1 && total() > l.width {
+			columns, widths = columns[:len(columns)-1], widths[:len(widths)-1]
+		}
+	}
+
+	line := func(values []string) string {
+		var sb strings.Builder
+		for j := range columns {
+			if j > 0 {
+				sb.WriteByte(' ')
+			}
+			value := truncate(values[j], widths[j])
+			sb.WriteString(value)
+			if j < len(columns)-1 {
+				sb.WriteString(strings.Repeat(" ", widths[j]-utf8.RuneCountInString(value)))
+			}
+		}
+		return sb.String()
+	}
+
+	headers := make([]string, len(columns))
+	separators := make([]string, len(columns))
+	for j, column := range columns {
+		headers[j] = column.header
+		separators[j] = strings.Repeat("─", widths[j])
+	}
+
+	if prefix != nil {
+		b.WriteString(prefix(-1))
+	}
+	b.WriteString(line(headers) + "\n")
+	if prefix != nil {
+		b.WriteString(prefix(-1))
+	}
+	b.WriteString(line(separators) + "\n")
+
+	for i, row := range rows {
+		if prefix != nil {
+			b.WriteString(prefix(i))
+		}
+		text := line(cells[i])
+		if l.color {
+			text = colorize(row.state, text)
+		}
+		b.WriteString(text + "\n")
+	}
+}
+
+// truncate shortens s to width runes, marking the cut with an ellipsis.
+func truncate(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	if width <= 1 {
+		return string([]rune(s)[:width])
+	}
+	return string([]rune(s)[:width-1]) + "…"
+}
+
+const (
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+)
+
+func colorize(state, text string) string {
+	switch state {
+	case stateUp:
+		return colorGreen + text + colorReset
+	case stateDegraded:
+		return colorYellow + text + colorReset
+	case stateDown:
+		return colorRed + text + colorReset
+	}
+	return text
+}
+
+// terminalWidth returns the width of the terminal on stdout, falling back to
+// $COLUMNS and then 0, which disables fitting.
+func terminalWidth() int {
+	if width := terminalColumns(int(os.Stdout.Fd())); width > 0 {
+		return width
+	}
+	if width, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && width > 0 {
+		return width
+	}
+	return 0
+}
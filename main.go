@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"net/url"
 	"os"
@@ -9,31 +10,390 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"time"
+	_ "time/tzdata"
 )
 
 func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	urls, err := validateURLs(os.Args[1:])
+	cfg, err := parseFlags(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	urls, err := validateURLs(append(cfg.urls(), flag.Args()...))
 	if err != nil {
 		usageExample()
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 
-	monitor := NewMonitor(urls)
+	monitor := NewMonitorFromConfig(urls, cfg)
+	// The incident log goes first: it knows how incidents that were
+	// ongoing when the previous run stopped ended.
+	if cfg.IncidentLog != "" {
+		if err := monitor.loadIncidentLog(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if cfg.BaselineFile != "" {
+		if err := monitor.loadBaselines(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if cfg.StatusPage != nil {
+		if err := monitor.loadStatusHistory(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
 	var wg sync.WaitGroup
 
+	if cfg.Listen != "" {
+		if err := monitor.Serve(ctx, &wg, cfg.Listen); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	monitor.Start(ctx, &wg)
 
 	<-ctx.Done()
-	fmt.Println("\nShutting down gracefully...")
+	fmt.Fprintln(os.Stderr, "\nShutting down gracefully...")
 
 	wg.Wait()
 
 	monitor.DisplayFinalTable()
+
+	if err := monitor.FlushIncidentLog(time.Now()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+	if err := monitor.SaveBaselines(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+
+	if cfg.HARFile != "" {
+		if err := monitor.WriteHAR(cfg.HARFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "HAR written to %s\n", cfg.HARFile)
+	}
+
+	if cfg.ReportFile != "" {
+		if err := monitor.WriteReport(cfg.ReportFile, cfg.ReportFormat); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Report written to %s\n", cfg.ReportFile)
+	}
+}
+
+func parseFlags(args []string) (*Config, error) {
+	configPath := flag.String("config", "", "path to a JSON config file with targets and SLOs")
+	availability := flag.Float64("slo", 0, "default availability objective in percent, e.g. 99.9")
+	latency := flag.Duration("slo-latency", 0, "default latency objective threshold, e.g. 300ms")
+	latencyTarget := flag.Float64("slo-latency-target", 95, "percent of requests that must finish within -slo-latency")
+	window := Duration{defaultSLOWindow}
+	flag.Var(&window, "slo-window", "SLO compliance window, e.g. 30d")
+	listen := flag.String("listen", "", "address for the control API, e.g. :8080")
+	var silences stringList
+	flag.Var(&silences, "silence", "silence alerts for [url=]duration, may be repeated")
+	maxInFlight := flag.Int("max-in-flight", 50, "maximum number of checks running at once, 0 for no limit")
+	hostRate := flag.Float64("host-rate", 0, "maximum checks per second to a single host, 0 for no limit")
+	startJitter := Duration{5 * time.Second}
+	flag.Var(&startJitter, "start-jitter", "spread the first check of each target randomly over this period")
+	var maxBodySize Size
+	flag.Var(&maxBodySize, "max-body-size", "stop reading response bodies after this many bytes, e.g. 10MB (default 10MB)")
+	hashBody := flag.Bool("hash-body", false, "compute a SHA-256 hash of each response body")
+	noColor := flag.Bool("no-color", false, "disable coloured output (also disabled by the NO_COLOR environment variable)")
+	var columns stringList
+	flag.Var(&columns, "columns", "comma-separated table columns: "+columnNames())
+	format := flag.String("format", "", "output format: "+strings.Join(formats, ", ")+" (default table)")
+	reportFile := flag.String("report-file", "", "write the final statistics to this file (.csv, .md or .html)")
+	reportFormatFlag := flag.String("report-format", "", "report format when it can't be told from the file name: "+strings.Join(reportFormats, ", "))
+	statusDir := flag.String("status-dir", "", "periodically write a static HTML status page to this directory")
+	statusInterval := Duration{defaultStatusInterval}
+	flag.Var(&statusInterval, "status-interval", "how often to rewrite the status page")
+	statusTitle := flag.String("status-title", "", "title of the status page (default \"Service Status\")")
+	statsdAddr := flag.String("statsd", "", "push metrics to a StatsD server over UDP, e.g. localhost:8125")
+	graphiteAddr := flag.String("graphite", "", "push metrics to a Graphite plaintext listener over TCP, e.g. localhost:2003")
+	influxURL := flag.String("influxdb", "", "push metrics to an InfluxDB write URL in line protocol")
+	metricsPrefix := flag.String("metrics-prefix", "", "prefix for pushed metric names (default web_monitor)")
+	var metricsTags stringList
+	flag.Var(&metricsTags, "metrics-tag", "key=value tag added to pushed metrics, may be repeated")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "send spans and metrics to an OTLP/HTTP collector, e.g. http://localhost:4318")
+	otlpInstance := flag.String("otlp-instance", "", "service.instance.id resource attribute (default hostname)")
+	otlpLocation := flag.String("otlp-location", "", "monitor.location resource attribute, e.g. eu-west")
+	var otlpHeaders stringList
+	flag.Var(&otlpHeaders, "otlp-header", "key=value header sent to the OTLP collector, may be repeated")
+	slackWebhook := flag.String("slack-webhook", "", "Slack incoming webhook URL notified when a target goes down or recovers")
+	teamsWebhook := flag.String("teams-webhook", "", "Microsoft Teams incoming webhook URL notified when a target goes down or recovers")
+	pagerDutyKey := flag.String("pagerduty-key", "", "PagerDuty Events v2 routing key; alerts are triggered and resolved with target state")
+	smtpServer := flag.String("smtp-server", "", "SMTP server host:port for email notifications; the password is read from SMTP_PASSWORD")
+	smtpUser := flag.String("smtp-user", "", "SMTP username")
+	smtpSecurityFlag := flag.String("smtp-security", SMTPStartTLS, "SMTP connection security: "+strings.Join(smtpSecurity, ", "))
+	emailFrom := flag.String("email-from", "", "sender address of notification emails")
+	var emailTo stringList
+	flag.Var(&emailTo, "email-to", "recipient of notification emails, may be repeated")
+	var emailDigest Duration
+	flag.Var(&emailDigest, "email-digest", "collect notifications for this long and send them as one email")
+	var apdexT Duration
+	flag.Var(&apdexT, "apdex-t", "Apdex threshold T: checks within T satisfy, within 4T tolerate (default 500ms)")
+	harFile := flag.String("har-file", "", "write the recent checks of every target to this HAR file on exit")
+	snapshotDir := flag.String("snapshot-dir", "", "save the status line, headers and start of the body of failed responses to this directory")
+	recentChecks := flag.Int("recent-checks", 0, "number of check results kept per target for the API, drill-down and final report (default 30)")
+	anomalyThreshold := flag.Float64("anomaly-threshold", 0, "flag checks whose latency deviates from the learned baseline by more than this many standard deviations")
+	anomalyHourly := flag.Bool("anomaly-hourly", false, "learn a separate latency baseline for each hour of the day")
+	baselineFilePath := flag.String("baseline-file", "", "save anomaly detection baselines to this file on exit and restore them on start")
+	incidentLogPath := flag.String("incident-log", "", "append finished incidents to this JSON Lines file and restore them on start")
+	onDown := flag.String("on-down", "", "shell command run when a target goes down")
+	onUp := flag.String("on-up", "", "shell command run when a target recovers")
+	var escalate stringList
+	flag.Var(&escalate, "escalate", "escalation step notifier[,notifier][@after], e.g. pagerduty@15m, may be repeated")
+	var rules stringList
+	flag.Var(&rules, "rule", "alert rule such as 'p95 over 5m > 800ms for 3 evaluations', may be repeated")
+	var ruleInterval Duration
+	flag.Var(&ruleInterval, "rule-interval", "how often alert rules are evaluated (default 30s)")
+	plain := flag.Bool("plain", false, "print the plain table even when running in a terminal")
+	detectChanges := flag.Bool("detect-changes", false, "alert when the response content changes")
+	var ignorePatterns stringList
+	flag.Var(&ignorePatterns, "ignore-pattern", "regexp for dynamic content ignored by -detect-changes, may be repeated")
+	flag.Usage = usageExample
+
+	flag.CommandLine.Parse(args)
+
+	cfg := &Config{}
+	if *configPath != "" {
+		loaded, err := loadConfig(*configPath)
+		if err != nil {
+			return nil, err
+		}
+		cfg = loaded
+	}
+
+	if *availability > 0 || *latency > 0 {
+		slo := &SLO{
+			Availability: *availability,
+			Latency:      Duration{*latency},
+			Window:       window,
+		}
+		if *latency > 0 {
+			slo.LatencyTarget = *latencyTarget
+		}
+		if err := slo.validate(); err != nil {
+			return nil, fmt.Errorf("slo flags: %v", err)
+		}
+		cfg.SLO = slo
+	}
+
+	if *listen != "" {
+		cfg.Listen = *listen
+	}
+
+	// Scheduling flags override the config file when given explicitly and
+	// provide the defaults otherwise.
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if set["max-in-flight"] || cfg.MaxInFlight == 0 {
+		cfg.MaxInFlight = *maxInFlight
+	}
+	if set["host-rate"] || cfg.HostRate == 0 {
+		cfg.HostRate = *hostRate
+	}
+	if set["start-jitter"] || cfg.StartJitter.Duration == 0 {
+		cfg.StartJitter = startJitter
+	}
+	if maxBodySize > 0 {
+		cfg.MaxBodySize = maxBodySize
+	}
+	if *hashBody {
+		cfg.HashBody = true
+	}
+	if *format != "" {
+		cfg.Format = *format
+	}
+	if *reportFile != "" {
+		cfg.ReportFile = *reportFile
+	}
+	if *reportFormatFlag != "" {
+		cfg.ReportFormat = *reportFormatFlag
+	}
+	if *statusDir != "" {
+		if cfg.StatusPage == nil {
+			cfg.StatusPage = &StatusPage{}
+		}
+		cfg.StatusPage.Dir = *statusDir
+	}
+	if cfg.StatusPage != nil {
+		if set["status-interval"] || cfg.StatusPage.Interval.Duration == 0 {
+			cfg.StatusPage.Interval = statusInterval
+		}
+		if *statusTitle != "" {
+			cfg.StatusPage.Title = *statusTitle
+		}
+	}
+	for _, exporter := range []ExporterConfig{
+		{Type: ExporterStatsD, Address: *statsdAddr},
+		{Type: ExporterGraphite, Address: *graphiteAddr},
+		{Type: ExporterInfluxDB, Address: *influxURL},
+	} {
+		if exporter.Address != "" {
+			cfg.Exporters = append(cfg.Exporters, exporter)
+		}
+	}
+	for i := range cfg.Exporters {
+		if *metricsPrefix != "" {
+			cfg.Exporters[i].Prefix = *metricsPrefix
+		}
+		for _, tag := range metricsTags {
+			key, value, ok := strings.Cut(tag, "=")
+			if !ok || key == "" {
+				return nil, fmt.Errorf("invalid metrics tag '%s', expected key=value", tag)
+			}
+			if cfg.Exporters[i].Tags == nil {
+				cfg.Exporters[i].Tags = make(map[string]string)
+			}
+			cfg.Exporters[i].Tags[key] = value
+		}
+	}
+	if *otlpEndpoint != "" {
+		if cfg.OTLP == nil {
+			cfg.OTLP = &OTLPConfig{}
+		}
+		cfg.OTLP.Endpoint = *otlpEndpoint
+	}
+	if cfg.OTLP != nil {
+		if *otlpInstance != "" {
+			cfg.OTLP.Instance = *otlpInstance
+		}
+		if *otlpLocation != "" {
+			cfg.OTLP.Location = *otlpLocation
+		}
+		for _, header := range otlpHeaders {
+			key, value, ok := strings.Cut(header, "=")
+			if !ok || key == "" {
+				return nil, fmt.Errorf("invalid otlp header '%s', expected key=value", header)
+			}
+			if cfg.OTLP.Headers == nil {
+				cfg.OTLP.Headers = make(map[string]string)
+			}
+			cfg.OTLP.Headers[key] = value
+		}
+	}
+	if *slackWebhook != "" {
+		cfg.Notifiers = append(cfg.Notifiers, NotifierConfig{Type: NotifierSlack, URL: *slackWebhook})
+	}
+	if *teamsWebhook != "" {
+		cfg.Notifiers = append(cfg.Notifiers, NotifierConfig{Type: NotifierTeams, URL: *teamsWebhook})
+	}
+	if *pagerDutyKey != "" {
+		cfg.Notifiers = append(cfg.Notifiers, NotifierConfig{Type: NotifierPagerDuty, RoutingKey: *pagerDutyKey})
+	}
+	if *smtpServer != "" {
+		cfg.Notifiers = append(cfg.Notifiers, NotifierConfig{Type: NotifierEmail, Email: &EmailConfig{
+			Server:   *smtpServer,
+			Username: *smtpUser,
+			Security: *smtpSecurityFlag,
+			From:     *emailFrom,
+			To:       emailTo,
+			Digest:   emailDigest,
+		}})
+	}
+	if set["apdex-t"] {
+		cfg.ApdexT = apdexT
+	}
+	if *harFile != "" {
+		cfg.HARFile = *harFile
+	}
+	if *snapshotDir != "" {
+		if cfg.Snapshots == nil {
+			cfg.Snapshots = &SnapshotConfig{}
+		}
+		cfg.Snapshots.Dir = *snapshotDir
+	}
+	if set["recent-checks"] {
+		cfg.RecentChecks = *recentChecks
+	}
+	if set["anomaly-threshold"] || *anomalyHourly {
+		if cfg.Anomaly == nil {
+			cfg.Anomaly = &AnomalyConfig{}
+		}
+		if set["anomaly-threshold"] {
+			cfg.Anomaly.Threshold = *anomalyThreshold
+		}
+		cfg.Anomaly.HourOfDay = cfg.Anomaly.HourOfDay || *anomalyHourly
+	}
+	if *baselineFilePath != "" {
+		cfg.BaselineFile = *baselineFilePath
+	}
+	if *incidentLogPath != "" {
+		cfg.IncidentLog = *incidentLogPath
+	}
+	if *onDown != "" {
+		cfg.Hooks = append(cfg.Hooks, shellHook(*onDown, stateDown))
+	}
+	if *onUp != "" {
+		cfg.Hooks = append(cfg.Hooks, shellHook(*onUp, stateUp))
+	}
+	for _, value := range escalate {
+		step, err := parseEscalationStep(value)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Escalation = append(cfg.Escalation, step)
+	}
+	for _, expr := range rules {
+		cfg.AlertRules = append(cfg.AlertRules, &AlertRule{Expr: expr})
+	}
+	if set["rule-interval"] {
+		cfg.RuleInterval = ruleInterval
+	}
+	tableFormat := cfg.Format == "" || cfg.Format == "table"
+	cfg.Interactive = tableFormat && !*plain && isTerminal(int(os.Stdout.Fd())) && isTerminal(int(os.Stdin.Fd()))
+	cfg.Color = !*noColor && os.Getenv("NO_COLOR") == "" && isTerminal(int(os.Stdout.Fd()))
+	if len(columns) > 0 {
+		cfg.Columns = nil
+		for _, value := range columns {
+			cfg.Columns = append(cfg.Columns, strings.Split(value, ",")...)
+		}
+	}
+	if *detectChanges {
+		cfg.DetectChanges = true
+	}
+	cfg.IgnorePatterns = append(cfg.IgnorePatterns, ignorePatterns...)
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, value := range silences {
+		silence, err := parseSilence(value, now)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Silences = append(cfg.Silences, silence)
+	}
+
+	return cfg, nil
+}
+
+// stringList collects the values of a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
 }
 
 func validateURLs(args []string) ([]string, error) {
@@ -74,4 +434,7 @@ func usageExample() {
 	fmt.Fprintf(os.Stderr, "   or: ./web-monitor <url1> [url2] ...\n")
 	fmt.Fprintf(os.Stderr, "\nExample: go run main.go https://example.com https://seznam.cz\n")
 	fmt.Fprintf(os.Stderr, "Example: go run main.go https://google.com https://github.com\n")
+	fmt.Fprintf(os.Stderr, "Example: go run main.go -slo 99.9 -slo-latency 300ms https://example.com\n")
+	fmt.Fprintf(os.Stderr, "\nOptions:\n")
+	flag.PrintDefaults()
 }
@@ -1,9 +1,22 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"testing"
@@ -237,7 +250,7 @@ func TestHTTPSuccessDetection(t *testing.T) {
 			t.Parallel()
 
 			mockTransport := httpmock.NewMockTransport()
-			
+
 			url := fmt.Sprintf("http://test%d.example.com/status", tt.statusCode)
 
 			mockTransport.RegisterResponder("GET", url,
@@ -277,7 +290,7 @@ func TestMakeRequestBasic(t *testing.T) {
 
 	mockTransport := httpmock.NewMockTransport()
 	url := "http://test-basic.example.com"
-	
+
 	mockTransport.RegisterResponder("GET", url,
 		httpmock.NewStringResponder(200, "OK"))
 
@@ -496,15 +509,15 @@ func TestMonitorWithCustomTransport(t *testing.T) {
 
 	mockTransport := httpmock.NewMockTransport()
 	url := "http://custom-transport.example.com"
-	
+
 	mockTransport.RegisterResponder("GET", url,
 		httpmock.NewStringResponder(200, "Custom transport response"))
 
 	monitor := NewMonitor([]string{url})
-	
+
 	originalTransport := monitor.httpClient.Transport
 	monitor.httpClient.Transport = mockTransport
-	
+
 	defer func() {
 		monitor.httpClient.Transport = originalTransport
 	}()
@@ -527,7 +540,7 @@ func TestMakeRequestWithCancellation(t *testing.T) {
 
 	mockTransport := httpmock.NewMockTransport()
 	url := "http://test-cancellation.example.com"
-	
+
 	mockTransport.RegisterResponder("GET", url,
 		func(req *http.Request) (*http.Response, error) {
 			select {
@@ -561,7 +574,7 @@ func TestMonitorStartWithContext(t *testing.T) {
 
 	mockTransport := httpmock.NewMockTransport()
 	url := "http://test-monitor-start.example.com"
-	
+
 	mockTransport.RegisterResponder("GET", url,
 		httpmock.NewStringResponder(200, "OK"))
 
@@ -575,12 +588,2710 @@ func TestMonitorStartWithContext(t *testing.T) {
 	monitor.Start(ctx, &wg)
 
 	<-ctx.Done()
-	
+
 	wg.Wait()
 
 	stats := monitor.stats[url].GetSnapshot()
-	
+
 	if stats.TotalRequests == 0 {
 		t.Errorf("Expected at least 1 request, got %d", stats.TotalRequests)
 	}
-}
\ No newline at end of file
+}
+func TestSLOStatus(t *testing.T) {
+	t.Parallel()
+
+	stats := NewURLStats("http://example.com")
+	slo := &SLO{Availability: 99, Latency: Duration{300 * time.Millisecond}}
+	if err := slo.validate(); err != nil {
+		t.Fatalf("Unexpected validation error: %v", err)
+	}
+	stats.SetSLO(slo)
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 100; i++ {
+		at := now.Add(-time.Duration(i) * time.Minute)
+		stats.updateAt(at, 100*time.Millisecond, 1000, true)
+	}
+
+	status, ok := stats.SLOStatus(now)
+	if !ok {
+		t.Fatal("Expected SLO status to be available")
+	}
+	if status.Availability != 100 || status.LatencyGood != 100 {
+		t.Errorf("Expected 100%% compliance, got %.2f%% / %.2f%%", status.Availability, status.LatencyGood)
+	}
+	if status.BudgetRemaining != 1 {
+		t.Errorf("Expected full error budget, got %v", status.BudgetRemaining)
+	}
+	if status.Alert != BurnNone {
+		t.Errorf("Expected no alert, got %q", status.Alert)
+	}
+
+	for i := 0; i < 10; i++ {
+		at := now.Add(-time.Duration(i) * 20 * time.Second)
+		stats.updateAt(at, 2*time.Second, 0, false)
+	}
+
+	status, _ = stats.SLOStatus(now)
+	if status.Alert != BurnPage {
+		t.Errorf("Expected page alert after burst of failures, got %q", status.Alert)
+	}
+	if status.BudgetRemaining >= 0 {
+		t.Errorf("Expected exhausted error budget, got %v", status.BudgetRemaining)
+	}
+	if status.Met(slo) {
+		t.Error("Expected SLO to be violated")
+	}
+}
+
+func TestSLOValidation(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		slo         SLO
+		shouldError bool
+	}{
+		{"availability only", SLO{Availability: 99.9}, false},
+		{"latency only", SLO{Latency: Duration{300 * time.Millisecond}}, false},
+		{"empty", SLO{}, true},
+		{"availability of 100", SLO{Availability: 100}, true},
+		{"negative window", SLO{Availability: 99, Window: Duration{-time.Hour}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tt.slo.validate()
+			if tt.shouldError && err == nil {
+				t.Error("Expected error but got none")
+			}
+			if !tt.shouldError && err != nil {
+				t.Errorf("Expected no error but got: %v", err)
+			}
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		input    string
+		expected time.Duration
+	}{
+		{"300ms", 300 * time.Millisecond},
+		{"6h", 6 * time.Hour},
+		{"30d", 30 * 24 * time.Hour},
+		{"1.5d", 36 * time.Hour},
+	}
+
+	for _, test := range tests {
+		result, err := parseDuration(test.input)
+		if err != nil {
+			t.Errorf("parseDuration(%q) returned error: %v", test.input, err)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("parseDuration(%q) = %v, expected %v", test.input, result, test.expected)
+		}
+	}
+
+	if _, err := parseDuration("soon"); err == nil {
+		t.Error("Expected error for invalid duration")
+	}
+}
+
+func TestMaintenanceWindowActive(t *testing.T) {
+	t.Parallel()
+
+	recurring := &MaintenanceWindow{
+		Schedule: "0 2 * * 1-5",
+		Duration: Duration{time.Hour},
+		Timezone: "Europe/Prague",
+	}
+	if err := recurring.validate(); err != nil {
+		t.Fatalf("Unexpected validation error: %v", err)
+	}
+
+	prague, _ := time.LoadLocation("Europe/Prague")
+	tests := []struct {
+		name     string
+		at       time.Time
+		expected bool
+	}{
+		{"start of window", time.Date(2024, 3, 4, 2, 0, 0, 0, prague), true},
+		{"inside window", time.Date(2024, 3, 4, 2, 59, 0, 0, prague), true},
+		{"after window", time.Date(2024, 3, 4, 3, 0, 0, 0, prague), false},
+		{"weekend", time.Date(2024, 3, 9, 2, 30, 0, 0, prague), false},
+		{"other timezone", time.Date(2024, 3, 4, 1, 30, 0, 0, time.UTC), true},
+	}
+
+	for _, tt := range tests {
+		if got := recurring.Active(tt.at); got != tt.expected {
+			t.Errorf("%s: Active(%v) = %v, expected %v", tt.name, tt.at, got, tt.expected)
+		}
+	}
+
+	start := time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC)
+	oneOff := &MaintenanceWindow{Start: start, End: start.Add(30 * time.Minute)}
+	if err := oneOff.validate(); err != nil {
+		t.Fatalf("Unexpected validation error: %v", err)
+	}
+	if !oneOff.Active(start.Add(10 * time.Minute)) {
+		t.Error("Expected one-off window to be active")
+	}
+	if oneOff.Active(start.Add(30 * time.Minute)) {
+		t.Error("Expected one-off window to be closed at its end")
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "a * * * *", "5-1 * * * *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("Expected error for schedule %q", expr)
+		}
+	}
+}
+
+func TestMaintenanceExcludesFailures(t *testing.T) {
+	t.Parallel()
+
+	mockTransport := httpmock.NewMockTransport()
+	url := "http://test-maintenance.example.com"
+
+	mockTransport.RegisterResponder("GET", url,
+		httpmock.NewStringResponder(503, "Down for maintenance"))
+
+	now := time.Now()
+	dir := t.TempDir()
+	cfg := &Config{
+		SLO:       &SLO{Availability: 99.9},
+		Snapshots: &SnapshotConfig{Dir: dir},
+		Maintenance: []*MaintenanceWindow{
+			{Name: "deploy", Start: now.Add(-time.Minute), End: now.Add(time.Hour)},
+		},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("Unexpected validation error: %v", err)
+	}
+
+	monitor := NewMonitorFromConfig([]string{url}, cfg)
+	monitor.httpClient.Transport = mockTransport
+
+	monitor.makeRequest(context.Background(), url)
+
+	stats := monitor.stats[url].GetSnapshot()
+	if stats.TotalRequests != 0 {
+		t.Errorf("Expected failure during maintenance to be excluded, got %d requests", stats.TotalRequests)
+	}
+	if stats.ExcludedFailures != 1 {
+		t.Errorf("Expected 1 excluded failure, got %d", stats.ExcludedFailures)
+	}
+	if alerts := monitor.recentAlerts(); len(alerts) != 0 {
+		t.Errorf("Expected no alerts during maintenance, got %v", alerts)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Expected no snapshots during maintenance, got %d", len(entries))
+	}
+}
+
+func TestSilenceSuppressesAlerts(t *testing.T) {
+	t.Parallel()
+
+	mockTransport := httpmock.NewMockTransport()
+	url := "http://test-silence.example.com"
+
+	mockTransport.RegisterResponder("GET", url,
+		httpmock.NewStringResponder(500, "Error"))
+
+	monitor := NewMonitorFromConfig([]string{url}, &Config{SLO: &SLO{Availability: 99.9}})
+	monitor.httpClient.Transport = mockTransport
+
+	server := httptest.NewServer(monitor.Handler())
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/api/silences", "application/json",
+		strings.NewReader(`{"url": "`+url+`", "duration": "1h", "reason": "deploy"}`))
+	if err != nil {
+		t.Fatalf("Creating silence failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", resp.StatusCode)
+	}
+
+	monitor.makeRequest(context.Background(), url)
+
+	if stats := monitor.stats[url].GetSnapshot(); stats.TotalRequests != 1 {
+		t.Errorf("Expected silenced check to still be counted, got %d requests", stats.TotalRequests)
+	}
+	if alerts := monitor.recentAlerts(); len(alerts) != 0 {
+		t.Errorf("Expected alerts to be silenced, got %v", alerts)
+	}
+
+	silences := monitor.Silences(time.Now())
+	if len(silences) != 1 {
+		t.Fatalf("Expected 1 active silence, got %d", len(silences))
+	}
+
+	req, _ := http.NewRequest("DELETE", server.URL+"/api/silences/"+silences[0].ID, nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Deleting silence failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", resp.StatusCode)
+	}
+
+	monitor.makeRequest(context.Background(), url)
+
+	// Both the burn-rate alert and the down alert fire once unsilenced.
+	if alerts := monitor.recentAlerts(); len(alerts) != 2 {
+		t.Errorf("Expected alerts once silence is removed, got %v", alerts)
+	}
+}
+
+func TestSchedulerLimitsInFlight(t *testing.T) {
+	t.Parallel()
+
+	s := newScheduler(2, 0)
+	ctx := context.Background()
+
+	release1, _ := s.acquire(ctx, "http://a.example.com")
+	release2, _ := s.acquire(ctx, "http://b.example.com")
+
+	blockedCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := s.acquire(blockedCtx, "http://c.example.com"); err == nil {
+		t.Error("Expected third check to wait for a free slot")
+	}
+
+	release1()
+	release3, err := s.acquire(ctx, "http://c.example.com")
+	if err != nil {
+		t.Errorf("Expected slot after release, got %v", err)
+	}
+
+	release2()
+	release3()
+}
+
+func TestSchedulerHostRate(t *testing.T) {
+	t.Parallel()
+
+	s := newScheduler(0, 20)
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		release, err := s.acquire(ctx, fmt.Sprintf("http://rate.example.com/page%d", i))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		release()
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected requests to the same host to be spaced 50ms apart, took %v", elapsed)
+	}
+
+	start = time.Now()
+	release, _ := s.acquire(ctx, "http://other.example.com")
+	release()
+	if elapsed := time.Since(start); elapsed > 20*time.Millisecond {
+		t.Errorf("Expected other host not to wait, took %v", elapsed)
+	}
+}
+
+func TestSchedulerCancelReleasesHostSlot(t *testing.T) {
+	t.Parallel()
+
+	s := newScheduler(1, 1)
+	ctx := context.Background()
+
+	release, _ := s.acquire(ctx, "http://cancel.example.com/a")
+	release()
+
+	// The second check has to wait a second for the host; giving up must
+	// leave the host where it was, not a further second out.
+	cancelCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := s.acquire(cancelCtx, "http://cancel.example.com/b"); err == nil {
+		t.Fatal("Expected cancelled check to fail")
+	}
+
+	s.mu.Lock()
+	next := s.hostNext["cancel.example.com"]
+	s.mu.Unlock()
+	if wait := time.Until(next); wait > time.Second {
+		t.Errorf("Expected cancelled reservation to be given back, host is free in %v", wait)
+	}
+
+	// No in-flight slot is held after cancellation.
+	select {
+	case s.slots <- struct{}{}:
+		<-s.slots
+	default:
+		t.Error("Expected in-flight slot to be free after cancellation")
+	}
+}
+
+func TestSchedulerThrottledHostDoesNotBlockOthers(t *testing.T) {
+	t.Parallel()
+
+	s := newScheduler(1, 2)
+	ctx := context.Background()
+
+	release, _ := s.acquire(ctx, "http://slow.example.com/a")
+	release()
+
+	// The next check of the throttled host waits 500ms for its turn.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		release, err := s.acquire(ctx, "http://slow.example.com/b")
+		if err == nil {
+			release()
+		}
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	start := time.Now()
+	release, err := s.acquire(ctx, "http://fast.example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	release()
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Expected other host not to wait behind the throttled one, took %v", elapsed)
+	}
+	<-done
+}
+
+func TestScheduleDelayTracking(t *testing.T) {
+	t.Parallel()
+
+	mockTransport := httpmock.NewMockTransport()
+	url := "http://test-schedule.example.com"
+	mockTransport.RegisterResponder("GET", url, httpmock.NewStringResponder(200, "OK"))
+
+	monitor := NewMonitor([]string{url})
+	monitor.httpClient.Transport = mockTransport
+
+	monitor.scheduleCheck(context.Background(), url, time.Now().Add(-2*time.Second))
+
+	stats := monitor.stats[url].GetSnapshot()
+	if stats.LateChecks != 1 {
+		t.Errorf("Expected 1 late check, got %d", stats.LateChecks)
+	}
+	if stats.MaxScheduleDelay < 2*time.Second {
+		t.Errorf("Expected max delay of at least 2s, got %v", stats.MaxScheduleDelay)
+	}
+	if stats.TotalRequests != 1 {
+		t.Errorf("Expected check to run, got %d requests", stats.TotalRequests)
+	}
+}
+
+func TestReadBody(t *testing.T) {
+	t.Parallel()
+
+	body := strings.Repeat("x", 100)
+
+	info, err := readBody(strings.NewReader(body), 1000, true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if info.Size != 100 || info.Truncated {
+		t.Errorf("Expected 100 bytes untruncated, got %d (truncated %v)", info.Size, info.Truncated)
+	}
+	if len(info.Hash) != 64 {
+		t.Errorf("Expected SHA-256 hex hash, got %q", info.Hash)
+	}
+
+	info, _ = readBody(strings.NewReader(body), 100, false)
+	if info.Truncated {
+		t.Error("Expected body exactly at the limit not to be truncated")
+	}
+	if info.Hash != "" {
+		t.Errorf("Expected no hash when hashing is disabled, got %q", info.Hash)
+	}
+
+	info, _ = readBody(strings.NewReader(body), 40, false)
+	if info.Size != 40 || !info.Truncated {
+		t.Errorf("Expected 40 bytes truncated, got %d (truncated %v)", info.Size, info.Truncated)
+	}
+}
+
+func TestMakeRequestBodyOptions(t *testing.T) {
+	t.Parallel()
+
+	mockTransport := httpmock.NewMockTransport()
+	url := "http://test-body.example.com"
+
+	mockTransport.RegisterResponder("GET", url,
+		httpmock.NewStringResponder(200, strings.Repeat("a", 4096)))
+	mockTransport.RegisterResponder("HEAD", url,
+		httpmock.NewStringResponder(200, ""))
+
+	cfg := &Config{
+		MaxBodySize: 1024,
+		HashBody:    true,
+	}
+	monitor := NewMonitorFromConfig([]string{url}, cfg)
+	monitor.httpClient.Transport = mockTransport
+
+	monitor.makeRequest(context.Background(), url)
+
+	stats := monitor.stats[url].GetSnapshot()
+	if stats.MaxSize != 1024 {
+		t.Errorf("Expected size capped at 1024, got %d", stats.MaxSize)
+	}
+	if stats.TruncatedBodies != 1 {
+		t.Errorf("Expected 1 truncated body, got %d", stats.TruncatedBodies)
+	}
+	if stats.LastBodyHash == "" {
+		t.Error("Expected body hash to be recorded")
+	}
+
+	headCfg := &Config{Targets: []TargetConfig{{URL: url, Method: "HEAD"}}}
+	headMonitor := NewMonitorFromConfig([]string{url}, headCfg)
+	headMonitor.httpClient.Transport = mockTransport
+
+	headMonitor.makeRequest(context.Background(), url)
+
+	stats = headMonitor.stats[url].GetSnapshot()
+	if stats.SuccessCount != 1 || stats.MaxSize != 0 {
+		t.Errorf("Expected successful HEAD check without body, got %d successes and size %d",
+			stats.SuccessCount, stats.MaxSize)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"512", 512},
+		{"64KB", 64 * 1024},
+		{"10MB", 10 * 1024 * 1024},
+		{"1gb", 1024 * 1024 * 1024},
+	}
+
+	for _, test := range tests {
+		result, err := parseSize(test.input)
+		if err != nil || result != test.expected {
+			t.Errorf("parseSize(%q) = %d, %v; expected %d", test.input, result, err, test.expected)
+		}
+	}
+
+	if _, err := parseSize("lots"); err == nil {
+		t.Error("Expected error for invalid size")
+	}
+	if _, err := parseSize("9000000000GB"); err == nil || !strings.Contains(err.Error(), "'9000000000GB'") {
+		t.Errorf("Expected overflow error quoting the input, got %v", err)
+	}
+	if _, err := parseSize("-1 kb"); err == nil || !strings.Contains(err.Error(), "'-1 kb'") {
+		t.Errorf("Expected error quoting the input, got %v", err)
+	}
+
+	invalid := []Config{
+		{MaxBodySize: -1},
+		{Targets: []TargetConfig{{URL: "http://a.com", MaxBodySize: -1}}},
+	}
+	for _, cfg := range invalid {
+		if err := cfg.validate(); err == nil {
+			t.Errorf("Expected error for negative max_body_size in %+v", cfg)
+		}
+	}
+}
+
+func TestContentChangeDetection(t *testing.T) {
+	t.Parallel()
+
+	mockTransport := httpmock.NewMockTransport()
+	url := "http://test-content.example.com"
+
+	bodies := []string{
+		"<h1>Welcome</h1>\n<p>Generated at 10:00:01</p>\n",
+		"<h1>Welcome</h1>\n<p>Generated at 10:00:06</p>\n",
+		"<h1>Hacked</h1>\n<p>Generated at 10:00:11</p>\n",
+	}
+	calls := 0
+	mockTransport.RegisterResponder("GET", url,
+		func(req *http.Request) (*http.Response, error) {
+			body := bodies[min(calls, len(bodies)-1)]
+			calls++
+			return httpmock.NewStringResponse(200, body), nil
+		})
+
+	cfg := &Config{
+		DetectChanges:  true,
+		IgnorePatterns: []string{`Generated at [0-9:]+`},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("Unexpected validation error: %v", err)
+	}
+
+	monitor := NewMonitorFromConfig([]string{url}, cfg)
+	monitor.httpClient.Transport = mockTransport
+
+	ctx := context.Background()
+	monitor.makeRequest(ctx, url)
+	monitor.makeRequest(ctx, url)
+
+	if changes := monitor.stats[url].RecentChanges(); len(changes) != 0 {
+		t.Fatalf("Expected ignored region not to count as a change, got %d changes", len(changes))
+	}
+
+	monitor.makeRequest(ctx, url)
+
+	changes := monitor.stats[url].RecentChanges()
+	if len(changes) != 1 {
+		t.Fatalf("Expected 1 content change, got %d", len(changes))
+	}
+
+	diff := changes[0].Diff(url)
+	if !strings.Contains(diff, "-<h1>Welcome</h1>") || !strings.Contains(diff, "+<h1>Hacked</h1>") {
+		t.Errorf("Expected diff to show the changed heading, got:\n%s", diff)
+	}
+
+	alerts := monitor.recentAlerts()
+	if len(alerts) != 1 || alerts[0].Severity != "change" {
+		t.Errorf("Expected 1 change alert, got %v", alerts)
+	}
+}
+
+func TestContentChangeKeepsBoundedBodies(t *testing.T) {
+	t.Parallel()
+
+	stat := NewURLStats("http://test-large-content.example.com")
+	now := time.Now()
+	for i, line := range []string{"old\n", "new\n"} {
+		body := []byte(strings.Repeat(line, 100_000))
+		stat.Record(CheckResult{
+			Time:        now.Add(time.Duration(i) * time.Second),
+			StatusCode:  200,
+			Success:     true,
+			Content:     body,
+			ContentHash: contentHash(body, nil),
+		})
+	}
+
+	changes := stat.RecentChanges()
+	if len(changes) != 1 {
+		t.Fatalf("Expected 1 content change, got %d", len(changes))
+	}
+	change := changes[0]
+	if len(change.Previous) > maxDiffContent || len(change.Current) > maxDiffContent {
+		t.Errorf("Expected bodies capped at %d bytes, got %d and %d", maxDiffContent, len(change.Previous), len(change.Current))
+	}
+	if !change.Truncated {
+		t.Error("Expected change to be marked truncated")
+	}
+	if diff := change.Diff("large"); !strings.Contains(diff, "[bodies truncated at") {
+		t.Errorf("Expected diff to mention the truncation, got:\n%.200s", diff)
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	t.Parallel()
+
+	from := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\n"
+	to := "a\nb\nc\nD\ne\nf\ng\nh\ni\nj\nk\nl\n"
+
+	expected := `--- old
++++ new
+@@ -1,7 +1,7 @@
+ a
+ b
+ c
+-d
++D
+ e
+ f
+ g
+@@ -9,3 +9,4 @@
+ i
+ j
+ k
++l
+`
+	if diff := unifiedDiff("old", "new", from, to); diff != expected {
+		t.Errorf("Unexpected diff:\n%s\nexpected:\n%s", diff, expected)
+	}
+}
+
+func TestTUISortAndFilter(t *testing.T) {
+	t.Parallel()
+
+	urls := []string{"http://fast.example.com", "http://slow.example.com", "http://down.example.org"}
+	monitor := NewMonitor(urls)
+	monitor.stats[urls[0]].Record(CheckResult{Time: time.Now(), Duration: 50 * time.Millisecond, Success: true})
+	monitor.stats[urls[1]].Record(CheckResult{Time: time.Now(), Duration: 900 * time.Millisecond, Success: true})
+	monitor.stats[urls[2]].Record(CheckResult{Time: time.Now(), Duration: 300 * time.Millisecond, Error: "connection refused"})
+
+	ui := &tui{m: monitor}
+
+	rowURLs := func() []string {
+		var result []string
+		for _, row := range ui.rows() {
+			result = append(result, row.url)
+		}
+		return result
+	}
+
+	ui.handleKey("3")
+	ui.handleKey("3")
+	if got := rowURLs(); strings.Join(got, ",") != strings.Join([]string{urls[1], urls[2], urls[0]}, ",") {
+		t.Errorf("Expected rows sorted by average duration descending, got %v", got)
+	}
+
+	for i := 0; i < 3; i++ {
+		ui.handleKey("f")
+	}
+	if got := rowURLs(); len(got) != 1 || got[0] != urls[2] {
+		t.Errorf("Expected only the down target, got %v", got)
+	}
+
+	ui.handleKey("f")
+	ui.handleKey("f")
+	for _, key := range []string{"/", "e", "x", "a", "m", "p", "l", "e", ".", "c", "o", "m", "enter"} {
+		ui.handleKey(key)
+	}
+	if got := rowURLs(); len(got) != 2 {
+		t.Errorf("Expected 2 targets matching filter %q, got %v", ui.filter, got)
+	}
+
+	ui.handleKey("esc")
+	if ui.filter != "" {
+		t.Errorf("Expected esc to clear filter, got %q", ui.filter)
+	}
+}
+
+func TestTUIDetail(t *testing.T) {
+	t.Parallel()
+
+	url := "http://detail.example.com"
+	monitor := NewMonitor([]string{url})
+	monitor.stats[url].Record(CheckResult{Time: time.Now(), Duration: 100 * time.Millisecond, StatusCode: 200, Success: true})
+	monitor.stats[url].Record(CheckResult{Time: time.Now(), Duration: 400 * time.Millisecond, StatusCode: 503, Error: "unexpected status 503"})
+
+	ui := &tui{m: monitor}
+	ui.handleKey("enter")
+	ui.handleKey("p")
+
+	var b strings.Builder
+	ui.render(&b)
+	output := b.String()
+
+	for _, expected := range []string{"[PAUSED]", "(down)", "Recent errors:", "unexpected status 503", "▁█"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected detail view to contain %q, got:\n%s", expected, output)
+		}
+	}
+}
+
+func TestReadKeys(t *testing.T) {
+	t.Parallel()
+
+	keys := make(chan string, 16)
+	readKeys(strings.NewReader("j\033[A\033[B\r/\033\x7f"), keys)
+	close(keys)
+
+	var got []string
+	for key := range keys {
+		got = append(got, key)
+	}
+
+	expected := []string{"j", "up", "down", "enter", "/", "esc", "backspace"}
+	if strings.Join(got, " ") != strings.Join(expected, " ") {
+		t.Errorf("readKeys() = %v, expected %v", got, expected)
+	}
+}
+
+func TestTableLayout(t *testing.T) {
+	t.Parallel()
+
+	urls := []string{"http://a-rather-long-host-name.example.com/with/a/long/path", "http://b.example.com"}
+	monitor := NewMonitor(urls)
+	monitor.stats[urls[0]].Record(CheckResult{Time: time.Now(), Duration: 120 * time.Millisecond, BodySize: 2048, Success: true})
+	monitor.stats[urls[1]].Record(CheckResult{Time: time.Now(), Duration: 80 * time.Millisecond, Error: "timeout"})
+	rows := monitor.tableRows()
+
+	var b strings.Builder
+	tableLayout{}.render(&b, rows, nil)
+	if !strings.Contains(b.String(), urls[0]) {
+		t.Errorf("Expected full URL without a width limit, got:\n%s", b.String())
+	}
+
+	b.Reset()
+	tableLayout{width: 60}.render(&b, rows, nil)
+	for _, line := range strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n") {
+		if width := len([]rune(line)); width > 60 {
+			t.Errorf("Expected lines of at most 60 columns, got %d: %q", width, line)
+		}
+	}
+	if !strings.Contains(b.String(), "…") {
+		t.Errorf("Expected truncated URL, got:\n%s", b.String())
+	}
+
+	columns, err := lookupColumns([]string{"url", "state", "ok"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	b.Reset()
+	tableLayout{columns: columns, color: true}.render(&b, rows, nil)
+	output := b.String()
+	if !strings.Contains(output, colorGreen) || !strings.Contains(output, colorRed) {
+		t.Errorf("Expected green and red rows, got %q", output)
+	}
+	if strings.Contains(output, "Duration") {
+		t.Errorf("Expected only the selected columns, got:\n%s", output)
+	}
+
+	if _, err := lookupColumns([]string{"bogus"}); err == nil {
+		t.Error("Expected error for unknown column")
+	}
+}
+
+func TestSectionsFitWidth(t *testing.T) {
+	t.Parallel()
+
+	url := "http://a-rather-long-host-name.example.com/with/a/long/path"
+	cfg := &Config{SLO: &SLO{Availability: 99.9}}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("Unexpected validation error: %v", err)
+	}
+	monitor := NewMonitorFromConfig([]string{url}, cfg)
+	now := time.Now()
+	monitor.stats[url].Record(CheckResult{Time: now, Duration: 80 * time.Millisecond, Error: "timeout"})
+
+	frame := Frame{
+		Time: now,
+		Rows: monitor.tableRows(),
+		Incidents: []IncidentRecord{
+			{URL: url, Incident: Incident{Start: now.Add(-time.Minute), Checks: 3, FirstError: "timeout"}},
+		},
+	}
+
+	var b strings.Builder
+	renderSLOTable(&b, frame, 60)
+	renderIncidents(&b, frame, 60)
+	output := b.String()
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if width := len([]rune(line)); width > 60 {
+			t.Errorf("Expected lines of at most 60 columns, got %d: %q", width, line)
+		}
+	}
+	if !strings.Contains(output, "Availability") || !strings.Contains(output, "Incidents:") {
+		t.Errorf("Expected SLO and incident tables, got:\n%s", output)
+	}
+}
+
+func TestRenderers(t *testing.T) {
+	t.Parallel()
+
+	urls := []string{"http://render-a.example.com", "http://render-b.example.com"}
+	monitor := NewMonitor(urls)
+	monitor.stats[urls[0]].Record(CheckResult{Time: time.Now(), Duration: 120 * time.Millisecond, BodySize: 2048, Success: true})
+
+	layout := func() tableLayout { return tableLayout{} }
+
+	tests := []struct {
+		format   string
+		live     []string
+		final    []string
+		liveNone bool
+	}{
+		{format: "table", live: []string{"URL", urls[0], "120ms"}, final: []string{"Final Statistics:", "2.0KB"}},
+		{format: "ndjson", live: []string{`"url":"` + urls[0] + `"`, `"duration_avg_ms":120`}, final: []string{`"final":true`, urls[1]}},
+		{format: "csv", live: []string{"time,final,url", urls[0] + ",up,1,1,120.000"}, final: []string{urls[1] + ",pending,0,0"}},
+		{format: "markdown", liveNone: true, final: []string{"| URL |", "| --- |", "| " + urls[0] + " |"}},
+		{format: "quiet", liveNone: true, final: []string{urls[0] + " up 1/1 ok avg 120ms"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			t.Parallel()
+
+			renderer, err := newRenderer(tt.format, layout, false)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			var live bytes.Buffer
+			if err := renderer.Render(&live, monitor.frame(false)); err != nil {
+				t.Fatalf("Render failed: %v", err)
+			}
+			if tt.liveNone && live.Len() != 0 {
+				t.Errorf("Expected no live output, got %q", live.String())
+			}
+			for _, expected := range tt.live {
+				if !strings.Contains(live.String(), expected) {
+					t.Errorf("Expected live output to contain %q, got:\n%s", expected, live.String())
+				}
+			}
+
+			var final bytes.Buffer
+			if err := renderer.Render(&final, monitor.frame(true)); err != nil {
+				t.Fatalf("Render failed: %v", err)
+			}
+			for _, expected := range tt.final {
+				if !strings.Contains(final.String(), expected) {
+					t.Errorf("Expected final output to contain %q, got:\n%s", expected, final.String())
+				}
+			}
+		})
+	}
+
+	if _, err := newRenderer("xml", layout, false); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+}
+
+func TestStreamingRendererOnlyEmitsUpdates(t *testing.T) {
+	t.Parallel()
+
+	urls := []string{"http://stream-a.example.com", "http://stream-b.example.com"}
+	monitor := NewMonitor(urls)
+	renderer, _ := newRenderer("ndjson", nil, false)
+
+	var out bytes.Buffer
+	monitor.stats[urls[0]].Record(CheckResult{Time: time.Now(), Success: true})
+	renderer.Render(&out, monitor.frame(false))
+	out.Reset()
+
+	monitor.stats[urls[1]].Record(CheckResult{Time: time.Now(), Success: true})
+	renderer.Render(&out, monitor.frame(false))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], urls[1]) {
+		t.Errorf("Expected a single record for the updated target, got %v", lines)
+	}
+}
+
+func TestLatencyPercentiles(t *testing.T) {
+	t.Parallel()
+
+	stats := NewURLStats("http://example.com")
+	for i := 1; i <= 100; i++ {
+		stats.Update(time.Duration(i)*10*time.Millisecond, 100, true)
+	}
+
+	snapshot := stats.GetSnapshot()
+	tests := []struct {
+		p        float64
+		expected time.Duration
+	}{
+		{50, 500 * time.Millisecond},
+		{95, 950 * time.Millisecond},
+		{99, 990 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		got := snapshot.Percentile(test.p)
+		// Buckets are 10% wide, so the estimate is an upper bound within 10%.
+		if got < test.expected || float64(got) > float64(test.expected)*1.1 {
+			t.Errorf("Percentile(%v) = %v, expected within 10%% above %v", test.p, got, test.expected)
+		}
+	}
+
+	if empty := NewURLStats("http://example.com").GetSnapshot(); empty.Percentile(95) != 0 {
+		t.Errorf("Expected 0 percentile without requests, got %v", empty.Percentile(95))
+	}
+}
+
+func TestErrorClassification(t *testing.T) {
+	t.Parallel()
+
+	mockTransport := httpmock.NewMockTransport()
+	url := "http://test-errors.example.com"
+
+	responses := []func(*http.Request) (*http.Response, error){
+		httpmock.NewStringResponder(404, "missing"),
+		httpmock.NewStringResponder(503, "unavailable"),
+		httpmock.NewErrorResponder(&net.OpError{Op: "dial", Net: "tcp", Err: fmt.Errorf("connection refused")}),
+	}
+	calls := 0
+	mockTransport.RegisterResponder("GET", url, func(req *http.Request) (*http.Response, error) {
+		responder := responses[calls]
+		calls++
+		return responder(req)
+	})
+
+	monitor := NewMonitor([]string{url})
+	monitor.httpClient.Transport = mockTransport
+
+	for range responses {
+		monitor.makeRequest(context.Background(), url)
+	}
+
+	stats := monitor.stats[url].GetSnapshot()
+	for _, kind := range []string{ErrorHTTP4xx, ErrorHTTP5xx, ErrorConnection} {
+		if stats.ErrorCounts[kind] != 1 {
+			t.Errorf("Expected 1 %s error, got %d (%v)", kind, stats.ErrorCounts[kind], stats.ErrorCounts)
+		}
+	}
+	if stats.ErrorTotal() != 3 {
+		t.Errorf("Expected 3 errors, got %d", stats.ErrorTotal())
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	t.Parallel()
+
+	url := "http://report.example.com/<script>"
+	monitor := NewMonitor([]string{url})
+	monitor.stats[url].Record(CheckResult{Time: time.Now(), Duration: 80 * time.Millisecond, Success: true})
+	monitor.stats[url].Record(CheckResult{Time: time.Now(), Duration: 10 * time.Second, ErrorKind: ErrorTimeout})
+
+	dir := t.TempDir()
+	tests := []struct {
+		file     string
+		format   string
+		expected []string
+	}{
+		{"report.csv", "", []string{"duration_p95_ms", "errors_timeout", url + ",down,2,1"}},
+		{"report.md", "", []string{"# Web Monitor Report", "| P95 |", "| Errors timeout |"}},
+		{"report.html", "", []string{"<th>P99</th>", "&lt;script&gt;", `<tr class="down">`}},
+		{"report.txt", "markdown", []string{"| URL |"}},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.file)
+		if err := monitor.WriteReport(path, tt.format); err != nil {
+			t.Errorf("WriteReport(%s) failed: %v", tt.file, err)
+			continue
+		}
+		data, _ := os.ReadFile(path)
+		for _, expected := range tt.expected {
+			if !strings.Contains(string(data), expected) {
+				t.Errorf("Expected %s to contain %q, got:\n%s", tt.file, expected, data)
+			}
+		}
+	}
+
+	if err := monitor.WriteReport(filepath.Join(dir, "report.txt"), ""); err == nil {
+		t.Error("Expected error for unknown report extension")
+	}
+}
+
+func TestIncidentTracking(t *testing.T) {
+	t.Parallel()
+
+	stats := NewURLStats("http://example.com")
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	results := []CheckResult{
+		{Time: start, Success: true},
+		{Time: start.Add(5 * time.Second), Error: "connection refused"},
+		{Time: start.Add(10 * time.Second), Error: "timeout"},
+		{Time: start.Add(15 * time.Second), Success: true},
+		{Time: start.Add(20 * time.Second), Error: "HTTP 500"},
+	}
+	for _, result := range results {
+		stats.Record(result)
+	}
+
+	incidents := stats.Incidents()
+	if len(incidents) != 2 {
+		t.Fatalf("Expected 2 incidents, got %d", len(incidents))
+	}
+
+	first := incidents[0]
+	if first.FirstError != "connection refused" || first.Checks != 2 {
+		t.Errorf("Expected first incident with 2 checks starting with 'connection refused', got %+v", first)
+	}
+	if first.Ongoing() || first.Duration(start.Add(time.Hour)) != 10*time.Second {
+		t.Errorf("Expected closed 10s incident, got %+v", first)
+	}
+	if !incidents[1].Ongoing() {
+		t.Errorf("Expected second incident to be ongoing, got %+v", incidents[1])
+	}
+}
+
+func TestStatusPage(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	up, down := "http://up.example.com", "http://down.example.com"
+	cfg := &Config{StatusPage: &StatusPage{Dir: dir, Title: "Acme <Status>"}}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+
+	now := time.Now()
+	monitor := NewMonitorFromConfig([]string{up, down}, cfg)
+	monitor.stats[up].Record(CheckResult{Time: now.Add(-48 * time.Hour), Success: true})
+	monitor.stats[up].Record(CheckResult{Time: now, Success: true})
+	monitor.stats[down].Record(CheckResult{Time: now, Error: "connection refused"})
+
+	if err := monitor.WriteStatusPage(now); err != nil {
+		t.Fatalf("WriteStatusPage failed: %v", err)
+	}
+
+	page, _ := os.ReadFile(filepath.Join(dir, "index.html"))
+	for _, expected := range []string{
+		"Acme &lt;Status&gt;",
+		"Partial outage",
+		`<span class="state down">down</span>`,
+		"100.00% uptime",
+		"connection refused",
+		"ongoing",
+	} {
+		if !strings.Contains(string(page), expected) {
+			t.Errorf("Expected status page to contain %q", expected)
+		}
+	}
+	if bars := strings.Count(string(page), `title="`); bars != 2*statusPageDays {
+		t.Errorf("Expected %d uptime bars, got %d", 2*statusPageDays, bars)
+	}
+
+	// A new monitor picks up the history written by the previous one.
+	restarted := NewMonitorFromConfig([]string{up, down}, cfg)
+	if err := restarted.loadStatusHistory(); err != nil {
+		t.Fatalf("loadStatusHistory failed: %v", err)
+	}
+	days := restarted.stats[up].DailyHistory(now, statusPageDays)
+	var total int64
+	for _, day := range days {
+		total += day.Total
+	}
+	if total != 2 || days[len(days)-1].Total != 1 {
+		t.Errorf("Expected restored history with 2 checks over 2 days, got %d", total)
+	}
+	incidents := restarted.stats[down].Incidents()
+	if len(incidents) != 1 {
+		t.Fatalf("Expected restored incident, got %v", incidents)
+	}
+	// The incident was ongoing when the history was saved; it must not
+	// stretch over the time the monitor was not running.
+	if incident := incidents[0]; incident.Ongoing() || !incident.End.Equal(now) || !incident.Interrupted {
+		t.Errorf("Expected incident interrupted at the save time, got %+v", incident)
+	}
+	restarted.stats[down].Record(CheckResult{Time: now.Add(time.Hour), Success: true})
+	if incident := restarted.stats[down].Incidents()[0]; !incident.End.Equal(now) {
+		t.Errorf("Expected first success after restart to leave the incident end, got %+v", incident)
+	}
+}
+
+func TestTargetIDs(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{Targets: []TargetConfig{{URL: "http://api.example.com/v1", ID: "api"}}}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+
+	urls := []string{"http://api.example.com/v1", "https://example.com/health", "http://example.com/health"}
+	monitor := NewMonitorFromConfig(urls, cfg)
+
+	if id := monitor.targets[urls[0]].ID; id != "api" {
+		t.Errorf("Expected configured id 'api', got '%s'", id)
+	}
+	first, second := monitor.targets[urls[1]].ID, monitor.targets[urls[2]].ID
+	if !strings.HasPrefix(first, "example-com-health-") || !strings.HasPrefix(second, "example-com-health-") || first == second {
+		t.Errorf("Expected both clashing ids to get a hash suffix, got '%s' and '%s'", first, second)
+	}
+	if again := NewMonitorFromConfig(urls, cfg).targets[urls[2]].ID; again != second {
+		t.Errorf("Expected stable id '%s', got '%s'", second, again)
+	}
+	reordered := NewMonitorFromConfig([]string{urls[2], urls[1], urls[0]}, cfg)
+	if reordered.targets[urls[1]].ID != first || reordered.targets[urls[2]].ID != second {
+		t.Errorf("Expected ids not to depend on target order, got '%s' and '%s'",
+			reordered.targets[urls[1]].ID, reordered.targets[urls[2]].ID)
+	}
+
+	invalid := []Config{
+		{Targets: []TargetConfig{{URL: "http://a.com", ID: "a/b"}}},
+		{Targets: []TargetConfig{{URL: "http://a.com", ID: "a"}, {URL: "http://b.com", ID: "a"}}},
+	}
+	for _, cfg := range invalid {
+		if err := cfg.validate(); err == nil {
+			t.Errorf("Expected error for ids in %+v", cfg.Targets)
+		}
+	}
+}
+
+func TestBadges(t *testing.T) {
+	t.Parallel()
+
+	url := "https://example.com/health"
+	monitor := NewMonitor([]string{url})
+	monitor.stats[url].Record(CheckResult{Time: time.Now(), Duration: 120 * time.Millisecond, Success: true})
+	monitor.stats[url].Record(CheckResult{Time: time.Now().Add(-48 * time.Hour), Duration: 100 * time.Millisecond, Error: "timeout"})
+	monitor.stats[url].Record(CheckResult{Time: time.Now(), Duration: 110 * time.Millisecond, Success: true})
+	handler := monitor.Handler()
+
+	tests := []struct {
+		path     string
+		status   int
+		expected []string
+	}{
+		{"/badges/example-com-health/status.svg", 200, []string{"status: degraded", badgeYellow}},
+		{"/badges/example-com-health/uptime.svg", 200, []string{"uptime: 66.67%", badgeRed}},
+		{"/badges/example-com-health/uptime.svg?window=24h", 200, []string{"uptime 1d: 100.00%", badgeGreen}},
+		{"/badges/example-com-health/response.svg?label=%3Capi%3E", 200, []string{"&lt;api&gt;: 110ms", badgeGreen}},
+		{"/badges/example-com-health/uptime.svg?window=1y", 400, nil},
+		{"/badges/example-com-health/size.svg", 404, nil},
+		{"/badges/missing/status.svg", 404, nil},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.path, tt.status, rec.Code)
+			continue
+		}
+		if tt.status == 200 && rec.Header().Get("Content-Type") != "image/svg+xml" {
+			t.Errorf("%s: expected SVG content type, got %s", tt.path, rec.Header().Get("Content-Type"))
+		}
+		for _, expected := range tt.expected {
+			if !strings.Contains(rec.Body.String(), expected) {
+				t.Errorf("%s: expected %q in:\n%s", tt.path, expected, rec.Body.String())
+			}
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/targets", nil))
+	if !strings.Contains(rec.Body.String(), `"/badges/example-com-health/uptime.svg"`) {
+		t.Errorf("Expected badge paths in target list, got %s", rec.Body.String())
+	}
+}
+
+func TestExporterEncoding(t *testing.T) {
+	t.Parallel()
+
+	at := time.Unix(1700000000, 0)
+	batch := []metricSample{{
+		Measurement: "check",
+		Tags:        map[string]string{"target": "example-com", "env": "prod"},
+		Time:        at,
+		Fields: []metricField{
+			{"duration", 120.5, metricTiming},
+			{"count", 1, metricCounter},
+		},
+	}}
+
+	statsd := string(encodeStatsD("wm", batch)[0].data)
+	expectedStatsD := "wm.check.duration:120.5|ms|#env:prod,target:example-com\nwm.check.count:1|c|#env:prod,target:example-com"
+	if statsd != expectedStatsD {
+		t.Errorf("Expected StatsD:\n%s\ngot:\n%s", expectedStatsD, statsd)
+	}
+
+	graphite := string(encodeGraphite("wm", batch)[0].data)
+	expectedGraphite := "wm.check.duration;env=prod;target=example-com 120.5 1700000000\nwm.check.count;env=prod;target=example-com 1 1700000000\n"
+	if graphite != expectedGraphite {
+		t.Errorf("Expected Graphite:\n%s\ngot:\n%s", expectedGraphite, graphite)
+	}
+
+	batch[0].Tags["env"] = "prod west"
+	influx := string(encodeInflux("wm", batch)[0].data)
+	expectedInflux := `wm_check,env=prod\ west,target=example-com duration=120.5,count=1 1700000000000000000` + "\n"
+	if influx != expectedInflux {
+		t.Errorf("Expected Influx:\n%s\ngot:\n%s", expectedInflux, influx)
+	}
+
+	// Tag values are sanitised for protocols that cannot escape them.
+	odd := []metricSample{{
+		Measurement: "check",
+		Tags:        map[string]string{"env": "prod;west ~1", "team": "a,b|c", "empty": ""},
+		Time:        at,
+		Fields:      []metricField{{"count", 1, metricCounter}},
+	}}
+	expectedGraphite = "wm.check.count;env=prod_west__1;team=a,b|c 1 1700000000\n"
+	if graphite := string(encodeGraphite("wm", odd)[0].data); graphite != expectedGraphite {
+		t.Errorf("Expected sanitised Graphite:\n%s\ngot:\n%s", expectedGraphite, graphite)
+	}
+	expectedStatsD = "wm.check.count:1|c|#env:prod;west_~1,team:a_b_c"
+	if statsd := string(encodeStatsD("wm", odd)[0].data); statsd != expectedStatsD {
+		t.Errorf("Expected sanitised StatsD:\n%s\ngot:\n%s", expectedStatsD, statsd)
+	}
+
+	// Large batches are split into packets that fit a datagram.
+	var many []metricSample
+	for range 100 {
+		many = append(many, batch[0])
+	}
+	packets := encodeStatsD("wm", many)
+	if len(packets) < 2 {
+		t.Errorf("Expected StatsD batch to be split, got %d packet", len(packets))
+	}
+	samples := 0
+	for _, packet := range packets {
+		if len(packet.data) > statsdPacketSize {
+			t.Errorf("Expected packets of at most %d bytes, got %d", statsdPacketSize, len(packet.data))
+		}
+		samples += packet.samples
+	}
+	if samples != len(many) {
+		t.Errorf("Expected the packets to account for %d samples, got %d", len(many), samples)
+	}
+
+	// A failed packet only counts its own samples as failed.
+	e := newExporter(ExporterConfig{Type: ExporterStatsD, Address: "localhost:8125", Prefix: "wm"})
+	sends := 0
+	e.send = func(context.Context, []byte) error {
+		sends++
+		if sends == 2 {
+			return errors.New("send failed")
+		}
+		return nil
+	}
+	e.flush(many)
+	if failed, sent := e.failed.Load(), e.sent.Load(); failed != int64(packets[1].samples) || sent+failed != int64(len(many)) {
+		t.Errorf("Expected %d failed samples of %d, got %d failed and %d sent", packets[1].samples, len(many), failed, sent)
+	}
+}
+
+func TestSLOMetrics(t *testing.T) {
+	t.Parallel()
+
+	url := "http://slo-metrics.example.com"
+	cfg := &Config{SLO: &SLO{Availability: 99, Latency: Duration{100 * time.Millisecond}, LatencyTarget: 90}}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("Unexpected validation error: %v", err)
+	}
+	monitor := NewMonitorFromConfig([]string{url}, cfg)
+
+	now := time.Now()
+	for i := range 10 {
+		result := CheckResult{Time: now.Add(time.Duration(i-10) * time.Minute), Duration: 50 * time.Millisecond, Success: true}
+		if i == 9 {
+			result = CheckResult{Time: result.Time, Duration: 50 * time.Millisecond, Error: "timeout"}
+		}
+		monitor.stats[url].Record(result)
+	}
+
+	fields := make(map[string]float64)
+	for _, sample := range monitor.aggregateSamples(now) {
+		if sample.Measurement == "target" {
+			for _, field := range sample.Fields {
+				fields[field.Name] = field.Value
+			}
+		}
+	}
+
+	// One failure in ten spends ten times the 1% budget.
+	expected := map[string]float64{
+		"slo_availability": 0.9,
+		"slo_latency_good": 0.9,
+		"slo_burn_rate_1h": 10,
+		"slo_burn_rate_6h": 10,
+		"slo_met":          0,
+	}
+	for name, value := range expected {
+		if got, ok := fields[name]; !ok || math.Abs(got-value) > 1e-9 {
+			t.Errorf("Expected %s = %v, got %v (present %v)", name, value, got, ok)
+		}
+	}
+	if budget, ok := fields["slo_budget_remaining"]; !ok || budget >= 0 {
+		t.Errorf("Expected an overspent budget, got %v (present %v)", budget, ok)
+	}
+
+	plain := NewMonitor([]string{url})
+	for _, sample := range plain.aggregateSamples(now) {
+		for _, field := range sample.Fields {
+			if strings.HasPrefix(field.Name, "slo_") {
+				t.Errorf("Expected no SLO fields without an SLO, got %s", field.Name)
+			}
+		}
+	}
+}
+
+func TestExporterNames(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{Exporters: []ExporterConfig{
+		{Type: ExporterStatsD, Address: "localhost:8125"},
+		{Type: ExporterStatsD, Address: "localhost:8126"},
+		{Type: ExporterGraphite, Address: "localhost:2003"},
+	}}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("Unexpected validation error: %v", err)
+	}
+
+	monitor := NewMonitorFromConfig([]string{"http://names.example.com"}, cfg)
+	var names []string
+	for _, sample := range monitor.aggregateSamples(time.Now()) {
+		if sample.Measurement == "exporter" {
+			names = append(names, sample.Tags["name"])
+		}
+	}
+	if expected := []string{"statsd-1", "statsd-2", "graphite"}; !slices.Equal(names, expected) {
+		t.Errorf("Expected exporter samples named %v, got %v", expected, names)
+	}
+
+	duplicate := &Config{Exporters: []ExporterConfig{
+		{Name: "push", Type: ExporterStatsD, Address: "localhost:8125"},
+		{Name: "push", Type: ExporterGraphite, Address: "localhost:2003"},
+	}}
+	if err := duplicate.validate(); err == nil {
+		t.Error("Expected error for duplicate exporter names")
+	}
+}
+
+func TestExporters(t *testing.T) {
+	t.Parallel()
+
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+
+	influxBodies := make(chan string, 10)
+	influx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("Authorization") != "Token secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		influxBodies <- string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer influx.Close()
+
+	cfg := &Config{Exporters: []ExporterConfig{
+		{Type: ExporterStatsD, Address: udp.LocalAddr().String(), Tags: map[string]string{"region": "eu"}},
+		{Type: ExporterGraphite, Address: tcp.Addr().String()},
+		{Type: ExporterInfluxDB, Address: influx.URL, Token: "secret"},
+	}}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+
+	url := "https://example.com/health"
+	monitor := NewMonitorFromConfig([]string{url}, cfg)
+	monitor.updateStats(url, CheckResult{Time: time.Now(), Duration: 50 * time.Millisecond, StatusCode: 200, Success: true})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	monitor.startExporters(ctx, &wg)
+
+	buf := make([]byte, 2048)
+	udp.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := udp.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("Expected StatsD packet: %v", err)
+	}
+	if !strings.Contains(string(buf[:n]), "web_monitor.check.duration:50|ms|#region:eu,target:example-com-health") {
+		t.Errorf("Unexpected StatsD packet: %s", buf[:n])
+	}
+
+	tcp.(*net.TCPListener).SetDeadline(time.Now().Add(5 * time.Second))
+	conn, err := tcp.Accept()
+	if err != nil {
+		t.Fatalf("Expected Graphite connection: %v", err)
+	}
+	data, _ := io.ReadAll(conn)
+	conn.Close()
+	if !strings.Contains(string(data), "web_monitor.check.success;target=example-com-health 1 ") {
+		t.Errorf("Unexpected Graphite data: %s", data)
+	}
+
+	select {
+	case body := <-influxBodies:
+		if !strings.HasPrefix(body, "web_monitor_check,target=example-com-health duration=50,size=0,success=1,count=1 ") {
+			t.Errorf("Unexpected Influx data: %s", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Expected Influx write")
+	}
+
+	cancel()
+	wg.Wait()
+
+	for _, status := range monitor.ExporterStatus() {
+		if status.Sent != 1 || status.Failed != 0 {
+			t.Errorf("Expected 1 sent sample for %s, got %+v", status.Type, status)
+		}
+	}
+}
+
+func TestExporterDoesNotBlock(t *testing.T) {
+	t.Parallel()
+
+	e := newExporter(ExporterConfig{Type: ExporterGraphite, Address: "127.0.0.1:1", BatchSize: 10})
+	start := time.Now()
+	for range exportQueueSize + 5 {
+		e.enqueue(metricSample{Measurement: "check"})
+	}
+	if time.Since(start) > time.Second {
+		t.Error("Expected enqueue not to block")
+	}
+	if dropped := e.status().Dropped; dropped != 5 {
+		t.Errorf("Expected 5 dropped samples, got %d", dropped)
+	}
+
+	e.flush([]metricSample{{Measurement: "check", Fields: []metricField{{"count", 1, metricCounter}}}})
+	if failed := e.status().Failed; failed != 1 {
+		t.Errorf("Expected 1 failed sample, got %d", failed)
+	}
+}
+
+func TestOTLPExport(t *testing.T) {
+	t.Parallel()
+
+	traceparents := make(chan string, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents <- r.Header.Get("traceparent")
+		fmt.Fprint(w, "ok")
+	}))
+	defer backend.Close()
+
+	var mu sync.Mutex
+	received := make(map[string][]string)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("X-Api-Key") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		received[r.URL.Path] = append(received[r.URL.Path], string(body))
+		mu.Unlock()
+	}))
+	defer collector.Close()
+
+	cfg := &Config{OTLP: &OTLPConfig{
+		Endpoint: collector.URL + "/",
+		Headers:  map[string]string{"X-Api-Key": "secret"},
+		Instance: "probe-1",
+		Location: "eu-west",
+	}}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+
+	monitor := NewMonitorFromConfig([]string{backend.URL}, cfg)
+	monitor.makeRequest(context.Background(), backend.URL)
+
+	header := <-traceparents
+	parts := strings.Split(header, "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || parts[3] != "01" {
+		t.Fatalf("Expected W3C traceparent header, got '%s'", header)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	monitor.startExporters(ctx, &wg)
+	cancel()
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(received["/v1/traces"]) != 1 {
+		t.Fatalf("Expected one trace export, got %d", len(received["/v1/traces"]))
+	}
+	traces := received["/v1/traces"][0]
+	for _, expected := range []string{
+		`"traceId":"` + parts[1] + `"`,
+		`"spanId":"` + parts[2] + `"`,
+		`"key":"service.instance.id","value":{"stringValue":"probe-1"}`,
+		`"key":"monitor.location","value":{"stringValue":"eu-west"}`,
+		`"key":"http.response.status_code","value":{"intValue":"200"}`,
+		`"key":"server.address","value":{"stringValue":"127.0.0.1"}`,
+		`"name":"GET"`,
+		`"name":"first_byte"`,
+		`"kind":3`,
+	} {
+		if !strings.Contains(traces, expected) {
+			t.Errorf("Expected %s in traces:\n%s", expected, traces)
+		}
+	}
+
+	if len(received["/v1/metrics"]) != 1 || !strings.Contains(received["/v1/metrics"][0], `"name":"web_monitor.target.requests"`) {
+		t.Errorf("Expected target metrics, got %v", received["/v1/metrics"])
+	}
+
+	for _, status := range monitor.ExporterStatus() {
+		if status.Type == "otlp" && (status.Failed != 0 || status.Sent == 0) {
+			t.Errorf("Expected successful OTLP exports, got %+v", status)
+		}
+	}
+}
+
+func TestNotifiers(t *testing.T) {
+	t.Parallel()
+
+	type request struct {
+		path string
+		body map[string]any
+	}
+	requests := make(chan request, 10)
+	standIn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		requests <- request{r.URL.Path, body}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer standIn.Close()
+
+	cfg := &Config{Notifiers: []NotifierConfig{
+		{Type: NotifierSlack, URL: standIn.URL + "/slack"},
+		{Type: NotifierTeams, URL: standIn.URL + "/teams"},
+		{Type: NotifierPagerDuty, URL: standIn.URL + "/pagerduty", RoutingKey: "key"},
+	}}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+
+	url := "https://example.com/health"
+	monitor := NewMonitorFromConfig([]string{url}, cfg)
+
+	start := time.Now()
+	monitor.updateStats(url, CheckResult{Time: start, Success: true})
+	monitor.updateStats(url, CheckResult{Time: start.Add(5 * time.Second), Error: "connection refused", ErrorKind: ErrorConnection})
+	monitor.updateStats(url, CheckResult{Time: start.Add(10 * time.Second), Error: "connection refused", ErrorKind: ErrorConnection})
+	monitor.updateStats(url, CheckResult{Time: start.Add(15 * time.Second), Success: true})
+
+	if len(monitor.notifications) != 2 {
+		t.Fatalf("Expected down and recovered events, got %d", len(monitor.notifications))
+	}
+	for range 2 {
+		monitor.deliver(<-monitor.notifications)
+	}
+
+	got := make(map[string][]map[string]any)
+	for range 6 {
+		r := <-requests
+		got[r.path] = append(got[r.path], r.body)
+	}
+
+	slack := got["/slack"]
+	if !strings.Contains(slack[0]["text"].(string), "is down: connection refused") ||
+		!strings.Contains(slack[1]["text"].(string), "recovered after") {
+		t.Errorf("Unexpected Slack messages: %v", slack)
+	}
+	if color := slack[0]["attachments"].([]any)[0].(map[string]any)["color"]; color != "danger" {
+		t.Errorf("Expected danger colour, got %v", color)
+	}
+
+	teams := got["/teams"]
+	if teams[0]["@type"] != "MessageCard" || teams[0]["themeColor"] != "C62828" || teams[1]["themeColor"] != "2E7D32" {
+		t.Errorf("Unexpected Teams cards: %v", teams)
+	}
+
+	pagerDuty := got["/pagerduty"]
+	if pagerDuty[0]["event_action"] != "trigger" || pagerDuty[1]["event_action"] != "resolve" {
+		t.Errorf("Expected trigger then resolve, got %v", pagerDuty)
+	}
+	if pagerDuty[0]["dedup_key"] != "web-monitor/example-com-health" || pagerDuty[0]["dedup_key"] != pagerDuty[1]["dedup_key"] {
+		t.Errorf("Expected matching dedup keys, got %v and %v", pagerDuty[0]["dedup_key"], pagerDuty[1]["dedup_key"])
+	}
+
+	for _, status := range monitor.NotifierStatus() {
+		if status.Sent != 2 || status.Failed != 0 {
+			t.Errorf("Expected 2 deliveries for %s, got %+v", status.Type, status)
+		}
+	}
+
+	alerts := monitor.recentAlerts()
+	if len(alerts) != 2 || alerts[0].Severity != "down" || alerts[1].Severity != "recovered" {
+		t.Errorf("Expected down and recovered alerts, got %v", alerts)
+	}
+}
+
+func TestNotifierFailures(t *testing.T) {
+	t.Parallel()
+
+	standIn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer standIn.Close()
+
+	monitor := NewMonitorFromConfig([]string{"http://a.com"}, &Config{
+		Notifiers: []NotifierConfig{{Type: NotifierSlack, URL: standIn.URL}},
+	})
+	monitor.deliver(notification{StateEvent: StateEvent{URL: "http://a.com", State: stateDown}})
+
+	status := monitor.NotifierStatus()[0]
+	if status.Failed != 1 || !strings.Contains(status.LastError, "400") {
+		t.Errorf("Expected failed delivery with status, got %+v", status)
+	}
+
+	invalid := []NotifierConfig{
+		{Type: "email", URL: "http://a.com"},
+		{Type: NotifierSlack},
+		{Type: NotifierPagerDuty},
+	}
+	for _, cfg := range invalid {
+		if err := cfg.validate(); err == nil {
+			t.Errorf("Expected error for %+v", cfg)
+		}
+	}
+}
+
+// fakeSMTP accepts one connection per message and sends each message's
+// DATA to the returned channel.
+func fakeSMTP(t *testing.T) (string, <-chan string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	messages := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				text := textproto.NewConn(conn)
+				text.PrintfLine("220 fake ESMTP")
+				for {
+					line, err := text.ReadLine()
+					if err != nil {
+						return
+					}
+					switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+					case "EHLO":
+						text.PrintfLine("250-fake\r\n250 AUTH PLAIN")
+					case "AUTH":
+						if !strings.Contains(line, base64.StdEncoding.EncodeToString([]byte("\x00monitor\x00secret"))) {
+							text.PrintfLine("535 bad credentials")
+							continue
+						}
+						text.PrintfLine("235 ok")
+					case "DATA":
+						text.PrintfLine("354 go ahead")
+						data, _ := text.ReadDotBytes()
+						messages <- string(data)
+						text.PrintfLine("250 queued")
+					case "QUIT":
+						text.PrintfLine("221 bye")
+						return
+					default:
+						text.PrintfLine("250 ok")
+					}
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String(), messages
+}
+
+func TestEmailNotifier(t *testing.T) {
+	t.Parallel()
+
+	server, messages := fakeSMTP(t)
+
+	subject := filepath.Join(t.TempDir(), "subject.tmpl")
+	os.WriteFile(subject, []byte(`{{.Event.State | printf "%s"}} {{.Event.TargetID}} {{len .Events}}`), 0o644)
+
+	cfg := &Config{Notifiers: []NotifierConfig{{Type: NotifierEmail, Email: &EmailConfig{
+		Server:          server,
+		Username:        "monitor",
+		Password:        "secret",
+		Security:        SMTPNone,
+		From:            "monitor@example.com",
+		To:              []string{"ops@example.com"},
+		SubjectTemplate: subject,
+	}}}}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+
+	url := "https://example.com/health"
+	monitor := NewMonitorFromConfig([]string{url}, cfg)
+	monitor.updateStats(url, CheckResult{Time: time.Now(), Duration: 80 * time.Millisecond, Success: true})
+	monitor.updateStats(url, CheckResult{Time: time.Now(), Error: "unexpected status 503 <Service Unavailable>", ErrorKind: ErrorHTTP5xx})
+	monitor.deliver(<-monitor.notifications)
+
+	if status := monitor.NotifierStatus()[0]; status.Sent != 1 {
+		t.Fatalf("Expected email to be sent, got %+v", status)
+	}
+
+	message := <-messages
+	for _, expected := range []string{
+		"Subject: down example-com-health 1",
+		"To: ops@example.com",
+		"Content-Type: multipart/alternative",
+		"Error:   unexpected status 503 <Service Unavailable>",
+		"Checks:  1/2 successful",
+		"&lt;Service Unavailable&gt;",
+		"Recent errors:",
+	} {
+		if !strings.Contains(message, expected) {
+			t.Errorf("Expected %q in email:\n%s", expected, message)
+		}
+	}
+}
+
+func TestEmailDigest(t *testing.T) {
+	t.Parallel()
+
+	server, messages := fakeSMTP(t)
+	cfg := EmailConfig{
+		Server:   server,
+		Security: SMTPNone,
+		From:     "monitor@example.com",
+		To:       []string{"ops@example.com"},
+		Digest:   Duration{time.Hour},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+
+	n := newEmailNotifier(cfg)
+	stats := NewURLStats("http://a.com").GetSnapshot()
+	for _, state := range []string{stateDown, stateUp} {
+		event := StateEvent{Time: time.Now(), URL: "http://a.com", TargetID: "a-com", State: state, Stats: &stats}
+		if err := n.Notify(context.Background(), event); err != nil {
+			t.Fatalf("Notify failed: %v", err)
+		}
+	}
+
+	select {
+	case <-messages:
+		t.Fatal("Expected digest to wait for the digest period")
+	default:
+	}
+
+	if err := n.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	message := <-messages
+	if !strings.Contains(message, "Subject: [web-monitor] 2 state changes") ||
+		!strings.Contains(message, "http://a.com is down") || !strings.Contains(message, "http://a.com recovered") {
+		t.Errorf("Expected both events in digest:\n%s", message)
+	}
+
+	if err := n.Flush(context.Background()); err != nil {
+		t.Errorf("Expected empty flush to succeed, got %v", err)
+	}
+}
+
+func TestEmailDigestCounts(t *testing.T) {
+	t.Parallel()
+
+	server, messages := fakeSMTP(t)
+	cfg := NotifierConfig{Type: NotifierEmail, Email: &EmailConfig{
+		Server:   server,
+		Security: SMTPNone,
+		From:     "monitor@example.com",
+		To:       []string{"ops@example.com"},
+		Digest:   Duration{time.Hour},
+	}}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+
+	monitor := NewMonitor([]string{"http://a.com"})
+	n := newNotifierEntry(cfg, http.DefaultClient)
+	stats := NewURLStats("http://a.com").GetSnapshot()
+	for _, state := range []string{stateDown, stateUp} {
+		event := StateEvent{Time: time.Now(), URL: "http://a.com", TargetID: "a-com", State: state, Stats: &stats}
+		monitor.deliver(notification{event, []*notifierEntry{n}})
+	}
+
+	if status := n.status(); status.Queued != 2 || status.Sent != 0 {
+		t.Errorf("Expected 2 queued and none sent before the digest, got %+v", status)
+	}
+
+	if err := n.notifier.(flusher).Flush(context.Background()); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	<-messages
+	if status := n.status(); status.Queued != 0 || status.Sent != 2 || status.Failed != 0 {
+		t.Errorf("Expected 2 sent once the digest went out, got %+v", status)
+	}
+}
+
+func TestEmailConfigValidation(t *testing.T) {
+	t.Parallel()
+
+	invalid := []EmailConfig{
+		{Server: "localhost", From: "a@b.c", To: []string{"d@e.f"}},
+		{Server: "localhost:25", To: []string{"d@e.f"}},
+		{Server: "localhost:25", From: "a@b.c", To: []string{"d@e.f"}, Security: "ssl"},
+		{Server: "localhost:25", From: "a@b.c", To: []string{"d@e.f"}, TextTemplate: "/nonexistent.tmpl"},
+	}
+	for _, cfg := range invalid {
+		if err := cfg.validate(); err == nil {
+			t.Errorf("Expected error for %+v", cfg)
+		}
+	}
+}
+
+func TestHooks(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	hooks := []HookConfig{
+		shellHook(`echo "$WEB_MONITOR_STATE $WEB_MONITOR_TARGET_ID" > `+out+`; cat >> `+out, stateDown),
+		shellHook("echo failing; exit 3", stateUp),
+		{Command: []string{"/bin/sh", "-c", "sleep 5"}, On: []string{stateUp}, Timeout: Duration{100 * time.Millisecond}},
+	}
+	for i := range hooks {
+		if err := hooks[i].validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	monitor := NewMonitorFromConfig([]string{"http://a.com"}, &Config{Hooks: hooks})
+	now := time.Now()
+	down := StateEvent{Time: now, URL: "http://a.com", TargetID: "a-com", State: stateDown, Error: "refused"}
+	monitor.hooks.trigger(down)
+	monitor.hooks.trigger(StateEvent{Time: now.Add(time.Second), URL: "http://a.com", TargetID: "a-com", State: stateUp})
+	// Within min_interval of the first down event.
+	monitor.hooks.trigger(down)
+	monitor.hooks.close()
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "down a-com\n") || !strings.Contains(string(data), `"error":"refused"`) {
+		t.Errorf("Expected environment and JSON event on stdin, got %q", data)
+	}
+
+	runs := monitor.hookRuns()
+	if len(runs) != 4 {
+		t.Fatalf("Expected 4 hook runs, got %d", len(runs))
+	}
+	var skipped, exited, timedOut bool
+	for _, run := range runs {
+		switch {
+		case run.Skipped:
+			skipped = true
+		case run.ExitCode == 3:
+			exited = run.Output == "failing\n"
+		case strings.Contains(run.Error, "timed out"):
+			timedOut = true
+		}
+	}
+	if !skipped || !exited || !timedOut {
+		t.Errorf("Expected skipped, exit 3 and timed out runs, got %+v", runs)
+	}
+
+	var failures int
+	for _, alert := range monitor.recentAlerts() {
+		if alert.Severity == "hook" {
+			failures++
+		}
+	}
+	if failures != 2 {
+		t.Errorf("Expected 2 hook alerts, got %d", failures)
+	}
+
+	rec := httptest.NewRecorder()
+	monitor.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/hooks", nil))
+	var listed []HookRun
+	if err := json.NewDecoder(rec.Body).Decode(&listed); err != nil || len(listed) != 4 {
+		t.Errorf("Expected 4 hook runs from API, got %d (%v)", len(listed), err)
+	}
+
+	invalid := []HookConfig{
+		{},
+		{Command: []string{"true"}, On: []string{"degraded"}},
+		{Command: []string{"true"}, Timeout: Duration{-time.Second}},
+	}
+	for _, cfg := range invalid {
+		if err := cfg.validate(); err == nil {
+			t.Errorf("Expected error for %+v", cfg)
+		}
+	}
+}
+
+func TestHookShortFlap(t *testing.T) {
+	t.Parallel()
+
+	hook := HookConfig{Command: []string{"true"}, On: []string{stateDown, stateUp}}
+	if err := hook.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	monitor := NewMonitorFromConfig([]string{"http://flap.com"}, &Config{Hooks: []HookConfig{hook}})
+	now := time.Now()
+	for i, state := range []string{stateDown, stateUp, stateDown, stateUp} {
+		monitor.hooks.trigger(StateEvent{Time: now.Add(time.Duration(i) * 10 * time.Second), URL: "http://flap.com", State: state})
+	}
+	monitor.hooks.close()
+
+	// A recovery within min_interval of the outage still runs; only the
+	// repeated transitions are held back.
+	var ran, skipped []string
+	for _, run := range monitor.hookRuns() {
+		if run.Skipped {
+			skipped = append(skipped, run.State)
+		} else {
+			ran = append(ran, run.State)
+		}
+	}
+	slices.Sort(ran)
+	if !slices.Equal(ran, []string{stateDown, stateUp}) || !slices.Equal(skipped, []string{stateDown, stateUp}) {
+		t.Errorf("Expected one down and one up run with the repeats skipped, ran %v, skipped %v", ran, skipped)
+	}
+}
+
+func TestHookSkippedRunsLater(t *testing.T) {
+	t.Parallel()
+
+	hook := HookConfig{Command: []string{"true"}, On: []string{stateDown, stateUp}, MinInterval: Duration{50 * time.Millisecond}}
+	if err := hook.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	a, b := "http://a.com", "http://b.com"
+	monitor := NewMonitorFromConfig([]string{a, b}, &Config{Hooks: []HookConfig{hook}})
+	now := time.Now()
+	// a stays down, b recovers before the skipped down run is due.
+	for _, url := range []string{a, b} {
+		monitor.hooks.trigger(StateEvent{Time: now, URL: url, State: stateDown})
+		monitor.hooks.trigger(StateEvent{Time: now.Add(10 * time.Millisecond), URL: url, State: stateDown})
+	}
+	monitor.hooks.trigger(StateEvent{Time: now.Add(20 * time.Millisecond), URL: b, State: stateUp})
+
+	ran := func(url, state string) int {
+		n := 0
+		for _, run := range monitor.hookRuns() {
+			if !run.Skipped && run.URL == url && run.State == state {
+				n++
+			}
+		}
+		return n
+	}
+	for deadline := time.Now().Add(2 * time.Second); ran(a, stateDown) < 2 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	monitor.hooks.close()
+
+	if n := ran(a, stateDown); n != 2 {
+		t.Errorf("Expected the skipped down hook to run once the interval passed, ran %d times", n)
+	}
+	if n := ran(b, stateDown); n != 1 {
+		t.Errorf("Expected the skipped down hook not to run after recovery, ran %d times", n)
+	}
+}
+
+func TestParseRuleExpr(t *testing.T) {
+	t.Parallel()
+
+	valid := []struct {
+		expr string
+		want ruleCondition
+	}{
+		{"p95 over 5m > 800ms for 3 evaluations", ruleCondition{metric: "p95", window: 5 * time.Minute, op: ">", threshold: float64(800 * time.Millisecond), times: 3}},
+		{"success rate over 15m < 98%", ruleCondition{metric: "success_rate", window: 15 * time.Minute, op: "<", threshold: 98, times: 1}},
+		{"Errors >= 10", ruleCondition{metric: "errors", op: ">=", threshold: 10, times: 1}},
+		{"size over 1h > 1MB for 1 evaluation", ruleCondition{metric: "size", window: time.Hour, op: ">", threshold: 1 << 20, times: 1}},
+	}
+	for _, tt := range valid {
+		got, err := parseRuleExpr(tt.expr)
+		if err != nil {
+			t.Errorf("Expected %q to parse, got %v", tt.expr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Expected %+v for %q, got %+v", tt.want, tt.expr, got)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"p95 > fast",
+		"latency > 800ms",
+		"p0 > 800ms",
+		"success rate < 120%",
+		"avg over 2d > 1s",
+		"avg > 1s for 0 evaluations",
+		"errors > 5 sometimes",
+	}
+	for _, expr := range invalid {
+		if _, err := parseRuleExpr(expr); err == nil {
+			t.Errorf("Expected error for %q", expr)
+		}
+	}
+
+	cfg := &Config{AlertRules: []*AlertRule{{Expr: "errors > 1"}, {Expr: "errors > 1"}}}
+	if err := cfg.validate(); err == nil {
+		t.Error("Expected error for duplicate rule names")
+	}
+}
+
+func TestAlertRules(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{
+		Targets: []TargetConfig{{URL: "http://a.com", ID: "a"}, {URL: "http://b.com", ID: "b"}},
+		AlertRules: []*AlertRule{{
+			Name:     "slow",
+			Expr:     "p95 over 5m > 800ms for 2 evaluations",
+			Targets:  []string{"a"},
+			Severity: "page",
+		}},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	monitor := NewMonitorFromConfig(cfg.urls(), cfg)
+	stat := monitor.stats["http://a.com"]
+
+	start := time.Now()
+	// Slow checks before the window must not count.
+	stat.updateAt(start.Add(-10*time.Minute), 2*time.Second, 0, true)
+	stat.updateAt(start, 100*time.Millisecond, 0, true)
+	monitor.evaluateRules(start)
+	if state := monitor.RuleStatuses()[0].State; state != RuleInactive {
+		t.Errorf("Expected inactive rule while fast, got %s", state)
+	}
+
+	stat.updateAt(start.Add(time.Second), 2*time.Second, 0, true)
+	monitor.evaluateRules(start.Add(time.Second))
+	if state := monitor.RuleStatuses()[0].State; state != RulePending {
+		t.Errorf("Expected pending rule after one match, got %s", state)
+	}
+
+	monitor.evaluateRules(start.Add(2 * time.Second))
+	status := monitor.RuleStatuses()[0]
+	if status.State != RuleFiring || status.FiredAt.IsZero() || status.Value == "" {
+		t.Errorf("Expected firing rule with value, got %+v", status)
+	}
+
+	// Once the slow checks leave the window the rule resolves.
+	later := start.Add(6 * time.Minute)
+	stat.updateAt(later, 100*time.Millisecond, 0, true)
+	monitor.evaluateRules(later)
+	status = monitor.RuleStatuses()[0]
+	if status.State != RuleResolved || status.ResolvedAt.IsZero() {
+		t.Errorf("Expected resolved rule, got %+v", status)
+	}
+
+	alerts := monitor.recentAlerts()
+	if len(alerts) != 2 || alerts[0].Severity != "page" || alerts[1].Severity != "resolved" {
+		t.Errorf("Expected firing and resolved alerts, got %v", alerts)
+	}
+
+	rec := httptest.NewRecorder()
+	monitor.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/rules", nil))
+	var statuses []RuleStatus
+	if err := json.NewDecoder(rec.Body).Decode(&statuses); err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 1 || statuses[0].TargetID != "a" {
+		t.Errorf("Expected the rule to apply to target a only, got %+v", statuses)
+	}
+}
+
+func TestEscalation(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{
+		Notifiers: []NotifierConfig{
+			{Name: "oncall", Type: NotifierSlack, URL: "http://127.0.0.1:1/slack"},
+			{Name: "pager", Type: NotifierPagerDuty, URL: "http://127.0.0.1:1/pd", RoutingKey: "key"},
+		},
+		Escalation: []EscalationStep{
+			{Notify: []string{"oncall"}},
+			{After: Duration{15 * time.Minute}, Notify: []string{"pager"}},
+		},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	a, b := "http://a.com", "http://b.com"
+	monitor := NewMonitorFromConfig([]string{a, b}, cfg)
+
+	recipients := func() []string {
+		var names []string
+		for len(monitor.notifications) > 0 {
+			n := <-monitor.notifications
+			for _, to := range n.to {
+				names = append(names, n.State+":"+to.name)
+			}
+		}
+		return names
+	}
+
+	start := time.Now()
+	monitor.updateStats(a, CheckResult{Time: start, Error: "refused"})
+	monitor.updateStats(b, CheckResult{Time: start, Error: "refused"})
+	if got := recipients(); !slices.Equal(got, []string{"down:oncall", "down:oncall"}) {
+		t.Errorf("Expected first step only, got %v", got)
+	}
+
+	// Acknowledging b stops it from escalating.
+	escalations := monitor.Escalations()
+	rec := httptest.NewRecorder()
+	monitor.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/api/escalations/"+escalations[1].ID+"/ack", strings.NewReader(`{"by": "alice"}`)))
+	var acked Escalation
+	json.NewDecoder(rec.Body).Decode(&acked)
+	if rec.Code != http.StatusOK || acked.State != EscalationAcknowledged || acked.AcknowledgedBy != "alice" || acked.TimeToAck.Duration <= 0 {
+		t.Errorf("Expected acknowledged escalation, got %d %+v", rec.Code, acked)
+	}
+
+	rec = httptest.NewRecorder()
+	monitor.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/api/escalations/"+escalations[1].ID+"/ack", nil))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 acknowledging twice, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	monitor.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/api/escalations/99/resolve", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown escalation, got %d", rec.Code)
+	}
+
+	monitor.escalate(start.Add(10 * time.Minute))
+	if got := recipients(); len(got) != 0 {
+		t.Errorf("Expected no escalation before 15m, got %v", got)
+	}
+	monitor.escalate(start.Add(16 * time.Minute))
+	if got := recipients(); !slices.Equal(got, []string{"down:pager"}) {
+		t.Errorf("Expected a to escalate to pager, got %v", got)
+	}
+
+	// Recovery goes to everyone who was told about the outage.
+	monitor.updateStats(a, CheckResult{Time: start.Add(20 * time.Minute), Success: true})
+	monitor.updateStats(b, CheckResult{Time: start.Add(20 * time.Minute), Success: true})
+	if got := recipients(); !slices.Equal(got, []string{"up:oncall", "up:pager", "up:oncall"}) {
+		t.Errorf("Expected recoveries to notified notifiers, got %v", got)
+	}
+
+	escalations = monitor.Escalations()
+	if escalations[0].State != EscalationResolved || escalations[0].ResolvedBy != "recovery" || escalations[0].Steps != 2 {
+		t.Errorf("Expected a resolved by recovery after 2 steps, got %+v", escalations[0])
+	}
+	if !slices.ContainsFunc(monitor.recentAlerts(), func(a Alert) bool { return a.Severity == "escalated" }) {
+		t.Error("Expected an escalated alert")
+	}
+
+	invalid := []*Config{
+		{Notifiers: cfg.Notifiers, Escalation: []EscalationStep{{Notify: []string{"nobody"}}}},
+		{Notifiers: cfg.Notifiers, Escalation: []EscalationStep{{After: Duration{time.Hour}, Notify: []string{"pager"}}, {Notify: []string{"oncall"}}}},
+		{Notifiers: []NotifierConfig{cfg.Notifiers[0], cfg.Notifiers[0]}, Escalation: []EscalationStep{{Notify: []string{"oncall"}}}},
+	}
+	for _, c := range invalid {
+		if err := c.validate(); err == nil {
+			t.Errorf("Expected error for escalation %+v", c.Escalation)
+		}
+	}
+}
+
+func TestIncidentLog(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "incidents.jsonl")
+	a, b := "http://a.com", "http://b.com"
+	cfg := &Config{IncidentLog: path}
+
+	monitor := NewMonitorFromConfig([]string{a, b}, cfg)
+	var out bytes.Buffer
+	monitor.out = &out
+	monitor.renderer = &tableRenderer{layout: monitor.tableLayout}
+
+	start := time.Now().Add(-time.Hour)
+	monitor.updateStats(a, CheckResult{Time: start, Error: "refused"})
+	monitor.updateStats(a, CheckResult{Time: start.Add(time.Minute), Error: "refused"})
+	monitor.updateStats(a, CheckResult{Time: start.Add(2 * time.Minute), Success: true})
+	monitor.updateStats(b, CheckResult{Time: start.Add(3 * time.Minute), Error: "timeout"})
+
+	rec := httptest.NewRecorder()
+	monitor.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/incidents", nil))
+	var records []IncidentRecord
+	json.NewDecoder(rec.Body).Decode(&records)
+	if len(records) != 2 || records[0].URL != a || records[0].Checks != 2 || records[0].Duration(time.Now()) != 2*time.Minute || !records[1].Ongoing() {
+		t.Errorf("Expected a closed and an ongoing incident, got %+v", records)
+	}
+
+	rec = httptest.NewRecorder()
+	monitor.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/incidents?url=http://c.com", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown target, got %d", rec.Code)
+	}
+
+	monitor.DisplayFinalTable()
+	if !strings.Contains(out.String(), "Incidents:") || !strings.Contains(out.String(), "ongoing") {
+		t.Errorf("Expected incidents in the final table, got:\n%s", out.String())
+	}
+
+	stop := time.Now()
+	if err := monitor.FlushIncidentLog(stop); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 2 || !strings.Contains(lines[1], `"interrupted":true`) {
+		t.Errorf("Expected the closed and the interrupted incident in the log, got:\n%s", data)
+	}
+
+	// A new run picks the incidents up again, with b's closed at shutdown.
+	restarted := NewMonitorFromConfig([]string{a, b}, cfg)
+	if err := restarted.loadIncidentLog(); err != nil {
+		t.Fatal(err)
+	}
+	records = restarted.Incidents("")
+	if len(records) != 2 || records[1].Ongoing() || !records[1].End.Equal(stop) {
+		t.Errorf("Expected restored incidents, got %+v", records)
+	}
+
+	// An incident logged while still open is closed at the time it was
+	// logged, not whenever the log was last written to.
+	logged := stop.Add(time.Minute)
+	ongoing := IncidentRecord{URL: a, Incident: Incident{Start: stop.Add(-time.Minute), FirstError: "refused"}}
+	if err := monitor.incidentLog.append(logged, ongoing); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, time.Time{}, logged.Add(time.Hour))
+	restarted = NewMonitorFromConfig([]string{a, b}, cfg)
+	if err := restarted.loadIncidentLog(); err != nil {
+		t.Fatal(err)
+	}
+	last, _ := restarted.stats[a].LastIncident()
+	if !last.Interrupted || !last.End.Equal(logged) {
+		t.Errorf("Expected the open incident closed at %v, got %+v", logged, last)
+	}
+
+	report := filepath.Join(t.TempDir(), "report.md")
+	if err := restarted.WriteReport(report, ""); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(report); !strings.Contains(string(data), "## Incidents") || !strings.Contains(string(data), "timeout") {
+		t.Errorf("Expected incidents in the report, got:\n%s", data)
+	}
+}
+
+func TestApdex(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{
+		ApdexT:  Duration{100 * time.Millisecond},
+		Targets: []TargetConfig{{URL: "http://a.com"}, {URL: "http://b.com", ApdexT: Duration{time.Second}}},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	monitor := NewMonitorFromConfig(cfg.urls(), cfg)
+
+	now := time.Now()
+	for _, url := range cfg.urls() {
+		stat := monitor.stats[url]
+		stat.updateAt(now.Add(-2*time.Hour), 50*time.Millisecond, 0, true)
+		stat.updateAt(now, 200*time.Millisecond, 0, true)
+		stat.updateAt(now, 500*time.Millisecond, 0, true)
+		stat.updateAt(now, 50*time.Millisecond, 0, false)
+	}
+
+	a := monitor.stats["http://a.com"].GetSnapshot()
+	// One satisfied, one tolerating, one frustrated and one failed.
+	if score, ok := a.Apdex(); !ok || score != 0.375 {
+		t.Errorf("Expected lifetime Apdex 0.375, got %v", score)
+	}
+	// The satisfied check is older than an hour.
+	if score, _ := monitor.stats["http://a.com"].Window(now, apdexWindow).Apdex(); score != 0.5/3 {
+		t.Errorf("Expected 1h Apdex %v, got %v", 0.5/3, score)
+	}
+	b := monitor.stats["http://b.com"].GetSnapshot()
+	if score, _ := b.Apdex(); score != 0.75 {
+		t.Errorf("Expected per-target T to give 0.75, got %v", score)
+	}
+	if _, ok := NewURLStats("http://c.com").Apdex(); ok {
+		t.Error("Expected no Apdex without checks")
+	}
+
+	columns, err := lookupColumns([]string{"url", "apdex", "apdex-1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := monitor.tableRows()[0]
+	if got := columns[1].value(row) + " " + columns[2].value(row); got != "0.38 0.17" {
+		t.Errorf("Expected Apdex columns '0.38 0.17', got '%s'", got)
+	}
+
+	var apdex float64
+	for _, field := range monitor.aggregateSamples(now)[0].Fields {
+		if field.Name == "apdex" {
+			apdex = field.Value
+		}
+	}
+	if apdex != 0.375 {
+		t.Errorf("Expected exported apdex 0.375, got %v", apdex)
+	}
+
+	if _, err := parseRuleExpr("apdex over 15m < 0.85"); err != nil {
+		t.Errorf("Expected Apdex rule to parse, got %v", err)
+	}
+	if _, err := parseRuleExpr("apdex < 85%"); err == nil {
+		t.Error("Expected error for Apdex threshold above 1")
+	}
+}
+
+func TestAnomalyDetection(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "baselines.json")
+	a, b := "http://a.com", "http://b.com"
+	cfg := &Config{
+		BaselineFile: path,
+		Targets:      []TargetConfig{{URL: a, Anomaly: &AnomalyConfig{Warmup: 10}}, {URL: b}},
+		AlertRules:   []*AlertRule{{Expr: "anomalies over 1h > 0"}},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	monitor := NewMonitorFromConfig(cfg.urls(), cfg)
+
+	start := time.Now().Add(-time.Hour)
+	for i := range 20 {
+		duration := 100*time.Millisecond + time.Duration(i%3)*5*time.Millisecond
+		monitor.updateStats(a, CheckResult{Time: start.Add(time.Duration(i) * time.Second), Duration: duration, Success: true})
+		monitor.updateStats(b, CheckResult{Time: start.Add(time.Duration(i) * time.Second), Duration: duration, Success: true})
+	}
+	if records := monitor.Anomalies(""); len(records) != 0 {
+		t.Fatalf("Expected no anomalies for steady latency, got %+v", records)
+	}
+
+	slow := start.Add(time.Minute)
+	monitor.updateStats(a, CheckResult{Time: slow, Duration: 900 * time.Millisecond, Success: true})
+	monitor.updateStats(b, CheckResult{Time: slow, Duration: 900 * time.Millisecond, Success: true})
+	// Failed checks never count as anomalies.
+	monitor.updateStats(a, CheckResult{Time: slow.Add(time.Second), Duration: 5 * time.Second, Error: "timeout"})
+
+	records := monitor.Anomalies("")
+	if len(records) != 1 || records[0].URL != a || records[0].Score <= 3 || records[0].Hour != -1 {
+		t.Fatalf("Expected one slow anomaly for a, got %+v", records)
+	}
+	if alerts := monitor.recentAlerts(); !slices.ContainsFunc(alerts, func(alert Alert) bool { return alert.Severity == "anomaly" && alert.URL == a }) {
+		t.Errorf("Expected an anomaly alert, got %v", alerts)
+	}
+
+	monitor.evaluateRules(slow.Add(2 * time.Second))
+	statuses := monitor.RuleStatuses()
+	if !slices.ContainsFunc(statuses, func(s RuleStatus) bool { return s.URL == a && s.State == RuleFiring }) {
+		t.Errorf("Expected the anomalies rule to fire for a, got %v", statuses)
+	}
+
+	rec := httptest.NewRecorder()
+	monitor.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/anomalies?url="+a, nil))
+	var listed []AnomalyRecord
+	json.NewDecoder(rec.Body).Decode(&listed)
+	if len(listed) != 1 || listed[0].Duration.Duration != 900*time.Millisecond {
+		t.Errorf("Expected the anomaly from the API, got %+v", listed)
+	}
+
+	// The baseline survives a restart, so the next slow check is flagged
+	// without another warmup.
+	if err := monitor.SaveBaselines(); err != nil {
+		t.Fatal(err)
+	}
+	restarted := NewMonitorFromConfig(cfg.urls(), cfg)
+	if err := restarted.loadBaselines(); err != nil {
+		t.Fatal(err)
+	}
+	restarted.updateStats(a, CheckResult{Time: slow.Add(time.Minute), Duration: 2 * time.Second, Success: true})
+	if records := restarted.Anomalies(a); len(records) != 1 {
+		t.Errorf("Expected an anomaly with the restored baseline, got %+v", records)
+	}
+}
+
+func TestLatencyBaselineHourOfDay(t *testing.T) {
+	t.Parallel()
+
+	cfg := &AnomalyConfig{Warmup: 5, HourOfDay: true}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nights are fast and days slow; each hour is judged against its own
+	// baseline once it has one.
+	var baseline latencyBaseline
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	for d := range 10 {
+		night := day.AddDate(0, 0, d).Add(3 * time.Hour)
+		noon := day.AddDate(0, 0, d).Add(12 * time.Hour)
+		baseline.observe(cfg, night, 50*time.Millisecond+time.Duration(d%2)*time.Millisecond)
+		baseline.observe(cfg, noon, 400*time.Millisecond+time.Duration(d%2)*10*time.Millisecond)
+	}
+
+	noon := day.AddDate(0, 0, 10).Add(12 * time.Hour)
+	if anomaly, ok := baseline.observe(cfg, noon, 405*time.Millisecond); ok {
+		t.Errorf("Expected a usual midday latency to pass, got %v", anomaly)
+	}
+	night := day.AddDate(0, 0, 10).Add(3 * time.Hour)
+	anomaly, ok := baseline.observe(cfg, night, 400*time.Millisecond)
+	if !ok || anomaly.Hour != 3 {
+		t.Errorf("Expected a midday latency at night to be an anomaly of hour 3, got %v, %v", anomaly, ok)
+	}
+}
+
+func TestDurationVariance(t *testing.T) {
+	t.Parallel()
+
+	monitor := NewMonitor([]string{"http://a.com"})
+	stats := monitor.stats["http://a.com"]
+	stats.SetRetention(time.Hour)
+	now := time.Now()
+	for i, ms := range []int{100, 300, 200, 400} {
+		stats.Record(CheckResult{Time: now.Add(time.Duration(i-4) * time.Second), Duration: time.Duration(ms) * time.Millisecond, Success: true})
+	}
+
+	lifetime, window := stats.GetSnapshot(), stats.WindowSnapshot(now, time.Minute)
+	for _, snapshot := range []*URLStats{&lifetime, &window} {
+		// Sample standard deviation of 100, 300, 200 and 400ms.
+		if got := snapshot.StdDev().Round(time.Millisecond); got != 129*time.Millisecond {
+			t.Errorf("Expected stddev 129ms, got %v", got)
+		}
+		if got := snapshot.CoefficientOfVariation(); math.Abs(got-0.516) > 0.001 {
+			t.Errorf("Expected coefficient of variation 0.516, got %v", got)
+		}
+		// |300-100| + |200-300| + |400-200| over three pairs.
+		if got := snapshot.Jitter().Round(time.Millisecond); got != 167*time.Millisecond {
+			t.Errorf("Expected jitter 167ms, got %v", got)
+		}
+	}
+
+	single := NewURLStats("http://b.com")
+	single.Update(100*time.Millisecond, 0, true)
+	if snapshot := single.GetSnapshot(); snapshot.StdDev() != 0 || snapshot.Jitter() != 0 {
+		t.Errorf("Expected no spread after one check, got %v and %v", snapshot.StdDev(), snapshot.Jitter())
+	}
+
+	columns, err := lookupColumns([]string{"stddev", "cv", "jitter"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := monitor.tableRows()[0]
+	if got := columns[0].value(row) + " " + columns[1].value(row) + " " + columns[2].value(row); got != "129ms 52% 166ms" {
+		t.Errorf("Expected variance columns '129ms 52%% 166ms', got '%s'", got)
+	}
+
+	if _, err := parseRuleExpr("jitter over 5m > 200ms"); err != nil {
+		t.Errorf("Expected jitter rule to parse, got %v", err)
+	}
+}
+
+func TestRecentChecks(t *testing.T) {
+	t.Parallel()
+
+	a, b := "http://a.com", "http://b.com"
+	cfg := &Config{RecentChecks: 5}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	monitor := NewMonitorFromConfig([]string{a, b}, cfg)
+	var out bytes.Buffer
+	monitor.out = &out
+	monitor.renderer = &tableRenderer{layout: monitor.tableLayout}
+
+	start := time.Now().Add(-time.Hour)
+	for i := range 8 {
+		result := CheckResult{Time: start.Add(time.Duration(i) * time.Second), StatusCode: 200, Duration: time.Duration(i+1) * time.Millisecond, BodySize: 100, Success: true}
+		if i == 6 {
+			result = CheckResult{Time: result.Time, StatusCode: 503, Duration: time.Millisecond, Error: "HTTP 503", ErrorKind: ErrorHTTP5xx}
+		}
+		monitor.updateStats(a, result)
+	}
+	monitor.updateStats(b, CheckResult{Time: start.Add(10 * time.Second), StatusCode: 200, Success: true})
+
+	// Only the newest five are kept, oldest first, and snapshots carry
+	// their own copy.
+	snapshot := monitor.stats[a].GetSnapshot()
+	checks := snapshot.RecentChecks()
+	if len(checks) != 5 || checks[0].Duration != 4*time.Millisecond || checks[3].StatusCode != 503 {
+		t.Fatalf("Expected checks 4 to 8, got %+v", checks)
+	}
+	monitor.updateStats(a, CheckResult{Time: start.Add(20 * time.Second), Success: true})
+	if got := snapshot.RecentChecks(); len(got) != 5 || got[4].Duration != 8*time.Millisecond {
+		t.Errorf("Expected the snapshot to be unaffected by later checks, got %+v", got)
+	}
+	if err := (&Config{RecentChecks: maxRecentChecks + 1}).validate(); err == nil {
+		t.Errorf("Expected error for recent_checks above %d", maxRecentChecks)
+	}
+
+	rec := httptest.NewRecorder()
+	monitor.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/checks", nil))
+	var records []CheckRecord
+	json.NewDecoder(rec.Body).Decode(&records)
+	if len(records) != 6 || records[4].URL != b || records[5].URL != a {
+		t.Errorf("Expected checks of both targets ordered by time, got %+v", records)
+	}
+	rec = httptest.NewRecorder()
+	monitor.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/checks?url="+b, nil))
+	records = nil
+	json.NewDecoder(rec.Body).Decode(&records)
+	if len(records) != 1 || records[0].StatusCode != 200 {
+		t.Errorf("Expected the check of b, got %+v", records)
+	}
+
+	monitor.DisplayFinalTable()
+	if got := out.String(); !strings.Contains(got, "Recent failures:") || !strings.Contains(got, "503  1ms      HTTP 503") {
+		t.Errorf("Expected the failure in the final table, got:\n%s", got)
+	}
+}
+
+func TestFailureSnapshots(t *testing.T) {
+	t.Parallel()
+
+	failing := true
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !failing {
+			w.Write([]byte("ok"))
+			return
+		}
+		w.Header().Set("X-Request-Id", "abc123")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("database connection pool exhausted"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("keep me"), 0o644)
+	cfg := &Config{Snapshots: &SnapshotConfig{Dir: dir, MaxBody: 8, MaxFiles: 2}}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	monitor := NewMonitorFromConfig([]string{server.URL}, cfg)
+
+	for range 3 {
+		monitor.makeRequest(context.Background(), server.URL)
+	}
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+	monitor.makeRequest(context.Background(), server.URL)
+
+	checks := monitor.stats[server.URL].RecentChecks()
+	if len(checks) != 4 || checks[2].Snapshot == "" || checks[3].Snapshot != "" {
+		t.Fatalf("Expected snapshots linked from the failed checks only, got %+v", checks)
+	}
+	data, err := os.ReadFile(checks[2].Snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"HTTP/1.1 503 Service Unavailable", "X-Request-Id: abc123", "\r\n\r\ndatabase\n[body truncated at 8B]"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected snapshot to contain %q, got:\n%s", want, data)
+		}
+	}
+
+	// Rotation keeps the two newest snapshots and leaves other files alone.
+	entries, _ := os.ReadDir(dir)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if len(names) != 3 || !slices.Contains(names, "notes.txt") || !slices.Contains(names, filepath.Base(checks[2].Snapshot)) {
+		t.Errorf("Expected two snapshots and notes.txt, got %v", names)
+	}
+	if _, err := os.Stat(checks[0].Snapshot); !os.IsNotExist(err) {
+		t.Errorf("Expected the oldest snapshot to be removed, got %v", err)
+	}
+}
+
+func TestHARExport(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s1"})
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+	target := server.URL + "/search?q=go&lang=en"
+	refused := "http://127.0.0.1:1/"
+
+	monitor := NewMonitor([]string{target, refused})
+	monitor.makeRequest(context.Background(), target)
+	monitor.makeRequest(context.Background(), target)
+	monitor.makeRequest(context.Background(), refused)
+
+	rec := httptest.NewRecorder()
+	monitor.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/har?url="+url.QueryEscape(target), nil))
+	var doc harDocument
+	if err := json.NewDecoder(rec.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Log.Version != "1.2" || len(doc.Log.Entries) != 2 {
+		t.Fatalf("Expected a HAR 1.2 log with two entries, got %+v", doc.Log)
+	}
+
+	first, second := doc.Log.Entries[0], doc.Log.Entries[1]
+	request, response := first.Request, first.Response
+	if request.Method != "GET" || request.HTTPVersion != "HTTP/1.1" || !slices.Contains(request.Headers, harNameValue{"User-Agent", "Go-http-client/1.1"}) {
+		t.Errorf("Expected the request as written, got %+v", request)
+	}
+	if !slices.Equal(request.QueryString, []harNameValue{{"lang", "en"}, {"q", "go"}}) {
+		t.Errorf("Expected the query string, got %+v", request.QueryString)
+	}
+	if response.Status != 200 || response.StatusText != "OK" || response.Content.Size != 5 || response.Content.MimeType != "text/plain" ||
+		!slices.Equal(response.Cookies, []harNameValue{{"session", "s1"}}) {
+		t.Errorf("Expected the response, got %+v", response)
+	}
+
+	// The first check opens a connection that the second one reuses.
+	if first.Timings.Connect < 0 || second.Timings.Connect != -1 || second.Timings.Blocked != -1 {
+		t.Errorf("Expected a new and a reused connection, got %+v and %+v", first.Timings, second.Timings)
+	}
+	timings := first.Timings
+	sum := max(0, timings.Blocked) + max(0, timings.DNS) + max(0, timings.Connect) + timings.Send + timings.Wait + timings.Receive
+	if math.Abs(first.Time-sum) > 1e-9 || first.Time <= 0 {
+		t.Errorf("Expected time to add up the timings, got %v for %+v", first.Time, timings)
+	}
+
+	// After a redirect only the headers of the final request are kept.
+	moved := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new", http.StatusFound)
+			return
+		}
+		w.Write([]byte("moved"))
+	}))
+	defer moved.Close()
+	redirect := moved.URL + "/old"
+	redirected := NewMonitor([]string{redirect})
+	redirected.makeRequest(context.Background(), redirect)
+	headers := redirected.HAR(redirect).Log.Entries[0].Request.Headers
+	var agents int
+	for _, header := range headers {
+		if header.Name == "User-Agent" {
+			agents++
+		}
+	}
+	if agents != 1 || !slices.Contains(headers, harNameValue{"Referer", redirect}) {
+		t.Errorf("Expected the headers of the redirected request only, got %+v", headers)
+	}
+
+	path := filepath.Join(t.TempDir(), "checks.har")
+	if err := monitor.WriteHAR(path); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	doc = harDocument{}
+	json.Unmarshal(data, &doc)
+	if len(doc.Log.Entries) != 3 {
+		t.Fatalf("Expected every target in the HAR file, got %d entries", len(doc.Log.Entries))
+	}
+	failed := doc.Log.Entries[2]
+	if failed.Response.Status != 0 || failed.Response.BodySize != -1 || failed.Error == "" || failed.TargetID == "" {
+		t.Errorf("Expected the refused check without a response, got %+v", failed)
+	}
+}
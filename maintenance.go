@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaintenanceWindow is a period during which checks keep running but
+// failures are left out of SLO and uptime figures and alerts are held back.
+// A window is either one-off (Start/End) or recurring, in which case it
+// opens whenever Schedule matches and stays open for Duration.
+type MaintenanceWindow struct {
+	Name     string    `json:"name"`
+	Targets  []string  `json:"targets,omitempty"`
+	Start    time.Time `json:"start,omitempty"`
+	End      time.Time `json:"end,omitempty"`
+	Schedule string    `json:"schedule,omitempty"`
+	Duration Duration  `json:"duration,omitempty"`
+	Timezone string    `json:"timezone,omitempty"`
+
+	cron     *cronSchedule
+	location *time.Location
+}
+
+func (w *MaintenanceWindow) validate() error {
+	if w.Schedule == "" {
+		if w.Start.IsZero() || w.End.IsZero() {
+			return fmt.Errorf("either schedule or start and end are required")
+		}
+		if !w.End.After(w.Start) {
+			return fmt.Errorf("end must be after start")
+		}
+		return nil
+	}
+
+	if w.Duration.Duration <= 0 {
+		return fmt.Errorf("recurring window needs a positive duration")
+	}
+
+	cron, err := parseCron(w.Schedule)
+	if err != nil {
+		return err
+	}
+	w.cron = cron
+
+	w.location = time.Local
+	if w.Timezone != "" {
+		loc, err := time.LoadLocation(w.Timezone)
+		if err != nil {
+			return fmt.Errorf("unknown timezone '%s'", w.Timezone)
+		}
+		w.location = loc
+	}
+
+	return nil
+}
+
+func (w *MaintenanceWindow) appliesTo(url string) bool {
+	if len(w.Targets) == 0 {
+		return true
+	}
+	for _, target := range w.Targets {
+		if target == url {
+			return true
+		}
+	}
+	return false
+}
+
+// Active reports whether the window is open at t.
+func (w *MaintenanceWindow) Active(t time.Time) bool {
+	if w.cron == nil {
+		return !t.Before(w.Start) && t.Before(w.End)
+	}
+
+	local := t.In(w.location).Truncate(time.Minute)
+	for start := local; t.Sub(start) < w.Duration.Duration; start = start.Add(-time.Minute) {
+		if w.cron.matches(start) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *MaintenanceWindow) String() string {
+	name := w.Name
+	if name == "" {
+		name = "maintenance"
+	}
+	if w.cron == nil {
+		return fmt.Sprintf("%s until %s", name, w.End.Local().Format("Jan 2 15:04"))
+	}
+	return fmt.Sprintf("%s (%s for %s)", name, w.Schedule, w.Duration)
+}
+
+// cronSchedule is a standard five-field cron expression: minute, hour, day of
+// month, month and day of week. Each field accepts *, single values, ranges,
+// lists and /step.
+type cronSchedule struct {
+	minute, hour, dom, month, dow []bool
+	domAny, dowAny                bool
+}
+
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule '%s' must have 5 fields", expr)
+	}
+
+	var c cronSchedule
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("schedule '%s' minute: %v", expr, err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("schedule '%s' hour: %v", expr, err)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("schedule '%s' day of month: %v", expr, err)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("schedule '%s' month: %v", expr, err)
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("schedule '%s' day of week: %v", expr, err)
+	}
+	// Sunday may be written as 0 or 7.
+	c.dow[0] = c.dow[0] || c.dow[7]
+	c.domAny = fields[2] == "*"
+	c.dowAny = fields[4] == "*"
+
+	return &c, nil
+}
+
+func parseCronField(field string, lo, hi int) ([]bool, error) {
+	set := make([]bool, hi+1)
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if rng, s, ok := strings.Cut(part, "/"); ok {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid step '%s'", s)
+			}
+			step = n
+			part = rng
+		}
+
+		start, end := lo, hi
+		if part != "*" {
+			from, to, isRange := strings.Cut(part, "-")
+			n, err := strconv.Atoi(from)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value '%s'", from)
+			}
+			start, end = n, n
+			if isRange {
+				if end, err = strconv.Atoi(to); err != nil {
+					return nil, fmt.Errorf("invalid value '%s'", to)
+				}
+			} else if step > 1 {
+				end = hi
+			}
+		}
+
+		if start < lo || end > hi || start > end {
+			return nil, fmt.Errorf("'%s' out of range %d-%d", part, lo, hi)
+		}
+		for i := start; i <= end; i += step {
+			set[i] = true
+		}
+	}
+
+	return set, nil
+}
+
+func (c *cronSchedule) matches(t time.Time) bool {
+	if !c.minute[t.Minute()] || !c.hour[t.Hour()] || !c.month[int(t.Month())] {
+		return false
+	}
+
+	// Like cron, when both day fields are restricted either may match.
+	dom, dow := c.dom[t.Day()], c.dow[int(t.Weekday())]
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// Silence mutes alerts for one target, or all targets when URL is empty,
+// until it expires. Unlike maintenance windows, silences do not affect the
+// statistics.
+type Silence struct {
+	ID      string    `json:"id"`
+	URL     string    `json:"url,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+	Created time.Time `json:"created"`
+	Until   time.Time `json:"until"`
+}
+
+func (s Silence) appliesTo(url string) bool {
+	return s.URL == "" || s.URL == url
+}
+
+// parseSilence parses the -silence flag value: either a duration that
+// silences every target, or url=duration.
+func parseSilence(value string, now time.Time) (Silence, error) {
+	url, duration := "", value
+	if i := strings.LastIndex(value, "="); i >= 0 {
+		url, duration = value[:i], value[i+1:]
+	}
+
+	d, err := parseDuration(duration)
+	if err != nil || d <= 0 {
+		return Silence{}, fmt.Errorf("invalid silence '%s': expected [url=]duration", value)
+	}
+
+	return Silence{URL: url, Created: now, Until: now.Add(d)}, nil
+}
+
+func (m *Monitor) AddSilence(s Silence) Silence {
+	m.alertsMu.Lock()
+	defer m.alertsMu.Unlock()
+
+	m.nextSilenceID++
+	s.ID = strconv.Itoa(m.nextSilenceID)
+	if s.Created.IsZero() {
+		s.Created = time.Now()
+	}
+	m.silences = append(m.silences, s)
+	return s
+}
+
+func (m *Monitor) RemoveSilence(id string) bool {
+	m.alertsMu.Lock()
+	defer m.alertsMu.Unlock()
+
+	for i, s := range m.silences {
+		if s.ID == id {
+			m.silences = append(m.silences[:i], m.silences[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Silences returns the silences that have not yet expired at now.
+func (m *Monitor) Silences(now time.Time) []Silence {
+	m.alertsMu.Lock()
+	defer m.alertsMu.Unlock()
+
+	active := m.silences[:0]
+	for _, s := range m.silences {
+		if now.Before(s.Until) {
+			active = append(active, s)
+		}
+	}
+	m.silences = active
+
+	return append([]Silence(nil), active...)
+}
+
+// maintenanceWindow returns the open maintenance window for url at now, if
+// there is one.
+func (m *Monitor) maintenanceWindow(url string, now time.Time) *MaintenanceWindow {
+	for _, w := range m.maintenance[url] {
+		if w.Active(now) {
+			return w
+		}
+	}
+	return nil
+}
+
+// suppressed reports whether alerts for url should be held back at now.
+func (m *Monitor) suppressed(url string, now time.Time) bool {
+	if m.maintenanceWindow(url, now) != nil {
+		return true
+	}
+	for _, s := range m.Silences(now) {
+		if s.appliesTo(url) {
+			return true
+		}
+	}
+	return false
+}
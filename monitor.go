@@ -2,35 +2,173 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"os"
+	"slices"
 	"sync"
 	"time"
 )
 
 type Monitor struct {
 	urls        []string
+	targets     map[string]TargetConfig
+	ids         map[string]string
 	stats       map[string]*URLStats
 	httpClient  *http.Client
 	statsMu     sync.RWMutex
 	updatedData chan struct{}
+
+	alertsMu      sync.Mutex
+	alerts        []Alert
+	burnState     map[string]string
+	ruleStates    map[string]*RuleStatus
+	silences      []Silence
+	nextSilenceID int
+
+	maintenance map[string][]*MaintenanceWindow
+
+	scheduler        *scheduler
+	firstCheckJitter time.Duration
+
+	// interactive selects the TUI instead of the plain table.
+	interactive bool
+	columns     []tableColumn
+	color       bool
+	renderer    Renderer
+	statusPage  *StatusPage
+	incidentLog *incidentLog
+	// baselineFile keeps the anomaly detection baselines across runs.
+	baselineFile string
+	snapshots    *snapshotStore
+	exporters    []*exporter
+	otlp         *otlpExporter
+
+	targetState   map[string]string
+	notifiers     []*notifierEntry
+	notifications chan notification
+	hooks         *hookRunner
+	rules         []*AlertRule
+
+	escalationLevels  []escalationLevel
+	escalations       []*Escalation
+	activeEscalations map[string]*Escalation
+	nextEscalationID  int
+
+	ruleInterval time.Duration
+	out          io.Writer
 }
 
 func NewMonitor(urls []string) *Monitor {
 	stats := make(map[string]*URLStats)
+	targets := make(map[string]TargetConfig)
 
+	defaults := &Config{}
 	for _, url := range urls {
 		stats[url] = NewURLStats(url)
+		targets[url] = defaults.targetConfig(url)
 	}
 
-	return &Monitor{
-		urls:  urls,
-		stats: stats,
+	m := &Monitor{
+		urls:    urls,
+		targets: targets,
+		stats:   stats,
 		httpClient: &http.Client{
 			Timeout: 10 * time.Second,
 		},
 		updatedData: make(chan struct{}, 100),
+		burnState:   make(map[string]string),
+		ruleStates:  make(map[string]*RuleStatus),
+
+		activeEscalations: make(map[string]*Escalation),
+		targetState:       make(map[string]string),
+		maintenance:       make(map[string][]*MaintenanceWindow),
+		scheduler:         newScheduler(0, 0),
+		out:               os.Stdout,
+	}
+	m.renderer = &tableRenderer{layout: m.tableLayout, clear: true}
+	m.assignTargetIDs()
+
+	return m
+}
+
+// NewMonitorFromConfig creates a monitor for urls and applies the per-target
+// settings from cfg.
+func NewMonitorFromConfig(urls []string, cfg *Config) *Monitor {
+	m := NewMonitor(urls)
+	m.scheduler = newScheduler(cfg.MaxInFlight, cfg.HostRate)
+	m.firstCheckJitter = cfg.StartJitter.Duration
+	m.interactive = cfg.Interactive
+	m.color = cfg.Color
+	// Columns and format were checked by validate.
+	m.columns, _ = lookupColumns(cfg.Columns)
+	m.renderer, _ = newRenderer(cfg.Format, m.tableLayout, true)
+	m.statusPage = cfg.StatusPage
+	if cfg.IncidentLog != "" {
+		m.incidentLog = &incidentLog{path: cfg.IncidentLog}
+	}
+	m.baselineFile = cfg.BaselineFile
+	if cfg.Snapshots != nil {
+		m.snapshots = &snapshotStore{cfg: *cfg.Snapshots}
+	}
+	if cfg.OTLP != nil {
+		m.otlp = newOTLPExporter(*cfg.OTLP)
+	}
+	notifyClient := &http.Client{Timeout: notifyTimeout}
+	for _, notifierCfg := range cfg.Notifiers {
+		m.notifiers = append(m.notifiers, newNotifierEntry(notifierCfg, notifyClient))
+	}
+	m.notifications = make(chan notification, notifyQueueSize)
+	m.escalationLevels = escalationLevels(cfg.Escalation, m.notifiers)
+	if len(cfg.Hooks) > 0 {
+		m.hooks = newHookRunner(cfg.Hooks)
+		m.hooks.recorded = m.hookAlert
+	}
+	for _, exporterCfg := range cfg.Exporters {
+		m.exporters = append(m.exporters, newExporter(exporterCfg))
+	}
+
+	for _, url := range urls {
+		target := cfg.targetConfig(url)
+		m.targets[url] = target
+		if target.SLO != nil {
+			m.stats[url].SetSLO(target.SLO)
+		}
+		if target.Anomaly != nil {
+			m.stats[url].SetAnomaly(target.Anomaly)
+		}
+		m.stats[url].SetApdexT(target.ApdexT.Duration)
+		if cfg.RecentChecks > 0 {
+			m.stats[url].SetRecentChecks(cfg.RecentChecks)
+		}
+		m.maintenance[url] = target.Maintenance
+	}
+	m.assignTargetIDs()
+
+	// Rules are matched against target IDs, so they can only be set up once
+	// the IDs are assigned.
+	m.rules = cfg.AlertRules
+	m.ruleInterval = cfg.RuleInterval.Duration
+	for _, rule := range m.rules {
+		if rule.cond.window == 0 {
+			continue
+		}
+		for _, url := range urls {
+			if rule.appliesTo(m.targets[url]) {
+				m.stats[url].SetRetention(rule.cond.window)
+			}
+		}
 	}
+
+	for _, silence := range cfg.Silences {
+		m.AddSilence(silence)
+	}
+
+	return m
 }
 
 func (m *Monitor) Start(ctx context.Context, wg *sync.WaitGroup) {
@@ -39,68 +177,215 @@ func (m *Monitor) Start(ctx context.Context, wg *sync.WaitGroup) {
 		go m.monitorURL(ctx, wg, url)
 	}
 
+	m.startExporters(ctx, wg)
+
+	if len(m.notifiers) > 0 {
+		wg.Add(1)
+		go m.notifyLoop(ctx, wg)
+	}
+
+	if slices.ContainsFunc(m.escalationLevels, func(l escalationLevel) bool { return l.after > 0 }) {
+		wg.Add(1)
+		go m.escalationLoop(ctx, wg)
+	}
+
+	if m.hooks != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-ctx.Done()
+			m.hooks.close()
+		}()
+	}
+
+	if len(m.rules) > 0 {
+		wg.Add(1)
+		go m.ruleLoop(ctx, wg)
+	}
+
+	if m.statusPage != nil {
+		wg.Add(1)
+		go m.statusPageLoop(ctx, wg)
+	}
+
 	wg.Add(1)
-	go m.displayLoop(ctx, wg)
+	if m.interactive {
+		go m.tuiLoop(ctx, wg)
+	} else {
+		go m.displayLoop(ctx, wg)
+	}
 }
 
 func (m *Monitor) monitorURL(ctx context.Context, wg *sync.WaitGroup, url string) {
 	defer wg.Done()
 
+	if delay := jitter(m.firstCheckJitter); delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
 
-	m.makeRequest(ctx, url)
+	m.scheduleCheck(ctx, url, time.Now())
 
 	for {
 		select {
-		case <-ticker.C:
-			m.makeRequest(ctx, url)
+		case due := <-ticker.C:
+			m.scheduleCheck(ctx, url, due)
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
+// scheduleCheck runs a check that was due at the given time once the
+// scheduler lets it through, recording how late it started.
+func (m *Monitor) scheduleCheck(ctx context.Context, url string, due time.Time) {
+	release, err := m.scheduler.acquire(ctx, url)
+	if err != nil {
+		return
+	}
+	defer release()
+
+	m.statsMu.RLock()
+	stat := m.stats[url]
+	m.statsMu.RUnlock()
+	stat.RecordScheduleDelay(time.Since(due))
+
+	m.makeRequest(ctx, url)
+}
+
 func (m *Monitor) makeRequest(ctx context.Context, url string) {
+	m.statsMu.RLock()
+	target := m.targets[url]
+	m.statsMu.RUnlock()
+
 	start := time.Now()
+	result := CheckResult{Time: start, Method: target.Method}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	var phases phaseRecorder
+	traceCtx := httptrace.WithClientTrace(ctx, phases.clientTrace())
+
+	req, err := http.NewRequestWithContext(traceCtx, target.Method, url, nil)
 	if err != nil {
-		m.updateStats(url, time.Since(start), 0, false)
+		result.Duration = time.Since(start)
+		result.Error = err.Error()
+		m.updateStats(url, result)
 		return
 	}
+	if m.otlp != nil {
+		result.TraceID, result.SpanID = newTraceIDs()
+		req.Header.Set("traceparent", traceparent(result.TraceID, result.SpanID))
+	}
 
 	resp, err := m.httpClient.Do(req)
-	duration := time.Since(start)
-
-	var bodySize int64
-	var success bool
+	result.Duration = time.Since(start)
 
 	if err != nil {
-		success = false
-		bodySize = 0
+		result.Error = err.Error()
+		result.ErrorKind = classifyError(err)
 	} else {
 		defer resp.Body.Close()
 
-		body, err := io.ReadAll(resp.Body)
+		result.StatusCode = resp.StatusCode
+		result.Proto = resp.Proto
+		result.ResponseHeader = resp.Header.Clone()
+		success := resp.StatusCode >= 200 && resp.StatusCode < 400
+
+		// Keep the start of the body in case the check fails and its
+		// response is saved.
+		var reader io.Reader = resp.Body
+		var captured *limitedBuffer
+		if m.snapshots != nil {
+			captured = &limitedBuffer{limit: int(m.snapshots.cfg.MaxBody)}
+			reader = io.TeeReader(resp.Body, captured)
+		}
+
+		var body bodyInfo
+		switch {
+		case target.Method == http.MethodHead || target.SkipBody:
+		case target.DetectChanges:
+			result.Content, body, err = readBodyContent(reader, int64(target.MaxBodySize), target.HashBody)
+			if err == nil {
+				result.ContentHash = contentHash(result.Content, target.ignore)
+			}
+		default:
+			body, err = readBody(reader, int64(target.MaxBodySize), target.HashBody)
+		}
+
 		if err != nil {
-			success = false
-			bodySize = 0
+			result.Error = fmt.Sprintf("reading body: %v", err)
+			result.ErrorKind = ErrorBody
+			if classifyError(err) == ErrorTimeout {
+				result.ErrorKind = ErrorTimeout
+			}
+			result.Content = nil
 		} else {
-			bodySize = int64(len(body))
-			success = resp.StatusCode >= 200 && resp.StatusCode < 400
+			result.BodySize = body.Size
+			result.BodyHash = body.Hash
+			result.Truncated = body.Truncated
+			result.Success = success
+			if !success {
+				result.Error = "unexpected status " + resp.Status
+				result.ErrorKind = ErrorHTTP4xx
+				if resp.StatusCode >= 500 {
+					result.ErrorKind = ErrorHTTP5xx
+				}
+			}
+		}
+
+		// Failures in a maintenance window are excluded from the stats, so
+		// there is nothing to point a snapshot at.
+		if captured != nil && !result.Success && m.maintenanceWindow(url, time.Now()) == nil {
+			path, err := m.snapshots.save(target.ID, result, resp, captured)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
+			result.Snapshot = path
 		}
 	}
 
-	m.updateStats(url, duration, bodySize, success)
+	result.Phases = phases.list()
+	result.RequestHeader = phases.requestHeaders()
+	m.updateStats(url, result)
+}
+
+func classifyError(err error) string {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return ErrorTimeout
+	}
+	if errors.As(err, &netErr) {
+		return ErrorConnection
+	}
+	return ErrorOther
 }
 
-func (m *Monitor) updateStats(url string, duration time.Duration, bodySize int64, success bool) {
+func (m *Monitor) updateStats(url string, result CheckResult) {
 	m.statsMu.RLock()
 	stat := m.stats[url]
 	m.statsMu.RUnlock()
 
-	stat.Update(duration, bodySize, success)
+	now := time.Now()
+	if !result.Success && m.maintenanceWindow(url, now) != nil {
+		stat.Exclude()
+	} else {
+		if stat.Record(result) {
+			m.contentChanged(url, now)
+		}
+		m.incidentClosed(url, result)
+		m.anomalyDetected(url, result, now)
+		m.exportCheck(url, result)
+		m.exportSpan(url, result)
+		m.checkSLO(url, now)
+		m.checkState(url, result, now)
+	}
 
 	select {
 	case m.updatedData <- struct{}{}:
@@ -111,12 +396,12 @@ func (m *Monitor) updateStats(url string, duration time.Duration, bodySize int64
 func (m *Monitor) displayLoop(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	m.displayTable()
+	m.renderer.Render(m.out, m.frame(false))
 
 	for {
 		select {
 		case <-m.updatedData:
-			m.displayTable()
+			m.renderer.Render(m.out, m.frame(false))
 		case <-ctx.Done():
 			return
 		}
@@ -124,5 +409,5 @@ func (m *Monitor) displayLoop(ctx context.Context, wg *sync.WaitGroup) {
 }
 
 func (m *Monitor) DisplayFinalTable() {
-	m.displayFinalTable()
-}
\ No newline at end of file
+	m.renderer.Render(m.out, m.frame(true))
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Notifier types.
+const (
+	NotifierSlack     = "slack"
+	NotifierTeams     = "teams"
+	NotifierPagerDuty = "pagerduty"
+	NotifierEmail     = "email"
+)
+
+var notifierTypes = []string{NotifierSlack, NotifierTeams, NotifierPagerDuty, NotifierEmail}
+
+const (
+	defaultPagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+	notifyQueueSize     = 100
+	notifyTimeout       = 10 * time.Second
+)
+
+// NotifierConfig configures where state changes are sent. URL is the
+// incoming webhook for Slack and Teams; PagerDuty needs a RoutingKey and
+// only uses URL to override the Events v2 endpoint. Email is configured
+// entirely by Email.
+type NotifierConfig struct {
+	// Name refers to the notifier in escalation steps. It defaults to
+	// the type.
+	Name       string       `json:"name,omitempty"`
+	Type       string       `json:"type"`
+	URL        string       `json:"url,omitempty"`
+	RoutingKey string       `json:"routing_key,omitempty"`
+	Email      *EmailConfig `json:"email,omitempty"`
+}
+
+func (c NotifierConfig) name() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.Type
+}
+
+func (c *NotifierConfig) validate() error {
+	if !slices.Contains(notifierTypes, c.Type) {
+		return fmt.Errorf("unknown type '%s', expected one of %s", c.Type, strings.Join(notifierTypes, ", "))
+	}
+	if c.Type == NotifierEmail {
+		if c.Email == nil {
+			return fmt.Errorf("email settings are required")
+		}
+		return c.Email.validate()
+	}
+	if c.Type == NotifierPagerDuty {
+		if c.RoutingKey == "" {
+			return fmt.Errorf("routing_key is required")
+		}
+		if c.URL == "" {
+			c.URL = defaultPagerDutyURL
+		}
+	}
+	if !strings.HasPrefix(c.URL, "http://") && !strings.HasPrefix(c.URL, "https://") {
+		return fmt.Errorf("url must be an http(s) URL, got '%s'", c.URL)
+	}
+	return nil
+}
+
+// StateEvent is a target going down or recovering, or an alert rule
+// firing (State down) or resolving (State up) for a target.
+type StateEvent struct {
+	Time     time.Time `json:"time"`
+	URL      string    `json:"url"`
+	TargetID string    `json:"target_id"`
+	Rule     string    `json:"rule,omitempty"`
+	State    string    `json:"state"`
+	Error    string    `json:"error,omitempty"`
+	// Incident is the outage that started or ended with this event.
+	Incident Incident `json:"incident"`
+
+	// Stats and RecentErrors describe the target when the event happened,
+	// for email templates.
+	Stats        *URLStats     `json:"-"`
+	RecentErrors []CheckResult `json:"-"`
+}
+
+func (e StateEvent) Down() bool {
+	return e.State == stateDown
+}
+
+// Summary is a one-line description of the event.
+func (e StateEvent) Summary() string {
+	if e.Rule != "" {
+		if e.Down() {
+			return fmt.Sprintf("%s: rule %s is firing, %s", e.URL, e.Rule, e.Error)
+		}
+		return fmt.Sprintf("%s: rule %s resolved after %s", e.URL, e.Rule, e.Incident.Duration(e.Time).Round(time.Second))
+	}
+	if e.Down() {
+		return fmt.Sprintf("%s is down: %s", e.URL, e.Error)
+	}
+	return fmt.Sprintf("%s recovered after %s", e.URL, e.Incident.Duration(e.Time).Round(time.Second))
+}
+
+// Notifier delivers state changes to an external service.
+type Notifier interface {
+	Notify(ctx context.Context, event StateEvent) error
+}
+
+func newNotifier(cfg NotifierConfig, client *http.Client) Notifier {
+	switch cfg.Type {
+	case NotifierSlack:
+		return &slackNotifier{url: cfg.URL, client: client}
+	case NotifierTeams:
+		return &teamsNotifier{url: cfg.URL, client: client}
+	case NotifierPagerDuty:
+		return &pagerDutyNotifier{url: cfg.URL, routingKey: cfg.RoutingKey, client: client}
+	case NotifierEmail:
+		return newEmailNotifier(*cfg.Email)
+	}
+	return nil
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
+
+type slackNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func (n *slackNotifier) Notify(ctx context.Context, event StateEvent) error {
+	color, title := "good", "Recovered"
+	if event.Down() {
+		color, title = "danger", "Down"
+	}
+
+	fields := []map[string]any{
+		{"title": "Target", "value": event.TargetID, "short": true},
+		{"title": "Since", "value": event.Incident.Start.Format(time.RFC3339), "short": true},
+	}
+	if !event.Down() {
+		fields = append(fields, map[string]any{"title": "Duration", "value": event.Incident.Duration(event.Time).Round(time.Second).String(), "short": true})
+	}
+
+	return postJSON(ctx, n.client, n.url, map[string]any{
+		"text": event.Summary(),
+		"attachments": []map[string]any{{
+			"color":      color,
+			"title":      title + ": " + event.URL,
+			"title_link": event.URL,
+			"text":       event.Error,
+			"fields":     fields,
+			"ts":         event.Time.Unix(),
+		}},
+	})
+}
+
+type teamsNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func (n *teamsNotifier) Notify(ctx context.Context, event StateEvent) error {
+	color := "2E7D32"
+	if event.Down() {
+		color = "C62828"
+	}
+
+	facts := []map[string]string{
+		{"name": "Target", "value": event.TargetID},
+		{"name": "State", "value": event.State},
+		{"name": "Since", "value": event.Incident.Start.Format(time.RFC3339)},
+	}
+	if event.Error != "" {
+		facts = append(facts, map[string]string{"name": "Error", "value": event.Error})
+	}
+
+	return postJSON(ctx, n.client, n.url, map[string]any{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"themeColor": color,
+		"summary":    event.Summary(),
+		"title":      event.Summary(),
+		"sections":   []map[string]any{{"activityTitle": event.URL, "facts": facts}},
+		"potentialAction": []map[string]any{{
+			"@type":   "OpenUri",
+			"name":    "Open target",
+			"targets": []map[string]string{{"os": "default", "uri": event.URL}},
+		}},
+	})
+}
+
+// pagerDutyNotifier triggers an alert when a target goes down and resolves
+// it on recovery. The dedup key is derived from the target ID, and the rule
+// for rule alerts, so that both events refer to the same PagerDuty alert.
+type pagerDutyNotifier struct {
+	url        string
+	routingKey string
+	client     *http.Client
+}
+
+func (n *pagerDutyNotifier) Notify(ctx context.Context, event StateEvent) error {
+	dedupKey := "web-monitor/" + event.TargetID
+	if event.Rule != "" {
+		dedupKey += "/" + event.Rule
+	}
+	payload := map[string]any{
+		"routing_key":  n.routingKey,
+		"event_action": "resolve",
+		"dedup_key":    dedupKey,
+	}
+	if event.Down() {
+		payload["event_action"] = "trigger"
+		payload["payload"] = map[string]any{
+			"summary":   event.Summary(),
+			"source":    event.URL,
+			"severity":  "critical",
+			"timestamp": event.Time.Format(time.RFC3339),
+			"component": event.TargetID,
+			"custom_details": map[string]any{
+				"error":          event.Error,
+				"incident_start": event.Incident.Start.Format(time.RFC3339),
+			},
+		}
+	}
+	return postJSON(ctx, n.client, n.url, payload)
+}
+
+// NotifierStatus counts deliveries per notifier.
+type NotifierStatus struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Sent   int64  `json:"sent"`
+	Failed int64  `json:"failed"`
+	// Queued events are waiting for a digest; they move to Sent or Failed
+	// once it goes out.
+	Queued    int64  `json:"queued"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// flusher is implemented by notifiers that hold events back, such as email
+// digests, so that nothing is lost on shutdown. They count what they flush
+// themselves.
+type flusher interface {
+	Flush(ctx context.Context) error
+}
+
+// digester is implemented by notifiers that may queue events for a digest
+// instead of sending them.
+type digester interface {
+	digesting() bool
+}
+
+type notifierEntry struct {
+	name     string
+	typ      string
+	notifier Notifier
+
+	sent, failed, queued atomic.Int64
+	lastError            atomic.Value
+}
+
+func newNotifierEntry(cfg NotifierConfig, client *http.Client) *notifierEntry {
+	n := &notifierEntry{name: cfg.name(), typ: cfg.Type, notifier: newNotifier(cfg, client)}
+	if email, ok := n.notifier.(*emailNotifier); ok {
+		email.delivered = n.digestDelivered
+	}
+	return n
+}
+
+func (n *notifierEntry) recordError(err error) {
+	n.failed.Add(1)
+	n.lastError.Store(err.Error())
+}
+
+// digestDelivered moves the events of a digest from queued to sent or
+// failed.
+func (n *notifierEntry) digestDelivered(events int, err error) {
+	n.queued.Add(-int64(events))
+	if err != nil {
+		n.failed.Add(int64(events))
+		n.lastError.Store(err.Error())
+		return
+	}
+	n.sent.Add(int64(events))
+}
+
+func (n *notifierEntry) status() NotifierStatus {
+	status := NotifierStatus{
+		Name:   n.name,
+		Type:   n.typ,
+		Sent:   n.sent.Load(),
+		Failed: n.failed.Load(),
+		Queued: n.queued.Load(),
+	}
+	if err, ok := n.lastError.Load().(string); ok {
+		status.LastError = err
+	}
+	return status
+}
+
+// notification is an event waiting to be delivered to some notifiers, nil
+// meaning all of them.
+type notification struct {
+	StateEvent
+	to []*notifierEntry
+}
+
+// notify queues an event for delivery to the given notifiers without
+// blocking the check that caused it.
+func (m *Monitor) notify(event StateEvent, to []*notifierEntry) {
+	if len(to) == 0 {
+		return
+	}
+
+	select {
+	case m.notifications <- notification{event, to}:
+	default:
+		for _, n := range to {
+			n.recordError(errors.New("notification queue full"))
+		}
+	}
+}
+
+func (m *Monitor) notifyLoop(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for {
+		select {
+		case event := <-m.notifications:
+			m.deliver(event)
+		case <-ctx.Done():
+			for len(m.notifications) > 0 {
+				m.deliver(<-m.notifications)
+			}
+			for _, n := range m.notifiers {
+				if f, ok := n.notifier.(flusher); ok {
+					ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+					f.Flush(ctx)
+					cancel()
+				}
+			}
+			return
+		}
+	}
+}
+
+func (m *Monitor) deliver(event notification) {
+	to := event.to
+	if to == nil {
+		to = m.notifiers
+	}
+	for _, n := range to {
+		// Queued events are counted before Notify, so that a digest going
+		// out right away never finds them missing.
+		d, ok := n.notifier.(digester)
+		digest := ok && d.digesting()
+		if digest {
+			n.queued.Add(1)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		err := n.notifier.Notify(ctx, event.StateEvent)
+		cancel()
+
+		if err != nil {
+			if digest {
+				n.queued.Add(-1)
+			}
+			n.recordError(err)
+			continue
+		}
+		if !digest {
+			n.sent.Add(1)
+		}
+	}
+}
+
+// NotifierStatus returns the delivery counters of every notifier.
+func (m *Monitor) NotifierStatus() []NotifierStatus {
+	var statuses []NotifierStatus
+	for _, n := range m.notifiers {
+		statuses = append(statuses, n.status())
+	}
+	return statuses
+}
+
+func (m *Monitor) handleListNotifiers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, m.NotifierStatus())
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OTLPConfig sends each check as a span and per-target metrics to an
+// OpenTelemetry collector over OTLP/HTTP with JSON encoding. Endpoint is the
+// collector's base URL; /v1/traces and /v1/metrics are appended.
+type OTLPConfig struct {
+	Endpoint   string            `json:"endpoint"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Instance   string            `json:"instance,omitempty"`
+	Location   string            `json:"location,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Interval   Duration          `json:"interval,omitempty"`
+}
+
+func (c *OTLPConfig) validate() error {
+	if !strings.HasPrefix(c.Endpoint, "http://") && !strings.HasPrefix(c.Endpoint, "https://") {
+		return fmt.Errorf("endpoint must be an http(s) URL, got '%s'", c.Endpoint)
+	}
+	c.Endpoint = strings.TrimSuffix(c.Endpoint, "/")
+	if c.Interval.Duration == 0 {
+		c.Interval.Duration = defaultExportInterval
+	}
+	if c.Interval.Duration < time.Second {
+		return fmt.Errorf("interval must be at least 1s, got %s", c.Interval)
+	}
+	if c.Instance == "" {
+		c.Instance, _ = os.Hostname()
+	}
+	return nil
+}
+
+// otlpSpan is a finished check waiting to be sent.
+type otlpSpan struct {
+	TargetID string
+	URL      string
+	Method   string
+	Result   CheckResult
+}
+
+// otlpExporter queues spans like exporter queues samples, so that a slow
+// collector never holds up a check.
+type otlpExporter struct {
+	cfg      OTLPConfig
+	resource otlpResource
+	client   *http.Client
+	spans    chan otlpSpan
+
+	sent, failed, dropped atomic.Int64
+}
+
+func newOTLPExporter(cfg OTLPConfig) *otlpExporter {
+	attributes := map[string]string{
+		"service.name":        "web-monitor",
+		"service.instance.id": cfg.Instance,
+	}
+	if cfg.Location != "" {
+		attributes["monitor.location"] = cfg.Location
+	}
+	maps.Copy(attributes, cfg.Attributes)
+
+	return &otlpExporter{
+		cfg:      cfg,
+		resource: otlpResource{Attributes: otlpAttributes(attributes)},
+		client:   &http.Client{Timeout: exportTimeout},
+		spans:    make(chan otlpSpan, exportQueueSize),
+	}
+}
+
+func (e *otlpExporter) enqueue(span otlpSpan) {
+	select {
+	case e.spans <- span:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+func (e *otlpExporter) status() ExporterStatus {
+	return ExporterStatus{
+		Name:    "otlp",
+		Type:    "otlp",
+		Address: e.cfg.Endpoint,
+		Sent:    e.sent.Load(),
+		Failed:  e.failed.Load(),
+		Dropped: e.dropped.Load(),
+	}
+}
+
+// run sends queued spans every second and the monitor's aggregates every
+// interval, with a last push of both on shutdown.
+func (e *otlpExporter) run(ctx context.Context, wg *sync.WaitGroup, aggregates func(time.Time) []metricSample) {
+	defer wg.Done()
+
+	flush := time.NewTicker(exportFlushInterval)
+	defer flush.Stop()
+	metrics := time.NewTicker(e.cfg.Interval.Duration)
+	defer metrics.Stop()
+
+	var batch []otlpSpan
+	for {
+		select {
+		case span := <-e.spans:
+			batch = append(batch, span)
+			if len(batch) >= defaultExportBatch {
+				e.sendSpans(batch)
+				batch = nil
+			}
+		case <-flush.C:
+			e.sendSpans(batch)
+			batch = nil
+		case now := <-metrics.C:
+			e.sendMetrics(aggregates(now))
+		case <-ctx.Done():
+			for len(e.spans) > 0 {
+				batch = append(batch, <-e.spans)
+			}
+			e.sendSpans(batch)
+			e.sendMetrics(aggregates(time.Now()))
+			return
+		}
+	}
+}
+
+func (e *otlpExporter) sendSpans(batch []otlpSpan) {
+	if len(batch) == 0 {
+		return
+	}
+
+	var spans []otlpSpanJSON
+	for _, span := range batch {
+		spans = append(spans, newOTLPSpan(span))
+	}
+	payload := map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource":   e.resource,
+			"scopeSpans": []any{map[string]any{"scope": otlpScope, "spans": spans}},
+		}},
+	}
+	e.post("/v1/traces", payload, len(batch))
+}
+
+func (e *otlpExporter) sendMetrics(samples []metricSample) {
+	if len(samples) == 0 {
+		return
+	}
+
+	// OTLP groups data points by metric, so turn each sample field into a
+	// data point of the metric with that name.
+	byName := make(map[string]*otlpMetric)
+	var names []string
+	for _, sample := range samples {
+		for _, field := range sample.Fields {
+			name := "web_monitor." + sample.Measurement + "." + field.Name
+			metric, ok := byName[name]
+			if !ok {
+				metric = &otlpMetric{Name: name, Unit: "1"}
+				if strings.HasPrefix(field.Name, "duration") {
+					metric.Unit = "ms"
+				}
+				byName[name] = metric
+				names = append(names, name)
+			}
+			metric.Gauge.DataPoints = append(metric.Gauge.DataPoints, otlpDataPoint{
+				Attributes:   otlpAttributes(sample.Tags),
+				TimeUnixNano: otlpTime(sample.Time),
+				AsDouble:     field.Value,
+			})
+		}
+	}
+
+	var metrics []*otlpMetric
+	for _, name := range names {
+		metrics = append(metrics, byName[name])
+	}
+	payload := map[string]any{
+		"resourceMetrics": []any{map[string]any{
+			"resource":     e.resource,
+			"scopeMetrics": []any{map[string]any{"scope": otlpScope, "metrics": metrics}},
+		}},
+	}
+	e.post("/v1/metrics", payload, len(samples))
+}
+
+func (e *otlpExporter) post(path string, payload any, count int) {
+	if err := e.postJSON(path, payload); err != nil {
+		e.failed.Add(int64(count))
+		return
+	}
+	e.sent.Add(int64(count))
+}
+
+func (e *otlpExporter) postJSON(path string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.cfg.Endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.cfg.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("otlp %s returned %s", path, resp.Status)
+	}
+	return nil
+}
+
+// The OTLP/HTTP JSON encoding: IDs are hex, 64-bit integers are strings.
+
+var otlpScope = map[string]string{"name": "web-monitor"}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpAttribute struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+func otlpString(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: map[string]any{"stringValue": value}}
+}
+
+func otlpInt(key string, value int64) otlpAttribute {
+	return otlpAttribute{Key: key, Value: map[string]any{"intValue": strconv.FormatInt(value, 10)}}
+}
+
+func otlpAttributes(values map[string]string) []otlpAttribute {
+	var attributes []otlpAttribute
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		attributes = append(attributes, otlpString(key, values[key]))
+	}
+	return attributes
+}
+
+func otlpTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+type otlpEvent struct {
+	Name         string `json:"name"`
+	TimeUnixNano string `json:"timeUnixNano"`
+}
+
+type otlpSpanJSON struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes"`
+	Events            []otlpEvent     `json:"events,omitempty"`
+	Status            map[string]any  `json:"status"`
+}
+
+const (
+	otlpSpanKindClient = 3
+	otlpStatusOK       = 1
+	otlpStatusError    = 2
+)
+
+func newOTLPSpan(span otlpSpan) otlpSpanJSON {
+	result := span.Result
+	traceID, spanID := result.TraceID, result.SpanID
+	if traceID == "" {
+		traceID, spanID = newTraceIDs()
+	}
+
+	attributes := []otlpAttribute{
+		otlpString("http.request.method", span.Method),
+		otlpString("url.full", span.URL),
+		otlpString("monitor.target.id", span.TargetID),
+		otlpInt("http.response.body.size", result.BodySize),
+	}
+	if u, err := url.Parse(span.URL); err == nil && u.Hostname() != "" {
+		attributes = append(attributes, otlpString("server.address", u.Hostname()))
+		if port, err := strconv.ParseInt(u.Port(), 10, 64); err == nil {
+			attributes = append(attributes, otlpInt("server.port", port))
+		}
+	}
+	if result.StatusCode != 0 {
+		attributes = append(attributes, otlpInt("http.response.status_code", int64(result.StatusCode)))
+	}
+
+	status := map[string]any{"code": otlpStatusOK}
+	if !result.Success {
+		kind := result.ErrorKind
+		if kind == "" {
+			kind = ErrorOther
+		}
+		attributes = append(attributes, otlpString("error.type", kind))
+		status = map[string]any{"code": otlpStatusError, "message": result.Error}
+	}
+
+	var events []otlpEvent
+	for _, phase := range result.Phases {
+		events = append(events, otlpEvent{Name: phase.Name, TimeUnixNano: otlpTime(phase.Time)})
+	}
+
+	// HTTP client spans are named after the method alone, the target is in
+	// the attributes.
+	return otlpSpanJSON{
+		TraceID:           traceID,
+		SpanID:            spanID,
+		Name:              span.Method,
+		Kind:              otlpSpanKindClient,
+		StartTimeUnixNano: otlpTime(result.Time),
+		EndTimeUnixNano:   otlpTime(result.Time.Add(result.Duration)),
+		Attributes:        attributes,
+		Events:            events,
+		Status:            status,
+	}
+}
+
+type otlpMetric struct {
+	Name  string `json:"name"`
+	Unit  string `json:"unit"`
+	Gauge struct {
+		DataPoints []otlpDataPoint `json:"dataPoints"`
+	} `json:"gauge"`
+}
+
+type otlpDataPoint struct {
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	TimeUnixNano string          `json:"timeUnixNano"`
+	AsDouble     float64         `json:"asDouble"`
+}
+
+// exportSpan queues a finished check on the OTLP exporter.
+func (m *Monitor) exportSpan(url string, result CheckResult) {
+	if m.otlp == nil {
+		return
+	}
+
+	m.statsMu.RLock()
+	target := m.targets[url]
+	m.statsMu.RUnlock()
+
+	m.otlp.enqueue(otlpSpan{TargetID: target.ID, URL: url, Method: target.Method, Result: result})
+}
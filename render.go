@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Renderer draws frames of statistics. Live frames are rendered whenever a
+// check completes; the final frame once after shutdown.
+type Renderer interface {
+	Render(w io.Writer, frame Frame) error
+}
+
+// Frame is a point-in-time snapshot of every target plus the alert state.
+type Frame struct {
+	Time     time.Time
+	Final    bool
+	Rows     []tableRow
+	Alerts   []Alert
+	Silences []Silence
+	Rules    []RuleStatus
+
+	Escalations []Escalation
+	Incidents   []IncidentRecord
+
+	Exporters []ExporterStatus
+	Notifiers []NotifierStatus
+	HookRuns  []HookRun
+}
+
+var formats = []string{"table", "ndjson", "csv", "markdown", "quiet"}
+
+func newRenderer(format string, layout func() tableLayout, clear bool) (Renderer, error) {
+	switch format {
+	case "", "table":
+		return &tableRenderer{layout: layout, clear: clear}, nil
+	case "ndjson":
+		return &ndjsonRenderer{seen: make(map[string]int64)}, nil
+	case "csv":
+		return &csvRenderer{seen: make(map[string]int64)}, nil
+	case "markdown":
+		return &markdownRenderer{layout: layout}, nil
+	case "quiet":
+		return quietRenderer{}, nil
+	}
+	return nil, fmt.Errorf("unknown format '%s', expected one of %s", format, strings.Join(formats, ", "))
+}
+
+func (m *Monitor) frame(final bool) Frame {
+	now := time.Now()
+	return Frame{
+		Time:     now,
+		Final:    final,
+		Rows:     m.tableRows(),
+		Alerts:   m.recentAlerts(),
+		Silences: m.Silences(now),
+		Rules:    m.RuleStatuses(),
+
+		Escalations: m.Escalations(),
+		Incidents:   m.Incidents(""),
+
+		Exporters: m.ExporterStatus(),
+		Notifiers: m.NotifierStatus(),
+		HookRuns:  m.hookRuns(),
+	}
+}
+
+// targetRecord is the machine-readable form of a row, shared by the NDJSON
+// and CSV renderers. Durations are in milliseconds and sizes in bytes.
+type targetRecord struct {
+	Time             time.Time `json:"time"`
+	Final            bool      `json:"final,omitempty"`
+	URL              string    `json:"url"`
+	State            string    `json:"state"`
+	Requests         int64     `json:"requests"`
+	Successes        int64     `json:"successes"`
+	DurationMinMs    float64   `json:"duration_min_ms"`
+	DurationAvgMs    float64   `json:"duration_avg_ms"`
+	DurationMaxMs    float64   `json:"duration_max_ms"`
+	DurationP50Ms    float64   `json:"duration_p50_ms"`
+	DurationP90Ms    float64   `json:"duration_p90_ms"`
+	DurationP95Ms    float64   `json:"duration_p95_ms"`
+	DurationP99Ms    float64   `json:"duration_p99_ms"`
+	DurationStdDevMs float64   `json:"duration_stddev_ms"`
+	DurationCV       float64   `json:"duration_cv"`
+	JitterMs         float64   `json:"jitter_ms"`
+	SizeMin          int64     `json:"size_min"`
+	SizeAvg          int64     `json:"size_avg"`
+	SizeMax          int64     `json:"size_max"`
+	ExcludedFailures int64     `json:"excluded_failures"`
+	LateChecks       int64     `json:"late_checks"`
+	TruncatedBodies  int64     `json:"truncated_bodies"`
+	ContentChanges   int64     `json:"content_changes"`
+
+	// Apdex and Apdex1h are nil before the first check.
+	Apdex   *float64 `json:"apdex,omitempty"`
+	Apdex1h *float64 `json:"apdex_1h,omitempty"`
+
+	Errors      int64            `json:"errors"`
+	ErrorCounts map[string]int64 `json:"error_counts,omitempty"`
+
+	SLO *sloRecord `json:"slo,omitempty"`
+}
+
+type sloRecord struct {
+	Availability    float64 `json:"availability"`
+	LatencyGood     float64 `json:"latency_good"`
+	BudgetRemaining float64 `json:"budget_remaining"`
+	BurnRate1h      float64 `json:"burn_rate_1h"`
+	BurnRate6h      float64 `json:"burn_rate_6h"`
+	Alert           string  `json:"alert,omitempty"`
+	Met             bool    `json:"met"`
+}
+
+func newTargetRecord(frame Frame, row tableRow) targetRecord {
+	s := row.snapshot
+	record := targetRecord{
+		Time:             frame.Time,
+		Final:            frame.Final,
+		URL:              row.url,
+		State:            row.state,
+		Requests:         s.TotalRequests,
+		Successes:        s.SuccessCount,
+		DurationAvgMs:    milliseconds(s.AverageDuration()),
+		DurationP50Ms:    milliseconds(s.Percentile(50)),
+		DurationP90Ms:    milliseconds(s.Percentile(90)),
+		DurationP95Ms:    milliseconds(s.Percentile(95)),
+		DurationP99Ms:    milliseconds(s.Percentile(99)),
+		DurationStdDevMs: milliseconds(s.StdDev()),
+		DurationCV:       s.CoefficientOfVariation(),
+		JitterMs:         milliseconds(s.Jitter()),
+		SizeAvg:          s.AverageSize(),
+		ExcludedFailures: s.ExcludedFailures,
+		LateChecks:       s.LateChecks,
+		TruncatedBodies:  s.TruncatedBodies,
+		ContentChanges:   s.ContentChanges,
+		Errors:           s.ErrorTotal(),
+		ErrorCounts:      s.ErrorCounts,
+	}
+
+	// Min and max hold sentinels until the first request.
+	if s.TotalRequests > 0 {
+		record.DurationMinMs = milliseconds(s.MinDuration)
+		record.DurationMaxMs = milliseconds(s.MaxDuration)
+		record.SizeMin = s.MinSize
+		record.SizeMax = s.MaxSize
+	}
+
+	if score, ok := s.Apdex(); ok {
+		record.Apdex = &score
+	}
+	if score, ok := row.recentCounts.Apdex(); ok {
+		record.Apdex1h = &score
+	}
+
+	if row.sloStatus != nil {
+		record.SLO = &sloRecord{
+			Availability:    row.sloStatus.Availability,
+			LatencyGood:     row.sloStatus.LatencyGood,
+			BudgetRemaining: row.sloStatus.BudgetRemaining,
+			BurnRate1h:      row.sloStatus.BurnRate1h,
+			BurnRate6h:      row.sloStatus.BurnRate6h,
+			Alert:           row.sloStatus.Alert,
+			Met:             row.sloStatus.Met(row.slo),
+		}
+	}
+
+	return record
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// changedRows returns the rows with new checks since the previous call,
+// or every row for the final frame, so streaming formats emit a record per
+// update instead of repeating every target.
+func changedRows(frame Frame, seen map[string]int64) []tableRow {
+	var rows []tableRow
+	for _, row := range frame.Rows {
+		count := row.snapshot.TotalRequests + row.snapshot.ExcludedFailures
+		if frame.Final || count != seen[row.url] {
+			rows = append(rows, row)
+		}
+		seen[row.url] = count
+	}
+	return rows
+}
+
+type ndjsonRenderer struct {
+	seen map[string]int64
+}
+
+func (r *ndjsonRenderer) Render(w io.Writer, frame Frame) error {
+	encoder := json.NewEncoder(w)
+	for _, row := range changedRows(frame, r.seen) {
+		if err := encoder.Encode(newTargetRecord(frame, row)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var csvHeader = append([]string{
+	"time", "final", "url", "state", "requests", "successes",
+	"duration_min_ms", "duration_avg_ms", "duration_max_ms",
+	"duration_p50_ms", "duration_p90_ms", "duration_p95_ms", "duration_p99_ms",
+	"duration_stddev_ms", "duration_cv", "jitter_ms",
+	"size_min", "size_avg", "size_max",
+	"excluded_failures", "late_checks", "truncated_bodies", "content_changes",
+	"apdex", "apdex_1h",
+	"errors",
+}, errorKindColumns()...)
+
+func errorKindColumns() []string {
+	var columns []string
+	for _, kind := range errorKinds {
+		columns = append(columns, "errors_"+kind)
+	}
+	return columns
+}
+
+type csvRenderer struct {
+	seen        map[string]int64
+	wroteHeader bool
+}
+
+func (r *csvRenderer) Render(w io.Writer, frame Frame) error {
+	cw := csv.NewWriter(w)
+	if !r.wroteHeader {
+		cw.Write(csvHeader)
+		r.wroteHeader = true
+	}
+
+	for _, row := range changedRows(frame, r.seen) {
+		cw.Write(newTargetRecord(frame, row).csvFields())
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func (r targetRecord) csvFields() []string {
+	ms := func(v float64) string { return strconv.FormatFloat(v, 'f', 3, 64) }
+	n := func(v int64) string { return strconv.FormatInt(v, 10) }
+	score := func(v *float64) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*v, 'f', 3, 64)
+	}
+
+	fields := []string{
+		r.Time.Format(time.RFC3339), strconv.FormatBool(r.Final), r.URL, r.State,
+		n(r.Requests), n(r.Successes),
+		ms(r.DurationMinMs), ms(r.DurationAvgMs), ms(r.DurationMaxMs),
+		ms(r.DurationP50Ms), ms(r.DurationP90Ms), ms(r.DurationP95Ms), ms(r.DurationP99Ms),
+		ms(r.DurationStdDevMs), strconv.FormatFloat(r.DurationCV, 'f', 3, 64), ms(r.JitterMs),
+		n(r.SizeMin), n(r.SizeAvg), n(r.SizeMax),
+		n(r.ExcludedFailures), n(r.LateChecks), n(r.TruncatedBodies), n(r.ContentChanges),
+		score(r.Apdex), score(r.Apdex1h),
+		n(r.Errors),
+	}
+	for _, kind := range errorKinds {
+		fields = append(fields, n(r.ErrorCounts[kind]))
+	}
+	return fields
+}
+
+// markdownRenderer writes the table columns as a Markdown table. Only the
+// final frame is written, since a new table per update is not useful.
+type markdownRenderer struct {
+	layout func() tableLayout
+}
+
+func (r *markdownRenderer) Render(w io.Writer, frame Frame) error {
+	if !frame.Final {
+		return nil
+	}
+
+	var b strings.Builder
+	writeMarkdownTable(&b, r.layout().visibleColumns(), frame.Rows)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeMarkdownTable(b *strings.Builder, columns []tableColumn, rows []tableRow) {
+	escape := strings.NewReplacer("|", `\|`, "\n", " ").Replace
+
+	b.WriteString("|")
+	for _, column := range columns {
+		b.WriteString(" " + column.header + " |")
+	}
+	b.WriteString("\n|")
+	for range columns {
+		b.WriteString(" --- |")
+	}
+	b.WriteString("\n")
+
+	for _, row := range rows {
+		b.WriteString("|")
+		for _, column := range columns {
+			b.WriteString(" " + escape(column.value(row)) + " |")
+		}
+		b.WriteString("\n")
+	}
+}
+
+// quietRenderer prints nothing while running and a one-line summary per
+// target at the end.
+type quietRenderer struct{}
+
+func (quietRenderer) Render(w io.Writer, frame Frame) error {
+	if !frame.Final {
+		return nil
+	}
+
+	for _, row := range frame.Rows {
+		s := row.snapshot
+		if _, err := fmt.Fprintf(w, "%s %s %d/%d ok avg %s\n",
+			row.url, row.state, s.SuccessCount, s.TotalRequests, formatDuration(s.AverageDuration())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var reportFormats = []string{"csv", "markdown", "html"}
+
+// reportFormat picks the report format from the explicit format, or from the
+// extension of path when format is empty.
+func reportFormat(path, format string) (string, error) {
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".csv":
+			format = "csv"
+		case ".md", ".markdown":
+			format = "markdown"
+		case ".html", ".htm":
+			format = "html"
+		default:
+			return "", fmt.Errorf("cannot tell report format from '%s', use -report-format", path)
+		}
+	}
+
+	for _, known := range reportFormats {
+		if format == known {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unknown report format '%s', expected one of %s", format, strings.Join(reportFormats, ", "))
+}
+
+// reportColumns are every table column followed by a count per error kind.
+func reportColumns() []tableColumn {
+	columns := append([]tableColumn(nil), tableColumns...)
+	for _, kind := range errorKinds {
+		columns = append(columns, tableColumn{
+			name:   "errors-" + kind,
+			header: "Errors " + strings.ReplaceAll(kind, "_", " "),
+			value: func(r tableRow) string {
+				return strconv.FormatInt(r.snapshot.ErrorCounts[kind], 10)
+			},
+		})
+	}
+	return columns
+}
+
+var incidentHeaders = []string{"URL", "Start", "End", "Duration", "Checks", "First error"}
+
+func incidentCells(record IncidentRecord, now time.Time) []string {
+	end := "ongoing"
+	if !record.Ongoing() {
+		end = record.End.Format(time.DateTime)
+	}
+	return []string{
+		record.URL,
+		record.Start.Format(time.DateTime),
+		end,
+		record.Duration(now).Round(time.Second).String(),
+		strconv.FormatInt(record.Checks, 10),
+		record.FirstError,
+	}
+}
+
+// WriteReport writes the final statistics to path as CSV, Markdown or HTML.
+func (m *Monitor) WriteReport(path, format string) error {
+	format, err := reportFormat(path, format)
+	if err != nil {
+		return err
+	}
+
+	frame := m.frame(true)
+
+	var b strings.Builder
+	switch format {
+	case "csv":
+		renderer := &csvRenderer{seen: make(map[string]int64)}
+		if err := renderer.Render(&b, frame); err != nil {
+			return err
+		}
+	case "markdown":
+		fmt.Fprintf(&b, "# Web Monitor Report\n\nGenerated %s\n\n", frame.Time.Format("2006-01-02 15:04:05 MST"))
+		writeMarkdownTable(&b, reportColumns(), frame.Rows)
+		if len(frame.Incidents) > 0 {
+			escape := strings.NewReplacer("|", `\|`, "\n", " ").Replace
+			b.WriteString("\n## Incidents\n\n| " + strings.Join(incidentHeaders, " | ") + " |\n|")
+			b.WriteString(strings.Repeat(" --- |", len(incidentHeaders)) + "\n")
+			for _, record := range frame.Incidents {
+				b.WriteString("|")
+				for _, cell := range incidentCells(record, frame.Time) {
+					b.WriteString(" " + escape(cell) + " |")
+				}
+				b.WriteString("\n")
+			}
+		}
+	case "html":
+		if err := writeHTMLReport(&b, frame); err != nil {
+			return err
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		return fmt.Errorf("writing report: %v", err)
+	}
+	return nil
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Web Monitor Report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; white-space: nowrap; }
+th { background: #f4f4f4; }
+tr.up td:first-child { border-left: 4px solid #2e7d32; }
+tr.degraded td:first-child { border-left: 4px solid #f9a825; }
+tr.down td:first-child { border-left: 4px solid #c62828; }
+</style>
+</head>
+<body>
+<h1>Web Monitor Report</h1>
+<p>Generated {{.Time}}</p>
+<table>
+<tr>{{range .Headers}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr class="{{.State}}">{{range .Cells}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>
+{{if .Incidents}}<h2>Incidents</h2>
+<table>
+<tr>{{range .IncidentHeaders}}<th>{{.}}</th>{{end}}</tr>
+{{range .Incidents}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
+
+func writeHTMLReport(b *strings.Builder, frame Frame) error {
+	type htmlRow struct {
+		State string
+		Cells []string
+	}
+
+	columns := reportColumns()
+	data := struct {
+		Time    string
+		Headers []string
+		Rows    []htmlRow
+
+		IncidentHeaders []string
+		Incidents       [][]string
+	}{Time: frame.Time.Format("2006-01-02 15:04:05 MST"), IncidentHeaders: incidentHeaders}
+
+	for _, record := range frame.Incidents {
+		data.Incidents = append(data.Incidents, incidentCells(record, frame.Time))
+	}
+
+	for _, column := range columns {
+		data.Headers = append(data.Headers, column.header)
+	}
+	for _, row := range frame.Rows {
+		r := htmlRow{State: row.state}
+		for _, column := range columns {
+			r.Cells = append(r.Cells, column.value(row))
+		}
+		data.Rows = append(data.Rows, r)
+	}
+
+	return htmlReportTemplate.Execute(b, data)
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRuleInterval = 30 * time.Second
+	maxRuleWindow       = 24 * time.Hour
+)
+
+// Rule states. A rule is pending while its condition holds for fewer
+// evaluations than it asks for, firing once it has held long enough and
+// resolved after a firing condition stops holding.
+const (
+	RuleInactive = "inactive"
+	RulePending  = "pending"
+	RuleFiring   = "firing"
+	RuleResolved = "resolved"
+)
+
+// AlertRule alerts when a condition over a target's statistics holds, for
+// example "p95 over 5m > 800ms for 3 evaluations" or
+// "success rate over 15m < 98%". Without "over" the condition applies to
+// the whole run. Targets limits the rule to the given target IDs or URLs.
+type AlertRule struct {
+	Name     string   `json:"name,omitempty"`
+	Expr     string   `json:"expr"`
+	Targets  []string `json:"targets,omitempty"`
+	Severity string   `json:"severity,omitempty"`
+
+	cond ruleCondition
+}
+
+func (r *AlertRule) validate() error {
+	cond, err := parseRuleExpr(r.Expr)
+	if err != nil {
+		return err
+	}
+	r.cond = cond
+	if r.Name == "" {
+		r.Name = r.Expr
+	}
+	if r.Severity == "" {
+		r.Severity = "warning"
+	}
+	return nil
+}
+
+func (r *AlertRule) appliesTo(target TargetConfig) bool {
+	return len(r.Targets) == 0 || slices.Contains(r.Targets, target.ID) || slices.Contains(r.Targets, target.URL)
+}
+
+// Kinds of rule metrics, which decide how thresholds are parsed and values
+// are shown.
+const (
+	metricLatency = "latency"
+	metricPercent = "percent"
+	metricCount   = "count"
+	metricSize    = "size"
+	metricScore   = "score"
+)
+
+type ruleMetric struct {
+	kind  string
+	value func(s *URLStats) float64
+}
+
+var ruleMetrics = map[string]ruleMetric{
+	"avg":          {metricLatency, func(s *URLStats) float64 { return float64(s.AverageDuration()) }},
+	"min":          {metricLatency, func(s *URLStats) float64 { return float64(s.MinDuration) }},
+	"max":          {metricLatency, func(s *URLStats) float64 { return float64(s.MaxDuration) }},
+	"stddev":       {metricLatency, func(s *URLStats) float64 { return float64(s.StdDev()) }},
+	"jitter":       {metricLatency, func(s *URLStats) float64 { return float64(s.Jitter()) }},
+	"success_rate": {metricPercent, func(s *URLStats) float64 { return percent(s.SuccessCount, s.TotalRequests) }},
+	"error_rate":   {metricPercent, func(s *URLStats) float64 { return 100 - percent(s.SuccessCount, s.TotalRequests) }},
+	"errors":       {metricCount, func(s *URLStats) float64 { return float64(s.ErrorTotal()) }},
+	"requests":     {metricCount, func(s *URLStats) float64 { return float64(s.TotalRequests) }},
+	"anomalies":    {metricCount, func(s *URLStats) float64 { return float64(s.Anomalies) }},
+	"size":         {metricSize, func(s *URLStats) float64 { return float64(s.AverageSize()) }},
+	"apdex": {metricScore, func(s *URLStats) float64 {
+		score, _ := s.Apdex()
+		return score
+	}},
+}
+
+var (
+	ruleExprPattern   = regexp.MustCompile(`^([a-z0-9_.]+)(?:\s+over\s+(\S+))?\s*(>=|<=|==|!=|>|<)\s*(\S+?)(?:\s+for\s+(\d+)\s+evaluations?)?$`)
+	percentilePattern = regexp.MustCompile(`^p(\d+(?:\.\d+)?)$`)
+)
+
+// ruleCondition is a parsed rule expression. Window is zero for conditions
+// over the whole run.
+type ruleCondition struct {
+	metric    string
+	window    time.Duration
+	op        string
+	threshold float64
+	times     int
+}
+
+func parseRuleExpr(expr string) (ruleCondition, error) {
+	normalized := strings.ToLower(strings.Join(strings.Fields(expr), " "))
+	normalized = strings.NewReplacer("success rate", "success_rate", "error rate", "error_rate").Replace(normalized)
+
+	match := ruleExprPattern.FindStringSubmatch(normalized)
+	if match == nil {
+		return ruleCondition{}, fmt.Errorf("invalid rule '%s', expected '<metric> [over <window>] <op> <value> [for <n> evaluations]'", expr)
+	}
+
+	cond := ruleCondition{metric: match[1], op: match[3], times: 1}
+	kind, ok := cond.kind()
+	if !ok {
+		return ruleCondition{}, fmt.Errorf("rule '%s': unknown metric '%s', expected pNN or one of %s", expr, cond.metric, strings.Join(ruleMetricNames(), ", "))
+	}
+
+	if match[2] != "" {
+		window, err := parseDuration(match[2])
+		if err != nil {
+			return ruleCondition{}, fmt.Errorf("rule '%s': %v", expr, err)
+		}
+		if window <= 0 || window > maxRuleWindow {
+			return ruleCondition{}, fmt.Errorf("rule '%s': window must be between 0 and %s, got %s", expr, maxRuleWindow, match[2])
+		}
+		cond.window = window
+	}
+
+	threshold, err := parseThreshold(kind, match[4])
+	if err != nil {
+		return ruleCondition{}, fmt.Errorf("rule '%s': %v", expr, err)
+	}
+	cond.threshold = threshold
+
+	if match[5] != "" {
+		cond.times, _ = strconv.Atoi(match[5])
+		if cond.times < 1 {
+			return ruleCondition{}, fmt.Errorf("rule '%s': must hold for at least 1 evaluation", expr)
+		}
+	}
+	return cond, nil
+}
+
+func parseThreshold(kind, value string) (float64, error) {
+	switch kind {
+	case metricLatency:
+		d, err := parseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("threshold must be a duration like 800ms, got '%s'", value)
+		}
+		return float64(d), nil
+	case metricPercent:
+		n, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || n < 0 || n > 100 {
+			return 0, fmt.Errorf("threshold must be a percentage like 98%%, got '%s'", value)
+		}
+		return n, nil
+	case metricScore:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil || n < 0 || n > 1 {
+			return 0, fmt.Errorf("threshold must be a score between 0 and 1, got '%s'", value)
+		}
+		return n, nil
+	case metricSize:
+		n, err := parseSize(value)
+		if err != nil {
+			return 0, fmt.Errorf("threshold must be a size like 10KB, got '%s'", value)
+		}
+		return float64(n), nil
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("threshold must be a number, got '%s'", value)
+	}
+	return n, nil
+}
+
+func ruleMetricNames() []string {
+	return slices.Sorted(maps.Keys(ruleMetrics))
+}
+
+func (c ruleCondition) kind() (string, bool) {
+	if match := percentilePattern.FindStringSubmatch(c.metric); match != nil {
+		p, _ := strconv.ParseFloat(match[1], 64)
+		return metricLatency, p > 0 && p <= 100
+	}
+	metric, ok := ruleMetrics[c.metric]
+	return metric.kind, ok
+}
+
+// value evaluates the metric over s. It returns false when s holds no
+// checks, since rates and latencies mean nothing then.
+func (c ruleCondition) value(s *URLStats) (float64, bool) {
+	if s.TotalRequests == 0 {
+		return 0, false
+	}
+	if match := percentilePattern.FindStringSubmatch(c.metric); match != nil {
+		p, _ := strconv.ParseFloat(match[1], 64)
+		return float64(s.Percentile(p)), true
+	}
+	return ruleMetrics[c.metric].value(s), true
+}
+
+func (c ruleCondition) holds(value float64) bool {
+	switch c.op {
+	case ">":
+		return value > c.threshold
+	case ">=":
+		return value >= c.threshold
+	case "<":
+		return value < c.threshold
+	case "<=":
+		return value <= c.threshold
+	case "==":
+		return value == c.threshold
+	}
+	return value != c.threshold
+}
+
+func (c ruleCondition) format(value float64) string {
+	kind, _ := c.kind()
+	switch kind {
+	case metricLatency:
+		return formatDuration(time.Duration(value))
+	case metricPercent:
+		return fmt.Sprintf("%.2f%%", value)
+	case metricSize:
+		return formatSize(int64(value))
+	case metricScore:
+		return formatApdex(value, true)
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// RuleStatus is the state of one rule for one target.
+type RuleStatus struct {
+	Rule     string `json:"rule"`
+	URL      string `json:"url"`
+	TargetID string `json:"target_id"`
+	State    string `json:"state"`
+	// Value is the metric at the last evaluation, empty without data.
+	Value      string    `json:"value,omitempty"`
+	Matches    int       `json:"matches"`
+	Since      time.Time `json:"since"`
+	FiredAt    time.Time `json:"fired_at,omitzero"`
+	ResolvedAt time.Time `json:"resolved_at,omitzero"`
+}
+
+func (s RuleStatus) String() string {
+	value := s.Value
+	if value == "" {
+		value = "no data"
+	}
+	return fmt.Sprintf("[%s] %s on %s: %s since %s", s.State, s.Rule, s.TargetID, value, s.Since.Format("15:04:05"))
+}
+
+func (m *Monitor) ruleLoop(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(m.ruleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			m.evaluateRules(now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// evaluateRules checks every rule against every target it applies to.
+// Like burn rates, rule states are left untouched while a target is
+// suppressed.
+func (m *Monitor) evaluateRules(now time.Time) {
+	for _, url := range m.urls {
+		if m.suppressed(url, now) {
+			continue
+		}
+
+		m.statsMu.RLock()
+		stat := m.stats[url]
+		target := m.targets[url]
+		m.statsMu.RUnlock()
+
+		for _, rule := range m.rules {
+			if !rule.appliesTo(target) {
+				continue
+			}
+
+			var snapshot URLStats
+			if rule.cond.window > 0 {
+				snapshot = stat.WindowSnapshot(now, rule.cond.window)
+			} else {
+				snapshot = stat.GetSnapshot()
+			}
+			value, ok := rule.cond.value(&snapshot)
+			holds := ok && rule.cond.holds(value)
+
+			formatted := ""
+			if ok {
+				formatted = rule.cond.format(value)
+			}
+			m.advanceRule(rule, target, now, holds, formatted)
+		}
+	}
+}
+
+// advanceRule moves the rule's state for target along its lifecycle. When
+// it starts or stops firing it records an alert and notifies.
+func (m *Monitor) advanceRule(rule *AlertRule, target TargetConfig, now time.Time, holds bool, value string) {
+	key := rule.Name + " " + target.URL
+
+	m.alertsMu.Lock()
+	status, ok := m.ruleStates[key]
+	if !ok {
+		status = &RuleStatus{Rule: rule.Name, URL: target.URL, TargetID: target.ID, State: RuleInactive, Since: now}
+		m.ruleStates[key] = status
+	}
+	status.Value = value
+
+	var alert *Alert
+	if holds {
+		status.Matches++
+		switch {
+		case status.State == RuleFiring:
+		case status.Matches >= rule.cond.times:
+			status.State, status.Since, status.FiredAt = RuleFiring, now, now
+			alert = &Alert{Time: now, URL: target.URL, Severity: rule.Severity,
+				Message: fmt.Sprintf("%s: value %s", rule.Name, value)}
+		case status.State != RulePending:
+			status.State, status.Since = RulePending, now
+		}
+	} else {
+		status.Matches = 0
+		switch status.State {
+		case RuleFiring:
+			status.State, status.Since, status.ResolvedAt = RuleResolved, now, now
+			if value == "" {
+				value = "no data"
+			}
+			alert = &Alert{Time: now, URL: target.URL, Severity: "resolved",
+				Message: fmt.Sprintf("%s no longer holds, value %s", rule.Name, value)}
+		case RulePending:
+			status.State, status.Since = RuleInactive, now
+		}
+	}
+	state, firedAt := status.State, status.FiredAt
+	m.alertsMu.Unlock()
+
+	if alert == nil {
+		return
+	}
+	m.recordAlert(*alert)
+
+	// Firing rules are notified and escalated like targets going down.
+	if state == RuleResolved {
+		event := m.stateEvent(target.URL, stateUp, "", now)
+		event.Rule, event.Incident = rule.Name, Incident{Start: firedAt, End: now}
+		m.closeEscalation(event)
+		return
+	}
+	event := m.stateEvent(target.URL, stateDown, "value "+value, now)
+	event.Rule, event.Incident = rule.Name, Incident{Start: now}
+	m.openEscalation(event)
+}
+
+// RuleStatuses returns the state of every rule for every target it has
+// been evaluated on, ordered by rule and URL.
+func (m *Monitor) RuleStatuses() []RuleStatus {
+	m.alertsMu.Lock()
+	defer m.alertsMu.Unlock()
+
+	statuses := []RuleStatus{}
+	for _, status := range m.ruleStates {
+		statuses = append(statuses, *status)
+	}
+	slices.SortFunc(statuses, func(a, b RuleStatus) int {
+		if c := strings.Compare(a.Rule, b.Rule); c != 0 {
+			return c
+		}
+		return strings.Compare(a.URL, b.URL)
+	})
+	return statuses
+}
+
+func (m *Monitor) handleListRules(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, m.RuleStatuses())
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// A check that starts more than lateThreshold after it was due is counted
+// as late.
+const lateThreshold = 500 * time.Millisecond
+
+// scheduler coordinates checks across all workers: it caps the number of
+// requests in flight and spaces out requests to the same host.
+type scheduler struct {
+	slots        chan struct{}
+	hostInterval time.Duration
+
+	mu       sync.Mutex
+	hostNext map[string]time.Time
+}
+
+// newScheduler returns a scheduler allowing maxInFlight concurrent checks
+// and hostRate checks per second to a single host. Zero disables the
+// respective limit.
+func newScheduler(maxInFlight int, hostRate float64) *scheduler {
+	s := &scheduler{hostNext: make(map[string]time.Time)}
+	if maxInFlight > 0 {
+		s.slots = make(chan struct{}, maxInFlight)
+	}
+	if hostRate > 0 {
+		s.hostInterval = time.Duration(float64(time.Second) / hostRate)
+	}
+	return s
+}
+
+// acquire blocks until a check against rawURL may start. The returned
+// function must be called once the check is done. The host's rate limit is
+// waited for before an in-flight slot is taken, so that checks of a
+// throttled host never hold slots that checks of other hosts could use.
+func (s *scheduler) acquire(ctx context.Context, rawURL string) (func(), error) {
+	if err := s.waitForHost(ctx, rawURL); err != nil {
+		return nil, err
+	}
+
+	if s.slots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+		return func() { <-s.slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// waitForHost reserves the next free slot for the host of rawURL and sleeps
+// until it arrives. The reservation is given back when ctx is done first,
+// unless a later one has been made since.
+func (s *scheduler) waitForHost(ctx context.Context, rawURL string) error {
+	if s.hostInterval == 0 {
+		return nil
+	}
+
+	host := rawURL
+	if parsed, err := url.Parse(rawURL); err == nil {
+		host = parsed.Host
+	}
+
+	s.mu.Lock()
+	now := time.Now()
+	start := now
+	previous := s.hostNext[host]
+	if previous.After(now) {
+		start = previous
+	}
+	reserved := start.Add(s.hostInterval)
+	s.hostNext[host] = reserved
+	s.mu.Unlock()
+
+	wait := start.Sub(now)
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		if s.hostNext[host].Equal(reserved) {
+			s.hostNext[host] = previous
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// jitter returns a random delay in [0, d) used to spread out the first
+// check of each worker.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return rand.N(d)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const defaultSLOWindow = 30 * 24 * time.Hour
+
+// SLO describes the objectives a target is expected to meet over Window.
+// Availability and LatencyTarget are percentages; a latency objective of
+// "p95 < 300ms" is LatencyTarget 95 with Latency 300ms.
+type SLO struct {
+	Availability  float64  `json:"availability,omitempty"`
+	Latency       Duration `json:"latency,omitempty"`
+	LatencyTarget float64  `json:"latency_target,omitempty"`
+	Window        Duration `json:"window,omitempty"`
+}
+
+func (s *SLO) validate() error {
+	if s.Availability == 0 && s.Latency.Duration == 0 {
+		return fmt.Errorf("at least one of availability or latency is required")
+	}
+	if s.Availability < 0 || s.Availability >= 100 {
+		return fmt.Errorf("availability must be between 0 and 100, got %v", s.Availability)
+	}
+	if s.Latency.Duration < 0 {
+		return fmt.Errorf("latency must be positive, got %v", s.Latency)
+	}
+	if s.LatencyTarget < 0 || s.LatencyTarget >= 100 {
+		return fmt.Errorf("latency_target must be between 0 and 100, got %v", s.LatencyTarget)
+	}
+	if s.Window.Duration < 0 {
+		return fmt.Errorf("window must be positive, got %v", s.Window)
+	}
+
+	if s.Latency.Duration > 0 && s.LatencyTarget == 0 {
+		s.LatencyTarget = 95
+	}
+	if s.Window.Duration == 0 {
+		s.Window.Duration = defaultSLOWindow
+	}
+	return nil
+}
+
+// Burn-rate alert severities, following the multi-window scheme from the
+// Google SRE workbook.
+const (
+	BurnNone   = ""
+	BurnTicket = "ticket"
+	BurnPage   = "page"
+)
+
+type burnRule struct {
+	long, short time.Duration
+	threshold   float64
+	severity    string
+}
+
+var burnRules = []burnRule{
+	{long: time.Hour, short: 5 * time.Minute, threshold: 14.4, severity: BurnPage},
+	{long: 6 * time.Hour, short: 30 * time.Minute, threshold: 6, severity: BurnPage},
+	{long: 24 * time.Hour, short: 2 * time.Hour, threshold: 3, severity: BurnTicket},
+	{long: 3 * 24 * time.Hour, short: 6 * time.Hour, threshold: 1, severity: BurnTicket},
+}
+
+type SLOStatus struct {
+	Availability    float64
+	LatencyGood     float64
+	Requests        int64
+	BudgetRemaining float64
+	BurnRate1h      float64
+	BurnRate6h      float64
+	Alert           string
+}
+
+func (s SLOStatus) Met(slo *SLO) bool {
+	if s.Requests == 0 {
+		return true
+	}
+	if slo.Availability > 0 && s.Availability < slo.Availability {
+		return false
+	}
+	if slo.Latency.Duration > 0 && s.LatencyGood < slo.LatencyTarget {
+		return false
+	}
+	return true
+}
+
+// burnRate returns how fast the error budget is being spent over the
+// counts in b: 1 means the budget will last exactly the SLO window.
+func (slo *SLO) burnRate(b windowBucket) float64 {
+	if b.Total == 0 {
+		return 0
+	}
+
+	var rate float64
+	if slo.Availability > 0 {
+		bad := float64(b.Total-b.Success) / float64(b.Total)
+		rate = max(rate, bad/(1-slo.Availability/100))
+	}
+	if slo.Latency.Duration > 0 {
+		slow := float64(b.Total-b.Fast) / float64(b.Total)
+		rate = max(rate, slow/(1-slo.LatencyTarget/100))
+	}
+	return rate
+}
+
+// budgetRemaining returns the fraction of the error budget left over the
+// counts in b. It goes negative once the budget is exhausted.
+func (slo *SLO) budgetRemaining(b windowBucket) float64 {
+	if b.Total == 0 {
+		return 1
+	}
+
+	remaining := 1.0
+	if slo.Availability > 0 {
+		allowed := float64(b.Total) * (1 - slo.Availability/100)
+		remaining = min(remaining, 1-float64(b.Total-b.Success)/allowed)
+	}
+	if slo.Latency.Duration > 0 {
+		allowed := float64(b.Total) * (1 - slo.LatencyTarget/100)
+		remaining = min(remaining, 1-float64(b.Total-b.Fast)/allowed)
+	}
+	return remaining
+}
+
+func percent(part, total int64) float64 {
+	if total == 0 {
+		return 100
+	}
+	return float64(part) / float64(total) * 100
+}
+
+// windowBucket holds request counts for one slot of a rollingWindow. Fast
+// counts requests that finished within the target's SLO latency threshold,
+// Satisfied and Tolerating those within its Apdex thresholds.
+type windowBucket struct {
+	slot       int64
+	Total      int64
+	Success    int64
+	Fast       int64
+	Satisfied  int64
+	Tolerating int64
+}
+
+// rollingWindow keeps per-slot counts for the most recent len(buckets)
+// slots of the given resolution, overwriting the oldest as time moves on.
+type rollingWindow struct {
+	resolution time.Duration
+	buckets    []windowBucket
+}
+
+func newRollingWindow(resolution, span time.Duration) *rollingWindow {
+	n := int(span / resolution)
+	if span%resolution != 0 {
+		n++
+	}
+	return &rollingWindow{
+		resolution: resolution,
+		buckets:    make([]windowBucket, n),
+	}
+}
+
+func (w *rollingWindow) span() time.Duration {
+	return w.resolution * time.Duration(len(w.buckets))
+}
+
+func (w *rollingWindow) bucket(at time.Time) *windowBucket {
+	slot := at.UnixNano() / int64(w.resolution)
+	b := &w.buckets[slot%int64(len(w.buckets))]
+	if b.slot != slot {
+		*b = windowBucket{slot: slot}
+	}
+	return b
+}
+
+// add adds the counts of one check to the slot containing at.
+func (w *rollingWindow) add(at time.Time, counts windowBucket) {
+	w.bucket(at).merge(counts)
+}
+
+func (b *windowBucket) merge(other windowBucket) {
+	b.Total += other.Total
+	b.Success += other.Success
+	b.Fast += other.Fast
+	b.Satisfied += other.Satisfied
+	b.Tolerating += other.Tolerating
+}
+
+// sum adds up every slot that overlaps the period (now-span, now].
+func (w *rollingWindow) sum(now time.Time, span time.Duration) windowBucket {
+	last := now.UnixNano() / int64(w.resolution)
+	first := (now.UnixNano() - int64(span)) / int64(w.resolution)
+
+	var total windowBucket
+	for _, b := range w.buckets {
+		if b.slot > first && b.slot <= last {
+			total.merge(b)
+		}
+	}
+	return total
+}
+
+// history returns the last n slots up to and including the one containing
+// now, oldest first. Slots without checks are returned empty.
+func (w *rollingWindow) history(now time.Time, n int) []windowBucket {
+	last := now.UnixNano() / int64(w.resolution)
+
+	history := make([]windowBucket, n)
+	for i := range history {
+		slot := last - int64(n-1-i)
+		history[i] = windowBucket{slot: slot}
+		if b := w.buckets[slot%int64(len(w.buckets))]; b.slot == slot {
+			history[i] = b
+		}
+	}
+	return history
+}
+
+// restore puts a bucket back into the window unless a newer slot already
+// occupies its place.
+func (w *rollingWindow) restore(b windowBucket) {
+	current := &w.buckets[b.slot%int64(len(w.buckets))]
+	if current.slot <= b.slot {
+		*current = b
+	}
+}
+
+// start returns the beginning of the bucket's slot.
+func (b windowBucket) start(resolution time.Duration) time.Time {
+	return time.Unix(0, b.slot*int64(resolution)).UTC()
+}
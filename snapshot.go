@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSnapshotBody  = 64 * 1024
+	defaultSnapshotFiles = 100
+	defaultSnapshotTotal = 50 * 1024 * 1024
+	snapshotSuffix       = ".http"
+)
+
+// SnapshotConfig saves the responses of failed checks to Dir: the status
+// line, the headers and the first MaxBody bytes of the body. The oldest
+// snapshots are removed once there are more than MaxFiles or they take up
+// more than MaxTotal bytes.
+type SnapshotConfig struct {
+	Dir      string `json:"dir"`
+	MaxBody  Size   `json:"max_body,omitempty"`
+	MaxFiles int    `json:"max_files,omitempty"`
+	MaxTotal Size   `json:"max_total,omitempty"`
+}
+
+func (c *SnapshotConfig) validate() error {
+	if c.Dir == "" {
+		return fmt.Errorf("dir is required")
+	}
+	if c.MaxBody < 0 || c.MaxFiles < 0 || c.MaxTotal < 0 {
+		return fmt.Errorf("max_body, max_files and max_total must not be negative")
+	}
+	if c.MaxBody == 0 {
+		c.MaxBody = defaultSnapshotBody
+	}
+	if c.MaxFiles == 0 {
+		c.MaxFiles = defaultSnapshotFiles
+	}
+	if c.MaxTotal == 0 {
+		c.MaxTotal = defaultSnapshotTotal
+	}
+	return nil
+}
+
+// snapshotStore writes response snapshots and keeps the directory within
+// its limits.
+type snapshotStore struct {
+	cfg SnapshotConfig
+	mu  sync.Mutex
+}
+
+// save writes a snapshot of resp, whose body starts with body, and returns
+// its path. Snapshot names start with the check time so that they sort
+// oldest first.
+func (s *snapshotStore) save(targetID string, result CheckResult, resp *http.Response, body *limitedBuffer) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.cfg.Dir, 0o755); err != nil {
+		return "", fmt.Errorf("creating snapshot directory: %v", err)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# %s %s\n", resp.Request.Method, resp.Request.URL)
+	fmt.Fprintf(&b, "# %s, %s: %s\n\n", result.Time.UTC().Format(time.RFC3339Nano), formatDuration(result.Duration), result.Error)
+	fmt.Fprintf(&b, "%s %s\r\n", resp.Proto, resp.Status)
+	resp.Header.Write(&b)
+	b.WriteString("\r\n")
+	b.Write(body.Bytes())
+	if body.truncated {
+		fmt.Fprintf(&b, "\n[body truncated at %s]\n", formatSize(int64(body.limit)))
+	}
+
+	name := fmt.Sprintf("%s-%s%s", result.Time.UTC().Format("20060102T150405.000000000Z"), targetID, snapshotSuffix)
+	path := filepath.Join(s.cfg.Dir, name)
+	if err := writeFileAtomic(path, b.Bytes()); err != nil {
+		return "", fmt.Errorf("writing snapshot: %v", err)
+	}
+	return path, s.rotate()
+}
+
+// rotate removes the oldest snapshots beyond the file count and size
+// limits. Other files in the directory are left alone.
+func (s *snapshotStore) rotate() error {
+	entries, err := os.ReadDir(s.cfg.Dir)
+	if err != nil {
+		return fmt.Errorf("rotating snapshots: %v", err)
+	}
+
+	type snapshotFile struct {
+		name string
+		size int64
+	}
+	var files []snapshotFile
+	var total int64
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), snapshotSuffix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, snapshotFile{entry.Name(), info.Size()})
+		total += info.Size()
+	}
+	slices.SortFunc(files, func(a, b snapshotFile) int { return strings.Compare(a.name, b.name) })
+
+	// The newest snapshot is always kept, even if it alone is over the
+	// size limit.
+	for len(files) > 1 && (len(files) > s.cfg.MaxFiles || total > int64(s.cfg.MaxTotal)) {
+		if err := os.Remove(filepath.Join(s.cfg.Dir, files[0].name)); err != nil {
+			return fmt.Errorf("rotating snapshots: %v", err)
+		}
+		total -= files[0].size
+		files = files[1:]
+	}
+	return nil
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"maps"
+	"math"
+	"net/http"
 	"sync"
 	"time"
 )
@@ -14,25 +17,255 @@ type URLStats struct {
 	MaxDuration   time.Duration
 	TotalDuration time.Duration
 
+	// DurationMean and DurationM2 are the running mean and sum of squared
+	// deviations of the durations in nanoseconds, kept with Welford's
+	// algorithm for StdDev. JitterTotal adds up the differences between
+	// consecutive durations, see Jitter.
+	DurationMean float64
+	DurationM2   float64
+	JitterTotal  time.Duration
+	LastDuration time.Duration
+
 	MinSize   int64
 	MaxSize   int64
 	TotalSize int64
 
+	// ExcludedFailures counts failed checks during maintenance windows,
+	// which are left out of every other figure.
+	ExcludedFailures int64
+
+	// LateChecks counts checks that started more than lateThreshold after
+	// they were due because of concurrency or per-host rate limits.
+	LateChecks        int64
+	MaxScheduleDelay  time.Duration
+	LastScheduleDelay time.Duration
+
+	// TruncatedBodies counts responses cut off at the target's body size
+	// limit. LastBodyHash is only set when body hashing is enabled.
+	TruncatedBodies int64
+	LastBodyHash    string
+
+	ContentHash    string
+	ContentChanges int64
+	changes        []ContentChange
+	lastContent    []byte
+	lastTruncated  bool
+
+	recent    *checkRing
+	incidents []Incident
+
+	// windowed keeps the checks of the last retain period for alert rules
+	// over windows, see WindowSnapshot.
+	windowed []windowSample
+	retain   time.Duration
+
+	// ApdexSatisfied and ApdexTolerating count checks within ApdexT and
+	// within four times ApdexT, see Apdex.
+	ApdexT          time.Duration
+	ApdexSatisfied  int64
+	ApdexTolerating int64
+
+	// Anomalies counts checks whose latency deviated from the learned
+	// baseline, see SetAnomaly.
+	Anomalies int64
+	anomalies []Anomaly
+	anomaly   *AnomalyConfig
+	baseline  *latencyBaseline
+
+	// ErrorCounts counts failed checks by ErrorKind.
+	ErrorCounts map[string]int64
+	latency     latencyHistogram
+
+	slo     *SLO
+	minutes *rollingWindow
+	hours   *rollingWindow
+	days    *rollingWindow
+
 	mu sync.RWMutex
 }
 
 func NewURLStats(url string) *URLStats {
 	return &URLStats{
-		URL: url,
+		URL:         url,
 		MinDuration: time.Duration(^uint64(0) >> 1),
 		MinSize:     ^int64(0) >> 1,
+		ApdexT:      defaultApdexT,
+		recent:      newCheckRing(defaultRecentChecks),
+		minutes:     newRollingWindow(time.Minute, 6*time.Hour),
+		hours:       newRollingWindow(time.Hour, defaultSLOWindow),
+		days:        newRollingWindow(24*time.Hour, statusPageDays*24*time.Hour),
 	}
 }
 
+// SetSLO attaches an objective to the stats. The hourly history is resized
+// to cover the SLO window and the longest burn-rate window.
+func (s *URLStats) SetSLO(slo *SLO) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.slo = slo
+	span := max(slo.Window.Duration, 3*24*time.Hour)
+	if span > s.hours.span() {
+		s.hours = newRollingWindow(time.Hour, span)
+	}
+}
+
+// SetRetention keeps the checks of the last span for WindowSnapshot.
+func (s *URLStats) SetRetention(span time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.retain = max(s.retain, span)
+}
+
+// Error kinds recorded with failed checks.
+const (
+	ErrorTimeout    = "timeout"
+	ErrorConnection = "connection"
+	ErrorHTTP4xx    = "http_4xx"
+	ErrorHTTP5xx    = "http_5xx"
+	ErrorBody       = "body"
+	ErrorOther      = "other"
+)
+
+var errorKinds = []string{ErrorTimeout, ErrorConnection, ErrorHTTP4xx, ErrorHTTP5xx, ErrorBody, ErrorOther}
+
+// CheckResult is the outcome of a single check.
+type CheckResult struct {
+	Time       time.Time
+	Duration   time.Duration
+	StatusCode int
+	BodySize   int64
+	BodyHash   string
+	Truncated  bool
+	Success    bool
+	Error      string
+	ErrorKind  string
+	// Snapshot is the path of the saved response of a failed check, see
+	// SnapshotConfig.
+	Snapshot string
+
+	// Phases are the httptrace events of the request. TraceID and SpanID
+	// are only set when the check was traced with a traceparent header.
+	Phases  []TracePhase
+	TraceID string
+	SpanID  string
+
+	// Method, Proto and the headers describe the request and response
+	// for HAR export. Proto and ResponseHeader are empty without a
+	// response.
+	Method         string
+	Proto          string
+	RequestHeader  http.Header
+	ResponseHeader http.Header
+
+	// Content and ContentHash are only set when change detection is
+	// enabled for the target.
+	Content     []byte
+	ContentHash string
+}
+
+func (s *URLStats) SLO() *SLO {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.slo
+}
+
 func (s *URLStats) Update(duration time.Duration, bodySize int64, success bool) {
+	s.updateAt(time.Now(), duration, bodySize, success)
+}
+
+func (s *URLStats) updateAt(at time.Time, duration time.Duration, bodySize int64, success bool) {
+	s.Record(CheckResult{Time: at, Duration: duration, BodySize: bodySize, Success: success})
+}
+
+// Record adds a check result to the statistics. It reports whether the
+// result changed the content of the target.
+func (s *URLStats) Record(result CheckResult) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	duration, bodySize, success := result.Duration, result.BodySize, result.Success
+
+	counts := windowBucket{Total: 1}
+	if success {
+		counts.Success = 1
+	}
+	if s.slo != nil && success && duration <= s.slo.Latency.Duration {
+		counts.Fast = 1
+	}
+	counts.Satisfied, counts.Tolerating = apdexCounts(success, duration, s.ApdexT)
+	s.ApdexSatisfied += counts.Satisfied
+	s.ApdexTolerating += counts.Tolerating
+	s.minutes.add(result.Time, counts)
+	s.hours.add(result.Time, counts)
+	s.days.add(result.Time, counts)
+	s.trackIncident(result)
+	anomaly := s.detectAnomaly(result)
+	if s.retain > 0 {
+		s.windowed = append(s.windowed, windowSample{
+			Time:      result.Time,
+			Duration:  duration,
+			BodySize:  bodySize,
+			Success:   success,
+			ErrorKind: result.ErrorKind,
+			Anomaly:   anomaly,
+		})
+		cutoff := result.Time.Add(-s.retain)
+		i := 0
+		for i < len(s.windowed) && !s.windowed[i].Time.After(cutoff) {
+			i++
+		}
+		s.windowed = s.windowed[i:]
+	}
+
+	if result.Truncated {
+		s.TruncatedBodies++
+	}
+	if !success {
+		kind := result.ErrorKind
+		if kind == "" {
+			kind = ErrorOther
+		}
+		if s.ErrorCounts == nil {
+			s.ErrorCounts = make(map[string]int64)
+		}
+		s.ErrorCounts[kind]++
+	}
+	s.latency.add(duration)
+	if result.BodyHash != "" {
+		s.LastBodyHash = result.BodyHash
+	}
+
+	// Keep the bodies out of the recent history, they are only needed for
+	// change detection.
+	summary := result
+	summary.Content = nil
+	s.recent.add(summary)
+
+	changed := false
+	if success && result.ContentHash != "" {
+		content, truncated := diffContent(result.Content)
+		if s.ContentHash != "" && s.ContentHash != result.ContentHash {
+			s.ContentChanges++
+			s.changes = append(s.changes, ContentChange{
+				Time:      result.Time,
+				OldHash:   s.ContentHash,
+				NewHash:   result.ContentHash,
+				Truncated: truncated || s.lastTruncated,
+				Previous:  s.lastContent,
+				Current:   content,
+			})
+			if len(s.changes) > maxContentChanges {
+				s.changes = s.changes[len(s.changes)-maxContentChanges:]
+			}
+			changed = true
+		}
+		s.ContentHash = result.ContentHash
+		s.lastContent, s.lastTruncated = content, truncated
+	}
+
 	s.TotalRequests++
 	if success {
 		s.SuccessCount++
@@ -46,6 +279,14 @@ func (s *URLStats) Update(duration time.Duration, bodySize int64, success bool)
 	}
 	s.TotalDuration += duration
 
+	delta := float64(duration) - s.DurationMean
+	s.DurationMean += delta / float64(s.TotalRequests)
+	s.DurationM2 += delta * (float64(duration) - s.DurationMean)
+	if s.TotalRequests > 1 {
+		s.JitterTotal += absDuration(duration - s.LastDuration)
+	}
+	s.LastDuration = duration
+
 	if s.TotalRequests == 1 || bodySize < s.MinSize {
 		s.MinSize = bodySize
 	}
@@ -53,6 +294,46 @@ func (s *URLStats) Update(duration time.Duration, bodySize int64, success bool)
 		s.MaxSize = bodySize
 	}
 	s.TotalSize += bodySize
+
+	return changed
+}
+
+// RecentChanges returns the most recent content changes, oldest first.
+func (s *URLStats) RecentChanges() []ContentChange {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]ContentChange(nil), s.changes...)
+}
+
+// DailyHistory returns the counts for each of the last n UTC days up to
+// and including today, oldest first.
+func (s *URLStats) DailyHistory(now time.Time, n int) []windowBucket {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.days.history(now, n)
+}
+
+// Exclude records a failed check that happened during maintenance.
+func (s *URLStats) Exclude() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ExcludedFailures++
+}
+
+func (s *URLStats) RecordScheduleDelay(delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.LastScheduleDelay = delay
+	if delay > s.MaxScheduleDelay {
+		s.MaxScheduleDelay = delay
+	}
+	if delay > lateThreshold {
+		s.LateChecks++
+	}
 }
 
 func (s *URLStats) GetSnapshot() URLStats {
@@ -69,6 +350,33 @@ func (s *URLStats) GetSnapshot() URLStats {
 		MinSize:       s.MinSize,
 		MaxSize:       s.MaxSize,
 		TotalSize:     s.TotalSize,
+
+		DurationMean: s.DurationMean,
+		DurationM2:   s.DurationM2,
+		JitterTotal:  s.JitterTotal,
+		LastDuration: s.LastDuration,
+
+		ExcludedFailures: s.ExcludedFailures,
+
+		LateChecks:        s.LateChecks,
+		MaxScheduleDelay:  s.MaxScheduleDelay,
+		LastScheduleDelay: s.LastScheduleDelay,
+
+		TruncatedBodies: s.TruncatedBodies,
+		LastBodyHash:    s.LastBodyHash,
+
+		ContentHash:    s.ContentHash,
+		ContentChanges: s.ContentChanges,
+
+		ApdexT:          s.ApdexT,
+		ApdexSatisfied:  s.ApdexSatisfied,
+		ApdexTolerating: s.ApdexTolerating,
+
+		Anomalies: s.Anomalies,
+
+		ErrorCounts: maps.Clone(s.ErrorCounts),
+		latency:     s.latency,
+		recent:      s.recent.resized(len(s.recent.checks)),
 	}
 }
 
@@ -79,9 +387,209 @@ func (s *URLStats) AverageDuration() time.Duration {
 	return s.TotalDuration / time.Duration(s.TotalRequests)
 }
 
+// Percentile estimates the p-th percentile response time, see
+// latencyHistogram.
+func (s *URLStats) Percentile(p float64) time.Duration {
+	return s.latency.Percentile(p)
+}
+
+// StdDev is the sample standard deviation of the durations.
+func (s *URLStats) StdDev() time.Duration {
+	if s.TotalRequests < 2 {
+		return 0
+	}
+	return time.Duration(math.Sqrt(s.DurationM2 / float64(s.TotalRequests-1)))
+}
+
+// CoefficientOfVariation is the standard deviation relative to the mean
+// duration, which compares how erratic fast and slow targets are.
+func (s *URLStats) CoefficientOfVariation() float64 {
+	if s.DurationMean == 0 {
+		return 0
+	}
+	return float64(s.StdDev()) / s.DurationMean
+}
+
+// Jitter is the mean absolute difference between the durations of
+// consecutive checks.
+func (s *URLStats) Jitter() time.Duration {
+	if s.TotalRequests < 2 {
+		return 0
+	}
+	return s.JitterTotal / time.Duration(s.TotalRequests-1)
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+func (s *URLStats) ErrorTotal() int64 {
+	return s.TotalRequests - s.SuccessCount
+}
+
 func (s *URLStats) AverageSize() int64 {
 	if s.TotalRequests == 0 {
 		return 0
 	}
 	return s.TotalSize / s.TotalRequests
 }
+
+// window returns the counts for the period ending at now, using the finest
+// history that still covers span.
+func (s *URLStats) window(now time.Time, span time.Duration) windowBucket {
+	switch {
+	case span <= s.minutes.span():
+		return s.minutes.sum(now, span)
+	case span <= s.hours.span():
+		return s.hours.sum(now, span)
+	}
+	return s.days.sum(now, span)
+}
+
+// Window returns the check counts for the period of length span ending at
+// now, at most statusPageDays long.
+func (s *URLStats) Window(now time.Time, span time.Duration) windowBucket {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.window(now, span)
+}
+
+// windowSample is the part of a check kept for WindowSnapshot.
+type windowSample struct {
+	Time      time.Time
+	Duration  time.Duration
+	BodySize  int64
+	Success   bool
+	ErrorKind string
+	Anomaly   bool
+}
+
+// WindowSnapshot summarises the checks of the period of length span ending
+// at now as if they were the only ones recorded. It covers at most the
+// period set with SetRetention.
+func (s *URLStats) WindowSnapshot(now time.Time, span time.Duration) URLStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var (
+		total, successes            int64
+		satisfied, tolerating       int64
+		anomalies                   int64
+		mean, m2                    float64
+		jitter, last                time.Duration
+		errorCounts                 map[string]int64
+		latency                     latencyHistogram
+		minDuration, maxDuration    time.Duration
+		totalDuration               time.Duration
+		minSize, maxSize, totalSize int64
+	)
+	cutoff := now.Add(-span)
+	for _, sample := range s.windowed {
+		if !sample.Time.After(cutoff) || sample.Time.After(now) {
+			continue
+		}
+
+		total++
+		if sample.Success {
+			successes++
+		} else {
+			kind := sample.ErrorKind
+			if kind == "" {
+				kind = ErrorOther
+			}
+			if errorCounts == nil {
+				errorCounts = make(map[string]int64)
+			}
+			errorCounts[kind]++
+		}
+		latency.add(sample.Duration)
+		sat, tol := apdexCounts(sample.Success, sample.Duration, s.ApdexT)
+		satisfied += sat
+		tolerating += tol
+		if sample.Anomaly {
+			anomalies++
+		}
+
+		if total == 1 || sample.Duration < minDuration {
+			minDuration = sample.Duration
+		}
+		maxDuration = max(maxDuration, sample.Duration)
+		totalDuration += sample.Duration
+		delta := float64(sample.Duration) - mean
+		mean += delta / float64(total)
+		m2 += delta * (float64(sample.Duration) - mean)
+		if total > 1 {
+			jitter += absDuration(sample.Duration - last)
+		}
+		last = sample.Duration
+
+		if total == 1 || sample.BodySize < minSize {
+			minSize = sample.BodySize
+		}
+		maxSize = max(maxSize, sample.BodySize)
+		totalSize += sample.BodySize
+	}
+
+	return URLStats{
+		URL:           s.URL,
+		TotalRequests: total,
+		SuccessCount:  successes,
+		MinDuration:   minDuration,
+		MaxDuration:   maxDuration,
+		TotalDuration: totalDuration,
+		MinSize:       minSize,
+		MaxSize:       maxSize,
+		TotalSize:     totalSize,
+		ErrorCounts:   errorCounts,
+		latency:       latency,
+
+		DurationMean: mean,
+		DurationM2:   m2,
+		JitterTotal:  jitter,
+		LastDuration: last,
+
+		ApdexT:          s.ApdexT,
+		ApdexSatisfied:  satisfied,
+		ApdexTolerating: tolerating,
+
+		Anomalies: anomalies,
+	}
+}
+
+// SLOStatus evaluates the attached SLO at now. The second return value is
+// false when the target has no SLO.
+func (s *URLStats) SLOStatus(now time.Time) (SLOStatus, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.slo == nil {
+		return SLOStatus{}, false
+	}
+
+	counts := s.window(now, s.slo.Window.Duration)
+	status := SLOStatus{
+		Availability:    percent(counts.Success, counts.Total),
+		LatencyGood:     percent(counts.Fast, counts.Total),
+		Requests:        counts.Total,
+		BudgetRemaining: s.slo.budgetRemaining(counts),
+		BurnRate1h:      s.slo.burnRate(s.window(now, time.Hour)),
+		BurnRate6h:      s.slo.burnRate(s.window(now, 6*time.Hour)),
+	}
+
+	for _, rule := range burnRules {
+		if status.Alert == BurnPage {
+			break
+		}
+		long := s.slo.burnRate(s.window(now, rule.long))
+		short := s.slo.burnRate(s.window(now, rule.short))
+		if long > rule.threshold && short > rule.threshold {
+			status.Alert = rule.severity
+		}
+	}
+
+	return status, true
+}
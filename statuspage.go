@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statusPageDays is how far back the uptime bars reach.
+const statusPageDays = 90
+
+const (
+	defaultStatusInterval = time.Minute
+	statusPageFile        = "index.html"
+	statusHistoryFile     = "history.json"
+	statusPageIncidents   = 10
+)
+
+// StatusPage periodically writes a self-contained HTML status page to Dir,
+// together with the daily history it was built from so that the uptime bars
+// survive restarts.
+type StatusPage struct {
+	Dir      string   `json:"dir"`
+	Interval Duration `json:"interval,omitempty"`
+	Title    string   `json:"title,omitempty"`
+}
+
+func (p *StatusPage) validate() error {
+	if p.Dir == "" {
+		return fmt.Errorf("dir is required")
+	}
+	if p.Interval.Duration == 0 {
+		p.Interval.Duration = defaultStatusInterval
+	}
+	if p.Interval.Duration < time.Second {
+		return fmt.Errorf("interval must be at least 1s, got %s", p.Interval)
+	}
+	if p.Title == "" {
+		p.Title = "Service Status"
+	}
+	return nil
+}
+
+// statusHistory is the content of history.json.
+type statusHistory struct {
+	Saved   time.Time                `json:"saved,omitzero"`
+	Targets map[string]targetHistory `json:"targets"`
+}
+
+type targetHistory struct {
+	Days      []dayHistory `json:"days"`
+	Incidents []Incident   `json:"incidents,omitempty"`
+}
+
+type dayHistory struct {
+	Date    string `json:"date"`
+	Total   int64  `json:"total"`
+	Success int64  `json:"success"`
+}
+
+// loadStatusHistory restores the daily counts and incidents saved by a
+// previous run. A missing history file is not an error.
+func (m *Monitor) loadStatusHistory() error {
+	path := filepath.Join(m.statusPage.Dir, statusHistoryFile)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading status history: %v", err)
+	}
+
+	var history statusHistory
+	if err := json.Unmarshal(data, &history); err != nil {
+		return fmt.Errorf("parsing status history: %v", err)
+	}
+	if history.Saved.IsZero() {
+		// Files written before the save time was recorded.
+		if info, err := os.Stat(path); err == nil {
+			history.Saved = info.ModTime()
+		}
+	}
+
+	m.statsMu.RLock()
+	defer m.statsMu.RUnlock()
+
+	for url, target := range history.Targets {
+		stat, ok := m.stats[url]
+		if !ok {
+			continue
+		}
+
+		stat.mu.Lock()
+		for _, day := range target.Days {
+			date, err := time.Parse(time.DateOnly, day.Date)
+			if err != nil {
+				continue
+			}
+			slot := date.UnixNano() / int64(stat.days.resolution)
+			stat.days.restore(windowBucket{slot: slot, Total: day.Total, Success: day.Success})
+		}
+		stat.restoreIncidents(target.Incidents, history.Saved)
+		stat.mu.Unlock()
+	}
+	return nil
+}
+
+func (m *Monitor) statusPageLoop(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(m.statusPage.Interval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.WriteStatusPage(time.Now()); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
+		case <-ctx.Done():
+			// Write once more so the page reflects the final state.
+			if err := m.WriteStatusPage(time.Now()); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
+			return
+		}
+	}
+}
+
+// WriteStatusPage writes index.html and history.json to the status page
+// directory. Both files are replaced atomically so a web server never serves
+// a partial page.
+func (m *Monitor) WriteStatusPage(now time.Time) error {
+	dir := m.statusPage.Dir
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("creating status page directory: %v", err)
+	}
+
+	rows := m.tableRows()
+	history := statusHistory{Saved: now, Targets: make(map[string]targetHistory)}
+	data := statusPageData{
+		Title:   m.statusPage.Title,
+		Updated: now.UTC().Format("2006-01-02 15:04 MST"),
+		Days:    statusPageDays,
+	}
+
+	down := 0
+	for _, row := range rows {
+		m.statsMu.RLock()
+		stat := m.stats[row.url]
+		m.statsMu.RUnlock()
+
+		days := stat.DailyHistory(now, statusPageDays)
+		incidents := stat.Incidents()
+
+		target := statusPageTarget{URL: row.url, State: row.state}
+		var saved []dayHistory
+		var total, success int64
+		for _, day := range days {
+			date := day.start(stat.days.resolution).Format(time.DateOnly)
+			bar := statusPageBar{Class: "none", Title: date + ": no data"}
+			if day.Total > 0 {
+				uptime := percent(day.Success, day.Total)
+				bar = statusPageBar{Class: uptimeClass(uptime), Title: fmt.Sprintf("%s: %.2f%% uptime", date, uptime)}
+				saved = append(saved, dayHistory{Date: date, Total: day.Total, Success: day.Success})
+			}
+			target.Bars = append(target.Bars, bar)
+			total += day.Total
+			success += day.Success
+		}
+		target.Uptime = "-"
+		if total > 0 {
+			target.Uptime = fmt.Sprintf("%.2f%%", percent(success, total))
+		}
+		data.Targets = append(data.Targets, target)
+		history.Targets[row.url] = targetHistory{Days: saved, Incidents: incidents}
+
+		for _, incident := range incidents {
+			data.Incidents = append(data.Incidents, newStatusPageIncident(row.url, incident, now))
+		}
+		if row.state == stateDown {
+			down++
+		}
+	}
+
+	sort.SliceStable(data.Incidents, func(i, j int) bool {
+		return data.Incidents[i].start.After(data.Incidents[j].start)
+	})
+	data.Incidents = data.Incidents[:min(len(data.Incidents), statusPageIncidents)]
+
+	switch {
+	case down == 0:
+		data.Summary, data.SummaryClass = "All systems operational", stateUp
+	case down < len(rows):
+		data.Summary, data.SummaryClass = "Partial outage", stateDegraded
+	default:
+		data.Summary, data.SummaryClass = "Major outage", stateDown
+	}
+
+	var page strings.Builder
+	if err := statusPageTemplate.Execute(&page, data); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, statusPageFile), []byte(page.String())); err != nil {
+		return fmt.Errorf("writing status page: %v", err)
+	}
+
+	encoded, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, statusHistoryFile), encoded); err != nil {
+		return fmt.Errorf("writing status history: %v", err)
+	}
+	return nil
+}
+
+// uptimeClass picks the colour of a day's uptime bar.
+func uptimeClass(uptime float64) string {
+	switch {
+	case uptime >= 99.9:
+		return stateUp
+	case uptime >= 99:
+		return "minor"
+	case uptime >= 95:
+		return stateDegraded
+	}
+	return stateDown
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+type statusPageData struct {
+	Title        string
+	Updated      string
+	Summary      string
+	SummaryClass string
+	Days         int
+	Targets      []statusPageTarget
+	Incidents    []statusPageIncident
+}
+
+type statusPageTarget struct {
+	URL    string
+	State  string
+	Uptime string
+	Bars   []statusPageBar
+}
+
+type statusPageBar struct {
+	Class string
+	Title string
+}
+
+type statusPageIncident struct {
+	URL      string
+	Start    string
+	Duration string
+	Error    string
+	Ongoing  bool
+	start    time.Time
+}
+
+func newStatusPageIncident(url string, incident Incident, now time.Time) statusPageIncident {
+	return statusPageIncident{
+		URL:      url,
+		Start:    incident.Start.UTC().Format("2006-01-02 15:04 MST"),
+		Duration: incident.Duration(now).Round(time.Second).String(),
+		Error:    incident.FirstError,
+		Ongoing:  incident.Ongoing(),
+		start:    incident.Start,
+	}
+}
+
+var statusPageTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; max-width: 56em; margin: 2em auto; padding: 0 1em; color: #222; }
+.summary { padding: 1em; border-radius: 4px; color: #fff; font-weight: bold; }
+.summary.up { background: #2e7d32; }
+.summary.degraded { background: #f9a825; }
+.summary.down { background: #c62828; }
+.target { margin: 1.5em 0; }
+.target header { display: flex; justify-content: space-between; }
+.state { font-weight: bold; text-transform: capitalize; }
+.state.up { color: #2e7d32; }
+.state.degraded { color: #f9a825; }
+.state.down { color: #c62828; }
+.state.pending { color: #888; }
+.bars { display: flex; gap: 1px; height: 2em; margin: 0.4em 0; }
+.bars span { flex: 1; border-radius: 1px; }
+.bars .up { background: #2e7d32; }
+.bars .minor { background: #9ccc65; }
+.bars .degraded { background: #f9a825; }
+.bars .down { background: #c62828; }
+.bars .none { background: #ddd; }
+.legend { display: flex; justify-content: space-between; color: #888; font-size: 0.8em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #eee; padding: 4px 8px; text-align: left; }
+.ongoing { color: #c62828; font-weight: bold; }
+footer { color: #888; font-size: 0.8em; margin-top: 2em; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<div class="summary {{.SummaryClass}}">{{.Summary}}</div>
+{{range .Targets}}<section class="target">
+<header><span>{{.URL}}</span><span class="state {{.State}}">{{.State}}</span></header>
+<div class="bars">{{range .Bars}}<span class="{{.Class}}" title="{{.Title}}"></span>{{end}}</div>
+<div class="legend"><span>{{$.Days}} days ago</span><span>{{.Uptime}} uptime</span><span>Today</span></div>
+</section>
+{{end}}<h2>Recent incidents</h2>
+{{if .Incidents}}<table>
+<tr><th>Target</th><th>Started</th><th>Duration</th><th>Error</th></tr>
+{{range .Incidents}}<tr><td>{{.URL}}</td><td>{{.Start}}</td><td>{{if .Ongoing}}<span class="ongoing">ongoing</span> {{end}}{{.Duration}}</td><td>{{.Error}}</td></tr>
+{{end}}</table>
+{{else}}<p>No incidents reported.</p>
+{{end}}<footer>Last updated {{.Updated}}</footer>
+</body>
+</html>
+`))
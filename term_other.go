@@ -0,0 +1,19 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+
+package main
+
+import "errors"
+
+// The interactive display is only supported on Linux, macOS and the BSDs;
+// elsewhere the plain table is used.
+func isTerminal(fd int) bool {
+	return false
+}
+
+func enableKeyInput(fd int) (restore func(), err error) {
+	return nil, errors.New("interactive mode is not supported on this platform")
+}
+
+func terminalColumns(fd int) int {
+	return 0
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Request phases recorded with httptrace, in the order they usually occur.
+const (
+	PhaseDNSStart     = "dns_start"
+	PhaseDNSDone      = "dns_done"
+	PhaseConnectStart = "connect_start"
+	PhaseConnectDone  = "connect_done"
+	PhaseTLSStart     = "tls_start"
+	PhaseTLSDone      = "tls_done"
+	PhaseWroteRequest = "wrote_request"
+	PhaseFirstByte    = "first_byte"
+)
+
+// TracePhase marks the moment a request reached a phase.
+type TracePhase struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+}
+
+// phaseRecorder collects phases and the request headers as written from
+// httptrace callbacks, which may run on other goroutines.
+type phaseRecorder struct {
+	mu      sync.Mutex
+	phases  []TracePhase
+	headers http.Header
+}
+
+func (r *phaseRecorder) add(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.phases = append(r.phases, TracePhase{Name: name, Time: time.Now()})
+}
+
+func (r *phaseRecorder) list() []TracePhase {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]TracePhase(nil), r.phases...)
+}
+
+// resetHeaders forgets the headers of an earlier hop when the client gets
+// a connection for the next request, so that only the final request's
+// headers are kept after redirects.
+func (r *phaseRecorder) resetHeaders() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.headers = nil
+}
+
+func (r *phaseRecorder) addHeader(key string, values []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.headers == nil {
+		r.headers = make(http.Header)
+	}
+	r.headers[key] = append(r.headers[key], values...)
+}
+
+// requestHeaders returns the headers written for the final request,
+// including those the transport adds such as Host and User-Agent.
+func (r *phaseRecorder) requestHeaders() http.Header {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.headers.Clone()
+}
+
+func (r *phaseRecorder) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn:              func(string) { r.resetHeaders() },
+		DNSStart:             func(httptrace.DNSStartInfo) { r.add(PhaseDNSStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { r.add(PhaseDNSDone) },
+		ConnectStart:         func(string, string) { r.add(PhaseConnectStart) },
+		ConnectDone:          func(string, string, error) { r.add(PhaseConnectDone) },
+		TLSHandshakeStart:    func() { r.add(PhaseTLSStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { r.add(PhaseTLSDone) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { r.add(PhaseWroteRequest) },
+		GotFirstResponseByte: func() { r.add(PhaseFirstByte) },
+		WroteHeaderField:     r.addHeader,
+	}
+}
+
+// newTraceIDs returns a random W3C trace ID and span ID in hex.
+func newTraceIDs() (traceID, spanID string) {
+	var b [24]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:16]), hex.EncodeToString(b[16:])
+}
+
+// traceparent formats a W3C Trace Context header for a sampled span.
+func traceparent(traceID, spanID string) string {
+	return "00-" + traceID + "-" + spanID + "-01"
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var tuiStates = []string{"all", stateUp, stateDegraded, stateDown, "alerting"}
+
+// tui is the interactive display used when stdout is a terminal. All state
+// is only touched from the display goroutine.
+type tui struct {
+	m   *Monitor
+	out io.Writer
+
+	sortColumn int
+	sortDesc   bool
+	filter     string
+	editing    bool
+	state      int
+	selected   int
+	detail     bool
+	paused     bool
+}
+
+func (m *Monitor) tuiLoop(ctx context.Context, wg *sync.WaitGroup) {
+	restore, err := enableKeyInput(int(os.Stdin.Fd()))
+	if err != nil {
+		m.displayLoop(ctx, wg)
+		return
+	}
+	defer wg.Done()
+	defer restore()
+
+	keys := make(chan string, 16)
+	go readKeys(os.Stdin, keys)
+
+	t := &tui{m: m, out: os.Stdout}
+	fmt.Fprint(t.out, "\033[?25l")
+	defer fmt.Fprint(t.out, "\033[?25h")
+
+	t.draw()
+	for {
+		select {
+		case <-m.updatedData:
+			if !t.paused {
+				t.draw()
+			}
+		case key := <-keys:
+			t.handleKey(key)
+			t.draw()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// readKeys turns raw terminal input into key names: single characters,
+// "up", "down", "enter", "esc" and "backspace".
+func readKeys(r io.Reader, keys chan<- string) {
+	buf := make([]byte, 64)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return
+		}
+
+		input := string(buf[:n])
+		for input != "" {
+			switch {
+			case strings.HasPrefix(input, "\033[A"):
+				keys <- "up"
+				input = input[3:]
+			case strings.HasPrefix(input, "\033[B"):
+				keys <- "down"
+				input = input[3:]
+			case strings.HasPrefix(input, "\033["):
+				// Ignore other escape sequences such as left/right.
+				input = input[min(3, len(input)):]
+			case input[0] == '\033':
+				keys <- "esc"
+				input = input[1:]
+			case input[0] == '\r' || input[0] == '\n':
+				keys <- "enter"
+				input = input[1:]
+			case input[0] == 127 || input[0] == 8:
+				keys <- "backspace"
+				input = input[1:]
+			default:
+				keys <- input[:1]
+				input = input[1:]
+			}
+		}
+	}
+}
+
+func (t *tui) handleKey(key string) {
+	if t.editing {
+		switch key {
+		case "enter":
+			t.editing = false
+		case "esc":
+			t.editing = false
+			t.filter = ""
+		case "backspace":
+			if t.filter != "" {
+				t.filter = t.filter[:len(t.filter)-1]
+			}
+		case "up", "down":
+		default:
+			t.filter += key
+		}
+		t.selected = 0
+		return
+	}
+
+	switch key {
+	case "1", "2", "3", "4", "5", "6", "7", "8", "9":
+		column := int(key[0] - '1')
+		if column >= len(t.m.tableLayout().visibleColumns()) {
+			return
+		}
+		if column == t.sortColumn {
+			t.sortDesc = !t.sortDesc
+		} else {
+			t.sortColumn, t.sortDesc = column, false
+		}
+	case "/":
+		t.editing = true
+	case "f":
+		t.state = (t.state + 1) % len(tuiStates)
+		t.selected = 0
+	case "up", "k":
+		t.selected = max(0, t.selected-1)
+	case "down", "j":
+		t.selected++
+	case "enter":
+		t.detail = !t.detail
+	case "esc":
+		if t.detail {
+			t.detail = false
+		} else {
+			t.filter = ""
+		}
+	case "p", " ":
+		t.paused = !t.paused
+	}
+}
+
+func (t *tui) rows() []tableRow {
+	rows := t.m.tableRows()
+
+	filtered := rows[:0]
+	for _, row := range rows {
+		if t.filter != "" && !strings.Contains(strings.ToLower(row.url), strings.ToLower(t.filter)) {
+			continue
+		}
+		switch tuiStates[t.state] {
+		case stateUp, stateDegraded, stateDown:
+			if row.state != tuiStates[t.state] {
+				continue
+			}
+		case "alerting":
+			if !row.alerting {
+				continue
+			}
+		}
+		filtered = append(filtered, row)
+	}
+
+	less := t.sortBy().less
+	sort.SliceStable(filtered, func(i, j int) bool {
+		if t.sortDesc {
+			return less(filtered[j], filtered[i])
+		}
+		return less(filtered[i], filtered[j])
+	})
+
+	return filtered
+}
+
+func (t *tui) sortBy() tableColumn {
+	columns := t.m.tableLayout().visibleColumns()
+	return columns[min(t.sortColumn, len(columns)-1)]
+}
+
+func (t *tui) draw() {
+	var b strings.Builder
+	b.WriteString("\033[2J\033[H")
+	t.render(&b)
+	io.WriteString(t.out, b.String())
+}
+
+func (t *tui) render(b *strings.Builder) {
+	rows := t.rows()
+	if t.selected >= len(rows) {
+		t.selected = max(0, len(rows)-1)
+	}
+
+	direction := "▲"
+	if t.sortDesc {
+		direction = "▼"
+	}
+	fmt.Fprintf(b, "Web Monitor  sort: %s %s  state: %s", t.sortBy().header, direction, tuiStates[t.state])
+	if t.filter != "" || t.editing {
+		fmt.Fprintf(b, "  filter: %s", t.filter)
+		if t.editing {
+			b.WriteString("_")
+		}
+	}
+	if t.paused {
+		b.WriteString("  [PAUSED]")
+	}
+	b.WriteString("\n\n")
+
+	layout := t.m.tableLayout()
+	layout.render(b, rows, func(i int) string {
+		if i >= 0 && i == t.selected {
+			return "> "
+		}
+		return "  "
+	})
+	if len(rows) == 0 {
+		b.WriteString("  no targets match\n")
+	}
+
+	if t.detail && len(rows) > 0 {
+		renderDetail(b, rows[t.selected], layout.color)
+	}
+
+	if alerts := t.m.recentAlerts(); len(alerts) > 0 {
+		fmt.Fprintf(b, "\nLast alert: %s\n", alerts[len(alerts)-1])
+	}
+
+	b.WriteString("\n1-9 sort  / filter  f state  ↑↓ select  enter details  p pause  ctrl+c quit\n")
+}
+
+func renderDetail(b *strings.Builder, row tableRow, color bool) {
+	title := fmt.Sprintf("%s (%s)", row.url, row.state)
+	if color {
+		title = colorize(row.state, title)
+	}
+	fmt.Fprintf(b, "\n%s\n", title)
+
+	var durations []time.Duration
+	var errors []CheckResult
+	for i, check := range row.recent {
+		if i >= len(row.recent)-sparklineWidth {
+			durations = append(durations, check.Duration)
+		}
+		if check.Error != "" {
+			errors = append(errors, check)
+		}
+	}
+	fmt.Fprintf(b, "Latency: %s\n", sparkline(durations))
+
+	start := max(0, len(row.recent)-10)
+	fmt.Fprintf(b, "\nRecent checks (last %d of %d kept):\n", len(row.recent)-start, len(row.recent))
+	for i := len(row.recent) - 1; i >= start; i-- {
+		check := row.recent[i]
+		status := "-"
+		if check.StatusCode != 0 {
+			status = fmt.Sprint(check.StatusCode)
+		}
+		fmt.Fprintf(b, "  %s  %-4s %-8s %-8s %s\n",
+			check.Time.Format("15:04:05"), status,
+			formatDuration(check.Duration), formatSize(check.BodySize), check.Error)
+	}
+	if len(row.recent) == 0 {
+		b.WriteString("  none yet\n")
+	}
+
+	if len(errors) > 0 {
+		b.WriteString("\nRecent errors:\n")
+		for i := len(errors) - 1; i >= max(0, len(errors)-5); i-- {
+			fmt.Fprintf(b, "  %s  %s\n", errors[i].Time.Format("15:04:05"), errors[i].Error)
+			if errors[i].Snapshot != "" {
+				fmt.Fprintf(b, "            response saved to %s\n", errors[i].Snapshot)
+			}
+		}
+	}
+}
+
+// sparklineWidth is how many of the latest checks the drill-down sparkline
+// shows, however many are kept.
+const sparklineWidth = 30
+
+// sparkline draws the values as a row of block characters scaled between
+// the smallest and largest value.
+func sparkline(values []time.Duration) string {
+	if len(values) == 0 {
+		return "-"
+	}
+
+	const blocks = "▁▂▃▄▅▆▇█"
+	levels := []rune(blocks)
+
+	lo, hi := values[0], values[0]
+	for _, v := range values {
+		lo, hi = min(lo, v), max(hi, v)
+	}
+
+	var b strings.Builder
+	for _, v := range values {
+		level := 0
+		if hi > lo {
+			level = int(float64(v-lo) / float64(hi-lo) * float64(len(levels)-1))
+		}
+		b.WriteRune(levels[level])
+	}
+	fmt.Fprintf(&b, "  %s .. %s", formatDuration(lo), formatDuration(hi))
+	return b.String()
+}